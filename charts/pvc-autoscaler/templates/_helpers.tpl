@@ -16,3 +16,9 @@ app.kubernetes.io/managed-by: {{ .Release.Service }}
 app.kubernetes.io/name: {{ include "pvcautoscaler.fullname" . }}
 app.kubernetes.io/instance: {{ .Release.Name }}
 {{- end }}
+
+{{/* Selector labels for the node agent, distinct from the controller ones */}}
+{{- define "pvcautoscaler.agentSelectorLabels" -}}
+app.kubernetes.io/name: {{ include "pvcautoscaler.fullname" . }}-agent
+app.kubernetes.io/instance: {{ .Release.Name }}
+{{- end }}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/lorenzophys/pvc-autoscaler/internal/agent"
+	log "github.com/sirupsen/logrus"
+)
+
+// runAgent serves the usage of the volumes mounted on the node until a
+// termination signal is received
+func runAgent(address, kubeletDir string, gracePeriod time.Duration, logger *log.Logger) {
+	restConfig, err := newKubeConfig()
+	if err != nil {
+		logger.Fatalf("an error occurred while loading the Kubernetes config: %s", err)
+	}
+	kubeClient, err := newKubeClient(restConfig)
+	if err != nil {
+		logger.Fatalf("an error occurred while creating the Kubernetes client: %s", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	collector := agent.NewCollector(kubeletDir, kubeClient, logger)
+	if err := collector.Start(ctx); err != nil {
+		logger.Fatalf("an error occurred while watching the persistent volumes: %s", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	collector.RegisterHandlers(mux)
+
+	server := &http.Server{
+		Addr:              address,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			logger.Fatalf("agent server error: %s", err)
+		}
+	}()
+	logger.Infof("pvc-autoscaler agent serving the volumes of %s on %s", kubeletDir, address)
+
+	<-ctx.Done()
+
+	logger.Info("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancel()
+	if err := shutdownServers(shutdownCtx, []*http.Server{server}); err != nil {
+		logger.Errorf("failed to shut down the agent server: %v", err)
+	}
+}
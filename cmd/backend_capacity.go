@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// backendCapacity returns the lookup of the PersistentVolume of the PVC and
+// of the CSIStorageCapacities the policy fits the resizes in, with
+// node-local provisioners like TopoLVM a resize that does not fit hangs in
+// Resizing. The resize goes ahead unchanged if they cannot be looked up.
+func (a *PVCAutoscaler) backendCapacity(ctx context.Context, pvc *corev1.PersistentVolumeClaim) func() (*corev1.PersistentVolume, []storagev1.CSIStorageCapacity) {
+	return func() (*corev1.PersistentVolume, []storagev1.CSIStorageCapacity) {
+		pvcId := fmt.Sprintf("%s/%s", pvc.Namespace, pvc.Name)
+		if pvc.Spec.VolumeName == "" {
+			return nil, nil
+		}
+
+		pv, err := a.kubeClient.CoreV1().PersistentVolumes().Get(ctx, pvc.Spec.VolumeName, metav1.GetOptions{})
+		if err != nil {
+			a.logger.Warnf("could not get the PersistentVolume of %s to check the backend capacity: %v", pvcId, err)
+			return nil, nil
+		}
+		capacities, err := a.kubeClient.StorageV1().CSIStorageCapacities(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			a.logger.Warnf("could not list the CSIStorageCapacities to check the backend capacity of %s: %v", pvcId, err)
+			return nil, nil
+		}
+
+		return pv, capacities.Items
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/lorenzophys/pvc-autoscaler/internal/config"
+	"github.com/lorenzophys/pvc-autoscaler/internal/metrics_clients/factory"
+	"github.com/lorenzophys/pvc-autoscaler/internal/provider"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// applyConfig updates the autoscaler settings, rebuilding only the clients
+// and the notifiers whose settings changed. Nothing is changed on error.
+func (a *PVCAutoscaler) applyConfig(cfg *config.Config) error {
+	previous := a.config
+	if previous == nil {
+		previous = &config.Config{}
+	}
+
+	kubeClient, dynamicClient := a.kubeClient, a.dynamicClient
+	if previous.RateLimits.KubeAPIQPS != cfg.RateLimits.KubeAPIQPS || previous.RateLimits.KubeAPIBurst != cfg.RateLimits.KubeAPIBurst {
+		restConfig := rest.CopyConfig(a.restConfig)
+		restConfig.QPS = cfg.RateLimits.KubeAPIQPS
+		restConfig.Burst = cfg.RateLimits.KubeAPIBurst
+
+		var err error
+		kubeClient, err = newKubeClient(restConfig)
+		if err != nil {
+			return fmt.Errorf("could not create the Kubernetes client: %w", err)
+		}
+		dynamicClient, err = newDynamicClient(restConfig)
+		if err != nil {
+			return fmt.Errorf("could not create the Kubernetes dynamic client: %w", err)
+		}
+	}
+
+	metricsClient := a.metricsClient
+	// The kubelet metrics client goes through the Kubernetes client
+	if previous.MetricsClient != cfg.MetricsClient || kubeClient != a.kubeClient {
+		var err error
+		metricsClient, err = factory.MetricsClientFactory(factory.Options{
+			Name:        cfg.MetricsClient.Name,
+			URL:         cfg.MetricsClient.URL,
+			Aggregation: cfg.MetricsClient.Aggregation,
+			MaxAge:      a.maxMetricsAge,
+			KubeClient:  kubeClient,
+			Logger:      a.logger,
+			Observe: func(backend string, pvcs int, err error) {
+				observeMetricsBackend(backend, pvcs, err)
+				if err != nil {
+					a.logger.Warnf("metrics client %s failed, using the other ones: %v", backend, err)
+				}
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("could not create the metrics client: %w", err)
+		}
+		a.logger.Infof("metrics client (%s) ready at address %s", cfg.MetricsClient.Name, cfg.MetricsClient.URL)
+	}
+
+	dispatcher := a.notifier
+	if dispatcher == nil || !reflect.DeepEqual(previous.Notifications, cfg.Notifications) {
+		var err error
+		dispatcher, err = newNotificationDispatcher(cfg.Notifications, a.logger)
+		if err != nil {
+			return err
+		}
+	}
+
+	namespaceSelector, err := toSelector(cfg.Selectors.NamespaceSelector)
+	if err != nil {
+		return fmt.Errorf("invalid namespace selector: %w", err)
+	}
+	pvcSelector, err := toSelector(cfg.Selectors.PVCSelector)
+	if err != nil {
+		return fmt.Errorf("invalid pvc selector: %w", err)
+	}
+
+	a.clientMu.Lock()
+	a.kubeClient = kubeClient
+	a.clientMu.Unlock()
+	a.dynamicClient = dynamicClient
+	a.metricsClient = metricsClient
+	if a.notifier != nil && a.notifier != dispatcher {
+		// The notifications queued before the reload are still sent
+		go a.notifier.Close(context.Background())
+	}
+	a.notifier = dispatcher
+	a.pollingInterval = cfg.PollingInterval.Duration
+	a.reconcileTimeout = cfg.ReconcileTimeout.Duration
+	a.defaultThreshold = cfg.Defaults.Threshold
+	a.defaultIncrease = cfg.Defaults.Increase
+	a.defaultTiers = cfg.Defaults.Tiers
+	a.defaultCeilingFactor = cfg.Defaults.CeilingFactor
+	a.offlineWindow = cfg.Defaults.OfflineExpansionWindow
+	a.resizeWindow = cfg.Defaults.ResizeWindow
+	a.emergencyThreshold = cfg.Defaults.EmergencyThreshold
+	a.freeze = cfg.Freeze
+	a.namespaceSelector = namespaceSelector
+	a.pvcSelector = pvcSelector
+	a.resizeStallTimeout = cfg.Notifications.ResizeStallTimeout.Duration
+	a.workers = cfg.RateLimits.Workers
+	a.delegations = cfg.Delegations
+	a.providerLimits = provider.Builtin().Merge(cfg.ProviderLimits)
+	a.config = cfg
+
+	return nil
+}
+
+// reloadConfig applies the config file if it changed since the last time
+func (a *PVCAutoscaler) reloadConfig() {
+	if a.configWatcher == nil {
+		return
+	}
+
+	cfg, hash := a.configWatcher.Current()
+	if hash == a.configHash {
+		return
+	}
+
+	if err := a.applyConfig(cfg); err != nil {
+		// Retried before each reconciliation, reported once
+		if hash != a.failedConfigHash {
+			a.logger.Errorf("failed to apply config %s: %v", hash, err)
+			configReloadErrors.Inc()
+			a.failedConfigHash = hash
+		}
+		return
+	}
+	a.failedConfigHash = ""
+	a.configHash = hash
+	setConfigHash(hash)
+	a.logger.Infof("applied config %s", hash)
+}
+
+// currentKubeClient returns the Kubernetes client of the last applied config,
+// it is safe to call from the webhook while the config is reloaded
+func (a *PVCAutoscaler) currentKubeClient() kubernetes.Interface {
+	a.clientMu.RLock()
+	defer a.clientMu.RUnlock()
+
+	return a.kubeClient
+}
+
+func toSelector(selector *metav1.LabelSelector) (labels.Selector, error) {
+	if selector == nil {
+		return labels.Everything(), nil
+	}
+
+	return metav1.LabelSelectorAsSelector(selector)
+}
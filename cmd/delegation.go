@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/lorenzophys/pvc-autoscaler/internal/delegation"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// delegateResize sets the new size in the owner of the PVC and lets its
+// operator propagate it. The PVC only gets the annotations, so that it waits
+// with ResizePending until its capacity changes like after a direct resize.
+func (a *PVCAutoscaler) delegateResize(ctx context.Context, pvc *corev1.PersistentVolumeClaim, target delegation.Target, capacityBytes int64, newStorage resource.Quantity, annotations map[string]*string) error {
+	pvcId := fmt.Sprintf("%s/%s", pvc.Namespace, pvc.Name)
+
+	patched, err := delegation.Resize(ctx, a.dynamicClient, target, newStorage)
+	if err != nil {
+		return err
+	}
+	if patched {
+		a.logger.Infof("resize of %s delegated to %s, %s set to %s", pvcId, target, target.Rule.Path, newStorage.String())
+	} else {
+		a.logger.Infof("resize of %s delegated to %s, which already asks for %s or more", pvcId, target, newStorage.String())
+	}
+
+	previousCapacity := strconv.FormatInt(capacityBytes, 10)
+	lastResize := time.Now().UTC().Format(time.RFC3339)
+	annotations[PVCAutoscalerPreviousCapacityAnnotation] = &previousCapacity
+	annotations[PVCAutoscalerLastResizeAnnotation] = &lastResize
+
+	if _, err := a.patchAnnotations(ctx, pvc, annotations); err != nil {
+		return fmt.Errorf("failed to annotate PVC %s: %w", pvcId, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+const metricsNamespace = "pvc_autoscaler"
+
+var (
+	configInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "config_info",
+		Help:      "Hash of the config file currently applied.",
+	}, []string{"hash"})
+
+	configReloadErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "config_reload_errors_total",
+		Help:      "Number of failed attempts to load or apply the config file.",
+	})
+
+	configLastReloadSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "config_last_reload_success_timestamp_seconds",
+		Help:      "Timestamp of the last successful load of the config file.",
+	})
+
+	metricsBackendUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "metrics_backend_up",
+		Help:      "Whether the last fetch of the metrics client backend succeeded.",
+	}, []string{"backend"})
+
+	metricsBackendErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "metrics_backend_errors_total",
+		Help:      "Number of failed fetches of the metrics client backend.",
+	}, []string{"backend"})
+
+	metricsBackendPVCs = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "metrics_backend_pvcs",
+		Help:      "Number of PVCs whose metrics came from the backend in the last fetch.",
+	}, []string{"backend"})
+
+	staleMetrics = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "stale_metrics_total",
+		Help:      "Number of pvc evaluations skipped because their metrics were older than --max-metrics-age.",
+	})
+
+	insufficientBackendCapacity = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "insufficient_backend_capacity_total",
+		Help:      "Number of resizes skipped because the CSIStorageCapacity of the node of the volume could not fit them.",
+	})
+
+	offlineExpansionEvictions = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "offline_expansion_evictions_total",
+		Help:      "Number of pods evicted to complete the offline expansion of the filesystem of their pvc.",
+	})
+)
+
+func newMetricsRegistry() *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		configInfo,
+		configReloadErrors,
+		configLastReloadSuccess,
+		metricsBackendUp,
+		metricsBackendErrors,
+		metricsBackendPVCs,
+		staleMetrics,
+		insufficientBackendCapacity,
+		offlineExpansionEvictions,
+	)
+
+	return registry
+}
+
+func setConfigHash(hash string) {
+	configInfo.Reset()
+	configInfo.WithLabelValues(hash).Set(1)
+}
+
+// observeConfigReload is called by the config watcher after each load attempt
+func observeConfigReload(hash string, err error) {
+	if err != nil {
+		configReloadErrors.Inc()
+		return
+	}
+	configLastReloadSuccess.Set(float64(time.Now().Unix()))
+}
+
+// observeMetricsBackend is called by the metrics client chain after each
+// fetch of a backend
+func observeMetricsBackend(backend string, pvcs int, err error) {
+	if err != nil {
+		metricsBackendUp.WithLabelValues(backend).Set(0)
+		metricsBackendErrors.WithLabelValues(backend).Inc()
+		metricsBackendPVCs.WithLabelValues(backend).Set(0)
+		return
+	}
+	metricsBackendUp.WithLabelValues(backend).Set(1)
+	metricsBackendPVCs.WithLabelValues(backend).Set(float64(pvcs))
+}
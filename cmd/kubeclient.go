@@ -1,20 +1,29 @@
 package main
 
 import (
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 )
 
-func newKubeClient() (*kubernetes.Clientset, error) {
-	config, err := rest.InClusterConfig()
+func newKubeConfig() (*rest.Config, error) {
+	return rest.InClusterConfig()
+}
+
+func newKubeClient(config *rest.Config) (*kubernetes.Clientset, error) {
+	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		return nil, err
 	}
 
-	clientset, err := kubernetes.NewForConfig(config)
+	return clientset, nil
+}
+
+func newDynamicClient(config *rest.Config) (dynamic.Interface, error) {
+	dynamicClient, err := dynamic.NewForConfig(config)
 	if err != nil {
 		return nil, err
 	}
 
-	return clientset, nil
+	return dynamicClient, nil
 }
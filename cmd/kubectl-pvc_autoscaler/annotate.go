@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/lorenzophys/pvc-autoscaler/internal/policy"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func runEnable(ctx context.Context, args []string, out io.Writer) error {
+	var o options
+	var threshold, increase, ceiling, tiers, attributesClasses string
+
+	fs := flag.NewFlagSet("enable", flag.ContinueOnError)
+	o.addFlags(fs)
+	fs.StringVar(&threshold, "threshold", "", "specify the threshold, e.g. 80%")
+	fs.StringVar(&increase, "increase", "", "specify the increase, e.g. 20%")
+	fs.StringVar(&ceiling, "ceiling", "", "specify the ceiling, e.g. 100Gi or 4x the original size")
+	fs.StringVar(&attributesClasses, "attributes-classes", "", "specify the size:class bands of the volume attributes classes, e.g. 0:gp3-standard,1Ti:gp3-fast")
+	fs.StringVar(&tiers, "tiers", "", "specify the threshold:increase tiers replacing the threshold and the increase, e.g. 75%:10%,90%:30%")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return errors.New("enable requires exactly one pvc name")
+	}
+
+	annotations := map[string]string{policy.EnabledAnnotation: "true"}
+
+	var errs []error
+	if threshold != "" {
+		if _, err := policy.ParsePercentage(threshold); err != nil {
+			errs = append(errs, fmt.Errorf("invalid threshold: %w", err))
+		}
+		annotations[policy.ThresholdAnnotation] = threshold
+	}
+	if increase != "" {
+		if _, err := policy.ParsePercentage(increase); err != nil {
+			errs = append(errs, fmt.Errorf("invalid increase: %w", err))
+		}
+		annotations[policy.IncreaseAnnotation] = increase
+	}
+	if factor, ok := strings.CutSuffix(ceiling, "x"); ok {
+		if _, err := policy.ParseCeilingFactor(factor); err != nil {
+			errs = append(errs, fmt.Errorf("invalid ceiling: %w", err))
+		}
+		annotations[policy.CeilingAnnotation] = ceiling
+	} else if ceiling != "" {
+		quantity, err := resource.ParseQuantity(ceiling)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid ceiling: %w", err))
+		} else if quantity.Sign() <= 0 {
+			errs = append(errs, errors.New("invalid ceiling: it must be positive"))
+		}
+		annotations[policy.CeilingAnnotation] = ceiling
+	}
+	if tiers != "" {
+		if _, err := policy.ParseTiers(tiers); err != nil {
+			errs = append(errs, fmt.Errorf("invalid tiers: %w", err))
+		}
+		annotations[policy.TiersAnnotation] = tiers
+	}
+	if attributesClasses != "" {
+		if _, err := policy.ParseAttributesClassBands(attributesClasses); err != nil {
+			errs = append(errs, fmt.Errorf("invalid attributes classes: %w", err))
+		}
+		annotations[policy.AttributesClassesAnnotation] = attributesClasses
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	return annotatePVC(ctx, &o, positional[0], annotations, out)
+}
+
+func runDisable(ctx context.Context, args []string, out io.Writer) error {
+	var o options
+
+	fs := flag.NewFlagSet("disable", flag.ContinueOnError)
+	o.addFlags(fs)
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return errors.New("disable requires exactly one pvc name")
+	}
+
+	// An explicit "false" is needed to opt out of a StorageClass enabling its PVCs
+	return annotatePVC(ctx, &o, positional[0], map[string]string{policy.EnabledAnnotation: "false"}, out)
+}
+
+func annotatePVC(ctx context.Context, o *options, name string, annotations map[string]string, out io.Writer) error {
+	ctx, cancel := context.WithTimeout(ctx, o.timeout)
+	defer cancel()
+
+	kubeClient, namespace, err := newKubeClient(o)
+	if err != nil {
+		return err
+	}
+
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{"annotations": annotations},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = kubeClient.CoreV1().PersistentVolumeClaims(namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("could not annotate the PersistentVolumeClaim: %w", err)
+	}
+
+	fmt.Fprintf(out, "persistentvolumeclaim/%s annotated\n", name)
+	return nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	clients "github.com/lorenzophys/pvc-autoscaler/internal/metrics_clients/clients"
+	"github.com/lorenzophys/pvc-autoscaler/internal/policy"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// The explanation of the reasons that are not self-explanatory
+var reasonDescriptions = map[policy.Reason]string{
+	policy.ReasonMetricsStale:             "the metrics are older than --max-metrics-age, the kubelet of the node probably stopped reporting",
+	policy.ReasonCapacityNotSet:           "the capacity of the pvc is not set yet, it is probably not bound",
+	policy.ReasonCapacityZero:             "the capacity of the pvc is zero",
+	policy.ReasonResizePending:            "the last resize has not been accepted yet, no new resize is requested until the capacity changes",
+	policy.ReasonCeilingReached:           "the pvc already reached its ceiling, it cannot grow anymore",
+	policy.ReasonOriginalSizeNotRecorded:  "the ceiling is a multiple of the original size, which the controller has not recorded in the original-size annotation yet",
+	policy.ReasonBelowThreshold:           "the used bytes are below the threshold, nothing to do",
+	policy.ReasonThresholdExceeded:        "the used bytes reached the threshold, the pvc is resized at the next reconciliation",
+	policy.ReasonResizeRequested:          "the resize request annotation is applied at the next reconciliation if it fits in the resource quotas",
+	policy.ReasonResizeRequestRejected:    "the resize request annotation is invalid, it is removed at the next reconciliation",
+	policy.ReasonProviderCooldown:         "the provider of the volume allows one modification per cooldown, the resize is deferred until its end",
+	policy.ReasonInvalidAttributesClasses: "the attributes classes should be a list of size:class with increasing sizes, e.g. 0:gp3-standard,1Ti:gp3-fast",
+	policy.ReasonInvalidTiers:             "the tiers should be a list of threshold:increase, optionally followed by :nocooldown, with increasing thresholds, e.g. 75%:10%,90%:30%,97%:50%:nocooldown",
+	policy.ReasonOutsideResizeWindow:      "the resizes are restricted to the resize windows, the resize is deferred until the next one",
+	policy.ReasonResizeFrozen:             "the resizes are frozen, the resize is deferred until the end of the freeze unless the usage reaches the emergency threshold",
+	policy.ReasonInvalidResizeWindow:      "the resize window should be a cron schedule followed by a duration, e.g. \"CRON_TZ=Europe/Paris 0 20 * * mon-fri 10h\"",
+}
+
+func runExplain(ctx context.Context, args []string, out io.Writer) error {
+	var o options
+
+	fs := flag.NewFlagSet("explain", flag.ContinueOnError)
+	o.addFlags(fs)
+	o.addPolicyFlags(fs)
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return errors.New("explain requires exactly one pvc name")
+	}
+
+	if err := o.loadConfig(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, o.timeout)
+	defer cancel()
+
+	kubeClient, namespace, err := newKubeClient(&o)
+	if err != nil {
+		return err
+	}
+
+	return explain(ctx, out, &o, kubeClient, namespace, positional[0], func(ctx context.Context) (map[types.NamespacedName]*clients.PVCMetrics, error) {
+		return fetchMetrics(ctx, &o, kubeClient)
+	})
+}
+
+// explain prints the effective policy of the pvc and the decision of the
+// autoscaler, the metrics are only fetched when the pvc is managed
+func explain(ctx context.Context, out io.Writer, o *options, kubeClient kubernetes.Interface, namespace, name string, fetchMetrics func(context.Context) (map[types.NamespacedName]*clients.PVCMetrics, error)) error {
+	pvc, err := kubeClient.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("could not get the PersistentVolumeClaim: %w", err)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintf(w, "PVC:\t%s/%s\n", pvc.Namespace, pvc.Name)
+
+	var sc *storagev1.StorageClass
+	if pvc.Spec.StorageClassName != nil {
+		sc, err = kubeClient.StorageV1().StorageClasses().Get(ctx, *pvc.Spec.StorageClassName, metav1.GetOptions{})
+		if err != nil {
+			fmt.Fprintf(w, "Decision:\t%s\n", policy.ReasonStorageClassNotFound)
+			fmt.Fprintf(w, "Error:\t%s\n", err)
+			return nil
+		}
+		fmt.Fprintf(w, "StorageClass:\t%s\n", sc.Name)
+	}
+
+	if !policy.Enabled(pvc, sc) {
+		fmt.Fprintf(w, "Managed:\tno, set the %s annotation to \"true\" on the pvc or its storageclass\n", policy.EnabledAnnotation)
+		return nil
+	}
+	if !o.pvcLabels.Matches(labels.Set(pvc.Labels)) {
+		fmt.Fprintf(w, "Managed:\tno, the pvc does not match the pvc selector %s\n", o.pvcLabels)
+		return nil
+	}
+	namespaces, err := selectedNamespaces(ctx, kubeClient, o.namespaceLabels)
+	if err != nil {
+		return err
+	}
+	if namespaces != nil && !namespaces[pvc.Namespace] {
+		fmt.Fprintf(w, "Managed:\tno, the namespace does not match the namespace selector %s\n", o.namespaceLabels)
+		return nil
+	}
+	fmt.Fprintln(w, "Managed:\tyes")
+
+	pvcsMetrics, err := fetchMetrics(ctx)
+	if err != nil {
+		return err
+	}
+	metrics := pvcsMetrics[types.NamespacedName{Namespace: pvc.Namespace, Name: pvc.Name}]
+
+	cfg := o.policyConfig()
+	cfg.Backend = backendCapacity(ctx, kubeClient, pvc)
+	result := policy.Evaluate(pvc, sc, metrics, cfg)
+
+	fmt.Fprintf(w, "Threshold:\t%s\n", orDash(result.Policy.Threshold))
+	fmt.Fprintf(w, "Increase:\t%s\n", orDash(result.Policy.Increase))
+	fmt.Fprintf(w, "Ceiling:\t%s\n", formatCeiling(pvc, sc, o.defaultCeilingFactor))
+	if limits := result.Limits; limits != nil {
+		fmt.Fprintf(w, "Provider limits:\tmax size %s, min step %s, cooldown %s\n", orDash(formatQuantity(limits.MaxSize)), orDash(formatQuantity(limits.MinStep)), limits.Cooldown.Duration)
+	}
+	if result.Policy.AttributesClasses != "" {
+		fmt.Fprintf(w, "Attributes classes:\t%s\n", result.Policy.AttributesClasses)
+	}
+	if result.Policy.ResizeWindow != "" {
+		fmt.Fprintf(w, "Resize window:\t%s\n", result.Policy.ResizeWindow)
+	}
+	if result.Policy.EmergencyThreshold != "" {
+		fmt.Fprintf(w, "Emergency threshold:\t%s\n", result.Policy.EmergencyThreshold)
+	}
+	if result.Policy.Tiers != "" {
+		fmt.Fprintf(w, "Tiers:\t%s\n", result.Policy.Tiers)
+		fmt.Fprintf(w, "Reached tier:\t%s\n", orDash(result.Tier))
+	}
+	if metrics != nil {
+		fmt.Fprintf(w, "Usage:\t%s of %s (%s)\n", formatBytes(metrics.VolumeUsedBytes), formatBytes(metrics.VolumeCapacityBytes), formatUsage(metrics))
+	} else if !o.hasMetricsClient() {
+		fmt.Fprintln(w, "Usage:\tunknown, set --metrics-client-url to fetch the metrics")
+	}
+	if result.ThresholdBytes != 0 {
+		fmt.Fprintf(w, "Threshold bytes:\t%s\n", formatBytes(result.ThresholdBytes))
+	}
+	fmt.Fprintf(w, "Last resize:\t%s\n", formatLastResize(pvc))
+
+	fmt.Fprintf(w, "Decision:\t%s\n", result.Reason())
+	if err := result.Err(); err != nil {
+		fmt.Fprintf(w, "Error:\t%s\n", err)
+	}
+	if description, ok := reasonDescriptions[result.Reason()]; ok {
+		fmt.Fprintf(w, "\t%s\n", description)
+	}
+	if !result.NextResizeAt.IsZero() {
+		fmt.Fprintf(w, "\tnext resize possible at %s\n", result.NextResizeAt.Format(time.RFC3339))
+	}
+	if resize, ok := result.Decision.(policy.Resize); ok {
+		fmt.Fprintf(w, "\twould resize from %s to %s\n", formatBytes(result.CurrentSizeBytes), resize.NewSize.String())
+		if resize.NoCooldown {
+			fmt.Fprintf(w, "\tbypassing the resize windows or the freeze because the usage reached the nocooldown tier %s\n", resize.Tier)
+		} else if resize.Emergency {
+			fmt.Fprintln(w, "\tbypassing the resize windows or the freeze because the usage reached the emergency threshold")
+		}
+		if resize.AttributesClass != "" {
+			fmt.Fprintf(w, "\tand switch to the attributes class %s\n", resize.AttributesClass)
+		}
+		if snapshotClass := pvc.Annotations[policy.SnapshotAnnotation]; snapshotClass != "" {
+			fmt.Fprintf(w, "\ta snapshot of class %s is taken first\n", snapshotClass)
+		}
+	}
+
+	return nil
+}
+
+func formatBytes(value int64) string {
+	return resource.NewQuantity(value, resource.BinarySI).String()
+}
+
+func formatQuantity(quantity resource.Quantity) string {
+	if quantity.IsZero() {
+		return ""
+	}
+
+	return quantity.String()
+}
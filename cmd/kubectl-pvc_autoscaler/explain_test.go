@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	clients "github.com/lorenzophys/pvc-autoscaler/internal/metrics_clients/clients"
+	"github.com/lorenzophys/pvc-autoscaler/internal/policy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestPVC(namespace, name string, annotations, labels map[string]string) *corev1.PersistentVolumeClaim {
+	storageClassName := "standard"
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   namespace,
+			Name:        name,
+			Annotations: annotations,
+			Labels:      labels,
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			StorageClassName: &storageClassName,
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")},
+			},
+		},
+		Status: corev1.PersistentVolumeClaimStatus{
+			Phase:    corev1.ClaimBound,
+			Capacity: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")},
+		},
+	}
+}
+
+func newTestStorageClass() *storagev1.StorageClass {
+	expandable := true
+	return &storagev1.StorageClass{
+		ObjectMeta:           metav1.ObjectMeta{Name: "standard"},
+		Provisioner:          "csi.example.com",
+		AllowVolumeExpansion: &expandable,
+	}
+}
+
+func newTestNamespace(name string, labels map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+// newTestMetrics returns the metrics of a 10Gi volume with usedGi used
+func newTestMetrics(usedGi float64) *clients.PVCMetrics {
+	return &clients.PVCMetrics{
+		VolumeUsedBytes:     int64(usedGi * (1 << 30)),
+		VolumeCapacityBytes: 10 << 30,
+	}
+}
+
+// newTestOptions parses the flags of the status and explain commands and
+// loads the config like they do, config is the content of the config file
+func newTestOptions(t *testing.T, args []string, config string) *options {
+	var o options
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	o.addFlags(fs)
+	o.addPolicyFlags(fs)
+
+	if config != "" {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		require.NoError(t, os.WriteFile(path, []byte(config), 0o600))
+		args = append(args, "--config", path)
+	}
+	_, err := parseArgs(fs, args)
+	require.NoError(t, err)
+	require.NoError(t, o.loadConfig())
+
+	return &o
+}
+
+func TestExplain(t *testing.T) {
+	enabled := map[string]string{policy.EnabledAnnotation: "true", policy.CeilingAnnotation: "20Gi"}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		labels      map[string]string
+		args        []string
+		config      string
+		usedGi      float64
+		expected    []string
+	}{
+		{
+			name:     "not managed",
+			usedGi:   9,
+			expected: []string{"Managed:", "no, set the " + policy.EnabledAnnotation},
+		},
+		{
+			name:        "below the threshold",
+			annotations: enabled,
+			usedGi:      5,
+			expected:    []string{"Managed:", "yes", "Threshold:", "80%", "Decision:", "BelowThreshold"},
+		},
+		{
+			name:        "threshold exceeded",
+			annotations: enabled,
+			usedGi:      9,
+			expected:    []string{"Decision:", "ThresholdExceeded", "would resize from 10Gi to 12Gi"},
+		},
+		{
+			name:        "excluded by the pvc selector",
+			annotations: enabled,
+			labels:      map[string]string{"tier": "cache"},
+			args:        []string{"--pvc-selector", "!tier"},
+			usedGi:      9,
+			expected:    []string{"no, the pvc does not match the pvc selector !tier"},
+		},
+		{
+			name:        "excluded by the namespace selector of the config",
+			annotations: enabled,
+			config:      "selectors:\n  namespaceSelector:\n    matchLabels:\n      autoscaled: \"true\"\n",
+			usedGi:      9,
+			expected:    []string{"no, the namespace does not match the namespace selector autoscaled=true"},
+		},
+		{
+			name:        "config defaults override the flags",
+			annotations: enabled,
+			args:        []string{"--default-threshold", "70%"},
+			config:      "defaults:\n  threshold: 95%\n  increase: 50%\n",
+			usedGi:      9,
+			expected:    []string{"Threshold:", "95%", "Increase:", "50%", "BelowThreshold"},
+		},
+		{
+			name:        "config provider limits",
+			annotations: enabled,
+			config:      "providerLimits:\n  csi.example.com:\n    maxSize: 11Gi\n",
+			usedGi:      9,
+			expected:    []string{"Provider limits:", "max size 11Gi", "would resize from 10Gi to 11Gi"},
+		},
+		{
+			name:        "config freeze",
+			annotations: enabled,
+			config:      "freeze: true\n",
+			usedGi:      9,
+			expected:    []string{"ResizeFrozen"},
+		},
+		{
+			name:        "emergency during a freeze",
+			annotations: enabled,
+			config:      "freeze: true\ndefaults:\n  threshold: 80%\n  increase: 20%\n  emergencyThreshold: 95%\n",
+			usedGi:      9.6,
+			expected:    []string{"Emergency threshold:", "because the usage reached the emergency threshold"},
+		},
+		{
+			name:        "nocooldown tier during a freeze",
+			annotations: map[string]string{policy.EnabledAnnotation: "true", policy.CeilingAnnotation: "20Gi", policy.TiersAnnotation: "75%:10%,97%:50%:nocooldown"},
+			config:      "freeze: true\n",
+			usedGi:      9.8,
+			expected:    []string{"Reached tier:", "because the usage reached the nocooldown tier 97%:50%:nocooldown"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := newTestOptions(t, tt.args, tt.config)
+			kubeClient := fake.NewSimpleClientset(
+				newTestStorageClass(),
+				newTestNamespace("default", nil),
+				newTestPVC("default", "data", tt.annotations, tt.labels),
+			)
+			fetchMetrics := func(context.Context) (map[types.NamespacedName]*clients.PVCMetrics, error) {
+				return map[types.NamespacedName]*clients.PVCMetrics{{Namespace: "default", Name: "data"}: newTestMetrics(tt.usedGi)}, nil
+			}
+
+			var out bytes.Buffer
+			err := explain(context.TODO(), &out, o, kubeClient, "default", "data", fetchMetrics)
+
+			assert.NoError(t, err)
+			for _, expected := range tt.expected {
+				assert.Contains(t, out.String(), expected)
+			}
+		})
+	}
+
+	t.Run("emergency is not reported as a nocooldown tier", func(t *testing.T) {
+		o := newTestOptions(t, nil, "freeze: true\ndefaults:\n  threshold: 80%\n  increase: 20%\n  emergencyThreshold: 95%\n")
+		kubeClient := fake.NewSimpleClientset(newTestStorageClass(), newTestPVC("default", "data", enabled, nil))
+		fetchMetrics := func(context.Context) (map[types.NamespacedName]*clients.PVCMetrics, error) {
+			return map[types.NamespacedName]*clients.PVCMetrics{{Namespace: "default", Name: "data"}: newTestMetrics(9.6)}, nil
+		}
+
+		var out bytes.Buffer
+		err := explain(context.TODO(), &out, o, kubeClient, "default", "data", fetchMetrics)
+
+		assert.NoError(t, err)
+		assert.NotContains(t, out.String(), "nocooldown")
+	})
+
+	t.Run("missing pvc", func(t *testing.T) {
+		o := newTestOptions(t, nil, "")
+
+		err := explain(context.TODO(), &bytes.Buffer{}, o, fake.NewSimpleClientset(), "default", "data", nil)
+
+		assert.ErrorContains(t, err, "could not get the PersistentVolumeClaim")
+	})
+
+	t.Run("invalid config file", func(t *testing.T) {
+		var o options
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		o.addFlags(fs)
+		o.addPolicyFlags(fs)
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		require.NoError(t, os.WriteFile(path, []byte("defaults:\n  threshold: NaN%\n"), 0o600))
+		_, err := parseArgs(fs, []string{"--config", path})
+		require.NoError(t, err)
+
+		assert.ErrorContains(t, o.loadConfig(), "defaults.threshold")
+	})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	clients "github.com/lorenzophys/pvc-autoscaler/internal/metrics_clients/clients"
+	"github.com/lorenzophys/pvc-autoscaler/internal/metrics_clients/factory"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// newKubeClient loads the kubeconfig like kubectl does and returns the
+// client with the namespace to use
+func newKubeClient(o *options) (kubernetes.Interface, string, error) {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = o.kubeconfig
+
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, &clientcmd.ConfigOverrides{
+		CurrentContext: o.context,
+	})
+
+	namespace := o.namespace
+	if namespace == "" {
+		var err error
+		namespace, _, err = clientConfig.Namespace()
+		if err != nil {
+			return nil, "", fmt.Errorf("could not get the namespace from the kubeconfig: %w", err)
+		}
+	}
+
+	restConfig, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, "", fmt.Errorf("could not load the kubeconfig: %w", err)
+	}
+
+	kubeClient, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not create the Kubernetes client: %w", err)
+	}
+
+	return kubeClient, namespace, nil
+}
+
+// hasMetricsClient is false when no metrics client URL is set, only the
+// kubelet client does not need one
+func (o *options) hasMetricsClient() bool {
+	return o.metricsClientURL != "" || o.metricsClient == "kubelet"
+}
+
+// fetchMetrics returns nil without error when there is no metrics client
+func fetchMetrics(ctx context.Context, o *options, kubeClient kubernetes.Interface) (map[types.NamespacedName]*clients.PVCMetrics, error) {
+	if !o.hasMetricsClient() {
+		return nil, nil
+	}
+
+	metricsClient, err := factory.MetricsClientFactory(factory.Options{
+		Name:        o.metricsClient,
+		URL:         o.metricsClientURL,
+		Aggregation: o.metricsAggregation,
+		MaxAge:      o.maxMetricsAge,
+		KubeClient:  kubeClient,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create the metrics client: %w", err)
+	}
+
+	pvcsMetrics, err := metricsClient.FetchPVCsMetrics(ctx, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch the PersistentVolumeClaims metrics: %w", err)
+	}
+
+	return pvcsMetrics, nil
+}
+
+// backendCapacity returns the volume and the CSIStorageCapacities the resize
+// of the pvc is fitted in, like the autoscaler does. The resize is not fitted
+// when they cannot be read.
+func backendCapacity(ctx context.Context, kubeClient kubernetes.Interface, pvc *corev1.PersistentVolumeClaim) func() (*corev1.PersistentVolume, []storagev1.CSIStorageCapacity) {
+	return func() (*corev1.PersistentVolume, []storagev1.CSIStorageCapacity) {
+		if pvc.Spec.VolumeName == "" {
+			return nil, nil
+		}
+
+		pv, err := kubeClient.CoreV1().PersistentVolumes().Get(ctx, pvc.Spec.VolumeName, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil
+		}
+		capacities, err := kubeClient.StorageV1().CSIStorageCapacities(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, nil
+		}
+
+		return pv, capacities.Items
+	}
+}
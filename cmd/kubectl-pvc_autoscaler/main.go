@@ -0,0 +1,249 @@
+// kubectl-pvc_autoscaler is a kubectl plugin to inspect and configure the
+// PVCs managed by the autoscaler. Install it anywhere in the PATH and run it
+// as "kubectl pvc-autoscaler".
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/lorenzophys/pvc-autoscaler/internal/config"
+	"github.com/lorenzophys/pvc-autoscaler/internal/policy"
+	"github.com/lorenzophys/pvc-autoscaler/internal/provider"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	DefaultThreshold          = "80%"
+	DefaultIncrease           = "20%"
+	DefaultMetricsProvider    = "prometheus"
+	DefaultMetricsAggregation = "max"
+	DefaultTimeout            = 30 * time.Second
+
+	// The defaults of the autoscaler settings the plugin does not use, the
+	// config file is validated with them
+	DefaultReconcileTimeOut = 1 * time.Minute
+	DefaultResizeStall      = 30 * time.Minute
+	DefaultWorkers          = 1
+	DefaultKubeAPIQPS       = 5
+	DefaultKubeAPIBurst     = 10
+)
+
+const usage = `kubectl pvc-autoscaler inspects and configures the PVCs managed by pvc-autoscaler.
+
+Usage:
+  kubectl pvc-autoscaler status [-n namespace | -A] [--config config.yaml]
+  kubectl pvc-autoscaler explain <pvc> [-n namespace] [--config config.yaml]
+  kubectl pvc-autoscaler enable <pvc> [-n namespace] [--threshold 80%%] [--increase 20%%] [--ceiling 100Gi] [--tiers 75%%:10%%,90%%:30%%]
+  kubectl pvc-autoscaler disable <pvc> [-n namespace]
+  kubectl pvc-autoscaler simulate <file> [--threshold 80%%] [--increase 20%%] [--ceiling 100Gi] [--tiers 75%%:10%%,90%%:30%%]
+
+Run "kubectl pvc-autoscaler <command> -h" for the options of a command.
+`
+
+// options are shared by all the commands
+type options struct {
+	kubeconfig       string
+	context          string
+	namespace        string
+	metricsClient    string
+	metricsClientURL string
+	// metricsAggregation combines the duplicate prometheus series of a pvc
+	metricsAggregation string
+	defaultThreshold   string
+	defaultIncrease    string
+	defaultTiers       string
+	// defaultCeilingFactor bounds the pvcs without ceiling to a multiple of
+	// their original size
+	defaultCeilingFactor float64
+	// defaultResizeWindow, defaultEmergencyThreshold and freeze defer the
+	// resizes
+	defaultResizeWindow       string
+	defaultEmergencyThreshold string
+	freeze                    bool
+	maxMetricsAge             time.Duration
+	timeout                   time.Duration
+	// configFile is the config file of the autoscaler, it overrides the
+	// policy flags
+	configFile        string
+	namespaceSelector string
+	pvcSelector       string
+
+	// Set by loadConfig
+	providerLimits  provider.Table
+	namespaceLabels labels.Selector
+	pvcLabels       labels.Selector
+}
+
+func (o *options) addFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.kubeconfig, "kubeconfig", "", "specify the kubeconfig file to use")
+	fs.StringVar(&o.context, "context", "", "specify the kubeconfig context to use")
+	fs.StringVar(&o.namespace, "namespace", "", "specify the namespace of the pvcs (default: the namespace of the context)")
+	fs.StringVar(&o.namespace, "n", "", "shorthand for --namespace")
+	fs.DurationVar(&o.timeout, "timeout", DefaultTimeout, "specify the time after which the command is considered failed")
+}
+
+// addPolicyFlags adds the flags mirroring the autoscaler settings that the
+// decision depends on, they should match the ones of the running autoscaler
+func (o *options) addPolicyFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.metricsClient, "metrics-client", DefaultMetricsProvider, "specify the metrics client to use to query volume stats")
+	fs.StringVar(&o.metricsClientURL, "metrics-client-url", "", "specify the metrics client URL to use to query volume stats, e.g. a port-forwarded prometheus")
+	fs.StringVar(&o.metricsAggregation, "metrics-aggregation", DefaultMetricsAggregation, "specify how the duplicate prometheus series of a pvc are combined, max, min or avg")
+	fs.StringVar(&o.defaultThreshold, "default-threshold", DefaultThreshold, "specify the threshold used when neither the pvc nor its storageclass set one")
+	fs.StringVar(&o.defaultIncrease, "default-increase", DefaultIncrease, "specify the increase used when neither the pvc nor its storageclass set one")
+	fs.StringVar(&o.defaultTiers, "default-tiers", "", "specify the tiers used when neither the pvc nor its storageclass set tiers, a threshold or an increase")
+	fs.Float64Var(&o.defaultCeilingFactor, "default-ceiling-factor", 0, "specify the multiple of the original size used as ceiling when neither the pvc nor its storageclass set one, 0 disables it")
+	fs.StringVar(&o.defaultResizeWindow, "default-resize-window", "", "specify the resize windows used when neither the pvc nor its storageclass set one, empty allows any time")
+	fs.StringVar(&o.defaultEmergencyThreshold, "default-emergency-threshold", "", "specify the usage above which the resize windows and the freeze are bypassed when neither the pvc nor its storageclass set one")
+	fs.BoolVar(&o.freeze, "freeze", false, "evaluate as if the resizes were frozen")
+	fs.DurationVar(&o.maxMetricsAge, "max-metrics-age", 0, "specify the age above which the metrics of a pvc are stale, 0 disables the check")
+	fs.StringVar(&o.namespaceSelector, "namespace-selector", "", "specify the label selector restricting the namespaces of the managed pvcs")
+	fs.StringVar(&o.pvcSelector, "pvc-selector", "", "specify the label selector restricting the managed pvcs")
+	fs.StringVar(&o.configFile, "config", "", "specify the config file of the autoscaler, it overrides the other flags except --metrics-client-url")
+}
+
+// loadConfig builds the autoscaler config from the policy flags and the
+// config file like the autoscaler does, and applies it to the options. The
+// metrics client URL of the autoscaler is usually not reachable from outside
+// of the cluster, the one of the flags is kept.
+func (o *options) loadConfig() error {
+	base := config.Config{
+		MetricsClient:    config.MetricsClientConfig{Name: o.metricsClient, URL: o.metricsClientURL, Aggregation: o.metricsAggregation},
+		PollingInterval:  metav1.Duration{Duration: DefaultPollingInterval},
+		ReconcileTimeout: metav1.Duration{Duration: DefaultReconcileTimeOut},
+		Defaults:         config.DefaultsConfig{Threshold: o.defaultThreshold, Increase: o.defaultIncrease, Tiers: o.defaultTiers, CeilingFactor: o.defaultCeilingFactor, ResizeWindow: o.defaultResizeWindow, EmergencyThreshold: o.defaultEmergencyThreshold},
+		Notifications:    config.NotificationsConfig{ResizeStallTimeout: metav1.Duration{Duration: DefaultResizeStall}},
+		RateLimits:       config.RateLimitsConfig{Workers: DefaultWorkers, KubeAPIQPS: DefaultKubeAPIQPS, KubeAPIBurst: DefaultKubeAPIBurst},
+		Freeze:           o.freeze,
+	}
+	if o.namespaceSelector != "" {
+		selector, err := metav1.ParseToLabelSelector(o.namespaceSelector)
+		if err != nil {
+			return fmt.Errorf("invalid namespace selector: %w", err)
+		}
+		base.Selectors.NamespaceSelector = selector
+	}
+	if o.pvcSelector != "" {
+		selector, err := metav1.ParseToLabelSelector(o.pvcSelector)
+		if err != nil {
+			return fmt.Errorf("invalid pvc selector: %w", err)
+		}
+		base.Selectors.PVCSelector = selector
+	}
+
+	cfg := &base
+	if o.configFile != "" {
+		var err error
+		cfg, _, err = config.Load(o.configFile, base)
+		if err != nil {
+			return fmt.Errorf("could not load the config file: %w", err)
+		}
+	}
+
+	namespaceLabels, err := toSelector(cfg.Selectors.NamespaceSelector)
+	if err != nil {
+		return fmt.Errorf("invalid namespace selector: %w", err)
+	}
+	pvcLabels, err := toSelector(cfg.Selectors.PVCSelector)
+	if err != nil {
+		return fmt.Errorf("invalid pvc selector: %w", err)
+	}
+
+	o.metricsClient = cfg.MetricsClient.Name
+	o.metricsAggregation = cfg.MetricsClient.Aggregation
+	o.defaultThreshold = cfg.Defaults.Threshold
+	o.defaultIncrease = cfg.Defaults.Increase
+	o.defaultTiers = cfg.Defaults.Tiers
+	o.defaultCeilingFactor = cfg.Defaults.CeilingFactor
+	o.defaultResizeWindow = cfg.Defaults.ResizeWindow
+	o.defaultEmergencyThreshold = cfg.Defaults.EmergencyThreshold
+	o.freeze = cfg.Freeze
+	o.providerLimits = provider.Builtin().Merge(cfg.ProviderLimits)
+	o.namespaceLabels = namespaceLabels
+	o.pvcLabels = pvcLabels
+
+	return nil
+}
+
+func toSelector(selector *metav1.LabelSelector) (labels.Selector, error) {
+	if selector == nil {
+		return labels.Everything(), nil
+	}
+
+	return metav1.LabelSelectorAsSelector(selector)
+}
+
+// policyConfig returns the config the decision depends on, the backend
+// capacity is set by the caller
+func (o *options) policyConfig() policy.Config {
+	providerLimits := o.providerLimits
+	if providerLimits == nil {
+		providerLimits = provider.Builtin()
+	}
+
+	return policy.Config{
+		DefaultThreshold:          o.defaultThreshold,
+		DefaultIncrease:           o.defaultIncrease,
+		DefaultTiers:              o.defaultTiers,
+		DefaultCeilingFactor:      o.defaultCeilingFactor,
+		ProviderLimits:            providerLimits,
+		DefaultResizeWindow:       o.defaultResizeWindow,
+		DefaultEmergencyThreshold: o.defaultEmergencyThreshold,
+		Freeze:                    o.freeze,
+		MaxMetricsAge:             o.maxMetricsAge,
+		Now:                       time.Now(),
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintf(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var run func(ctx context.Context, args []string, out io.Writer) error
+	switch os.Args[1] {
+	case "status":
+		run = runStatus
+	case "explain":
+		run = runExplain
+	case "enable":
+		run = runEnable
+	case "disable":
+		run = runDisable
+	case "simulate":
+		run = runSimulate
+	case "-h", "--help", "help":
+		fmt.Fprintf(os.Stdout, usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n"+usage, os.Args[1])
+		os.Exit(2)
+	}
+
+	if err := run(context.Background(), os.Args[2:], os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// parseArgs parses the flags of a command allowing them both before and
+// after the positional arguments, as kubectl does
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
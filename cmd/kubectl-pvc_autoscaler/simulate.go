@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/lorenzophys/pvc-autoscaler/internal/policy"
+	"github.com/lorenzophys/pvc-autoscaler/internal/simulator"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	DefaultPollingInterval = 30 * time.Second
+	DefaultResizeLatency   = 2 * time.Minute
+)
+
+func runSimulate(_ context.Context, args []string, out io.Writer) error {
+	var (
+		o               options
+		format          string
+		threshold       string
+		increase        string
+		ceiling         string
+		tiers           string
+		resizeWindow    string
+		emergency       string
+		initialSize     string
+		pollingInterval time.Duration
+		resizeLatency   time.Duration
+		cooldown        time.Duration
+	)
+
+	fs := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	fs.StringVar(&o.defaultThreshold, "default-threshold", DefaultThreshold, "specify the threshold used when --threshold is not set")
+	fs.StringVar(&o.defaultIncrease, "default-increase", DefaultIncrease, "specify the increase used when --increase is not set")
+	fs.StringVar(&o.defaultTiers, "default-tiers", "", "specify the tiers used when none of --tiers, --threshold and --increase are set")
+	fs.Float64Var(&o.defaultCeilingFactor, "default-ceiling-factor", 0, "specify the multiple of the initial size used as ceiling when --ceiling is not set, 0 disables it")
+	fs.StringVar(&format, "format", "", "specify the format of the file, csv or prometheus (default: from the file extension)")
+	fs.StringVar(&threshold, "threshold", "", "specify the threshold annotation of the simulated pvcs")
+	fs.StringVar(&increase, "increase", "", "specify the increase annotation of the simulated pvcs")
+	fs.StringVar(&ceiling, "ceiling", "", "specify the ceiling annotation of the simulated pvcs")
+	fs.StringVar(&tiers, "tiers", "", "specify the tiers annotation of the simulated pvcs")
+	fs.StringVar(&resizeWindow, "resize-window", "", "specify the resize window annotation of the simulated pvcs, evaluated at the time of the samples")
+	fs.StringVar(&emergency, "emergency-threshold", "", "specify the emergency threshold annotation of the simulated pvcs")
+	fs.StringVar(&initialSize, "initial-size", "", "specify the initial size of the pvcs whose capacity is not in the file")
+	fs.DurationVar(&pollingInterval, "polling-interval", DefaultPollingInterval, "specify the polling interval of the autoscaler, 0 evaluates every sample")
+	fs.DurationVar(&resizeLatency, "resize-latency", DefaultResizeLatency, "specify how long the csi driver takes to expand a volume")
+	fs.DurationVar(&cooldown, "cooldown", 0, "specify the minimum time between two modifications of a volume, e.g. 6h on EBS")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return errors.New("simulate requires exactly one file")
+	}
+
+	series, err := readSeries(positional[0], format)
+	if err != nil {
+		return err
+	}
+
+	var initialSizeBytes int64
+	if initialSize != "" {
+		quantity, err := resource.ParseQuantity(initialSize)
+		if err != nil {
+			return fmt.Errorf("invalid initial size: %w", err)
+		}
+		initialSizeBytes = quantity.Value()
+	}
+
+	opts := simulator.Options{
+		Config:          o.policyConfig(),
+		Annotations:     make(map[string]string),
+		PollingInterval: pollingInterval,
+		ResizeLatency:   resizeLatency,
+		Cooldown:        cooldown,
+	}
+	for key, value := range map[string]string{
+		policy.ThresholdAnnotation:          threshold,
+		policy.IncreaseAnnotation:           increase,
+		policy.CeilingAnnotation:            ceiling,
+		policy.TiersAnnotation:              tiers,
+		policy.ResizeWindowAnnotation:       resizeWindow,
+		policy.EmergencyThresholdAnnotation: emergency,
+	} {
+		if value != "" {
+			opts.Annotations[key] = value
+		}
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "TIME\tPVC\tEVENT\tSIZE\tUSED")
+
+	var results []simulator.Result
+	for _, s := range series {
+		if s.InitialCapacityBytes == 0 {
+			if initialSizeBytes == 0 {
+				return fmt.Errorf("the capacity of %s is not in the file, set --initial-size", s.PVC)
+			}
+			s.InitialCapacityBytes = initialSizeBytes
+		}
+
+		res := simulator.Run(s, opts)
+		results = append(results, res)
+
+		for _, e := range res.Events {
+			size := formatBytes(e.OldSizeBytes)
+			if e.NewSizeBytes != 0 {
+				size += " -> " + formatBytes(e.NewSizeBytes)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", e.Time.Format(time.RFC3339), res.PVC, e.Type, size, formatBytes(e.UsedBytes))
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(out)
+	w = tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "PVC\tINITIAL\tFINAL\tADDED\tRESIZES\tTIME FULL\tLAST DECISION")
+
+	var resizes int
+	var added int64
+	for _, res := range results {
+		resizes += res.Resizes
+		added += res.FinalSizeBytes - res.InitialSizeBytes
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			res.PVC,
+			formatBytes(res.InitialSizeBytes),
+			formatBytes(res.FinalSizeBytes),
+			formatBytes(res.FinalSizeBytes-res.InitialSizeBytes),
+			res.Resizes,
+			res.FullDuration,
+			orDash(string(res.LastReason)),
+		)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "\n%d pvcs, %d resizes, %s added\n", len(results), resizes, formatBytes(added))
+	return nil
+}
+
+func readSeries(path, format string) ([]simulator.Series, error) {
+	if format == "" {
+		format = "csv"
+		if filepath.Ext(path) == ".json" {
+			format = "prometheus"
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch format {
+	case "csv":
+		return simulator.ReadCSV(f)
+	case "prometheus":
+		return simulator.ReadPrometheus(f)
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	clients "github.com/lorenzophys/pvc-autoscaler/internal/metrics_clients/clients"
+	"github.com/lorenzophys/pvc-autoscaler/internal/policy"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"k8s.io/client-go/kubernetes"
+)
+
+func runStatus(ctx context.Context, args []string, out io.Writer) error {
+	var o options
+	var allNamespaces bool
+
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	o.addFlags(fs)
+	o.addPolicyFlags(fs)
+	fs.BoolVar(&allNamespaces, "all-namespaces", false, "list the pvcs of all the namespaces")
+	fs.BoolVar(&allNamespaces, "A", false, "shorthand for --all-namespaces")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	if err := o.loadConfig(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, o.timeout)
+	defer cancel()
+
+	kubeClient, namespace, err := newKubeClient(&o)
+	if err != nil {
+		return err
+	}
+	if allNamespaces {
+		namespace = ""
+	}
+
+	pvcsMetrics, err := fetchMetrics(ctx, &o, kubeClient)
+	if err != nil {
+		return err
+	}
+
+	return status(ctx, out, &o, kubeClient, namespace, pvcsMetrics)
+}
+
+// status lists the managed pvcs of the namespace, of all the namespaces when
+// it is empty, with the decision of the autoscaler
+func status(ctx context.Context, out io.Writer, o *options, kubeClient kubernetes.Interface, namespace string, pvcsMetrics map[types.NamespacedName]*clients.PVCMetrics) error {
+	pvcList, err := kubeClient.CoreV1().PersistentVolumeClaims(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: o.pvcLabels.String(),
+	})
+	if err != nil {
+		return fmt.Errorf("could not list the PersistentVolumeClaims: %w", err)
+	}
+	storageClasses, err := listStorageClasses(ctx, kubeClient)
+	if err != nil {
+		return err
+	}
+	namespaces, err := selectedNamespaces(ctx, kubeClient, o.namespaceLabels)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	if namespace == "" {
+		fmt.Fprint(w, "NAMESPACE\t")
+	}
+	fmt.Fprintln(w, "NAME\tUSAGE\tTHRESHOLD\tCEILING\tLAST RESIZE\tDECISION")
+
+	for i := range pvcList.Items {
+		pvc := &pvcList.Items[i]
+		if namespaces != nil && !namespaces[pvc.Namespace] {
+			continue
+		}
+		sc := storageClassOf(pvc, storageClasses)
+		if !policy.Enabled(pvc, sc) {
+			continue
+		}
+
+		metrics := pvcsMetrics[types.NamespacedName{Namespace: pvc.Namespace, Name: pvc.Name}]
+		cfg := o.policyConfig()
+		cfg.Backend = backendCapacity(ctx, kubeClient, pvc)
+		result := policy.Evaluate(pvc, sc, metrics, cfg)
+
+		if namespace == "" {
+			fmt.Fprintf(w, "%s\t", pvc.Namespace)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			pvc.Name,
+			formatUsage(metrics),
+			orDash(result.Policy.Threshold),
+			formatCeiling(pvc, sc, o.defaultCeilingFactor),
+			formatLastResize(pvc),
+			result.Reason(),
+		)
+	}
+
+	return w.Flush()
+}
+
+// selectedNamespaces returns the namespaces matching the selector, nil when
+// it selects all of them
+func selectedNamespaces(ctx context.Context, kubeClient kubernetes.Interface, selector labels.Selector) (map[string]bool, error) {
+	if selector == nil || selector.Empty() {
+		return nil, nil
+	}
+
+	nsList, err := kubeClient.CoreV1().Namespaces().List(ctx, metav1.ListOptions{
+		LabelSelector: selector.String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list the Namespaces: %w", err)
+	}
+
+	namespaces := make(map[string]bool, len(nsList.Items))
+	for _, ns := range nsList.Items {
+		namespaces[ns.Name] = true
+	}
+
+	return namespaces, nil
+}
+
+func listStorageClasses(ctx context.Context, kubeClient kubernetes.Interface) (map[string]*storagev1.StorageClass, error) {
+	scList, err := kubeClient.StorageV1().StorageClasses().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not list the StorageClasses: %w", err)
+	}
+
+	storageClasses := make(map[string]*storagev1.StorageClass, len(scList.Items))
+	for i := range scList.Items {
+		storageClasses[scList.Items[i].Name] = &scList.Items[i]
+	}
+
+	return storageClasses, nil
+}
+
+func storageClassOf(pvc *corev1.PersistentVolumeClaim, storageClasses map[string]*storagev1.StorageClass) *storagev1.StorageClass {
+	if pvc.Spec.StorageClassName == nil {
+		return nil
+	}
+
+	return storageClasses[*pvc.Spec.StorageClassName]
+}
+
+func formatUsage(metrics *clients.PVCMetrics) string {
+	if metrics == nil || metrics.VolumeCapacityBytes == 0 {
+		return "-"
+	}
+
+	return fmt.Sprintf("%.1f%%", float64(metrics.VolumeUsedBytes)*100/float64(metrics.VolumeCapacityBytes))
+}
+
+func formatCeiling(pvc *corev1.PersistentVolumeClaim, sc *storagev1.StorageClass, defaultFactor float64) string {
+	ceiling, err := policy.StorageCeiling(pvc, policy.EffectiveAnnotations(sc, pvc), defaultFactor)
+	if err != nil {
+		return "invalid"
+	}
+	if ceiling.IsZero() {
+		return "-"
+	}
+
+	return ceiling.String()
+}
+
+func formatLastResize(pvc *corev1.PersistentVolumeClaim) string {
+	lastResize, err := time.Parse(time.RFC3339, pvc.Annotations[policy.LastResizeAnnotation])
+	if err != nil {
+		return "-"
+	}
+
+	return duration.HumanDuration(time.Since(lastResize)) + " ago"
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+
+	return value
+}
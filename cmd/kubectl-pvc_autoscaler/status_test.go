@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	clients "github.com/lorenzophys/pvc-autoscaler/internal/metrics_clients/clients"
+	"github.com/lorenzophys/pvc-autoscaler/internal/policy"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestStatus(t *testing.T) {
+	enabled := map[string]string{policy.EnabledAnnotation: "true", policy.CeilingAnnotation: "20Gi"}
+
+	kubeClient := fake.NewSimpleClientset(
+		newTestStorageClass(),
+		newTestNamespace("default", map[string]string{"autoscaled": "true"}),
+		newTestNamespace("other", nil),
+		newTestPVC("default", "full", enabled, nil),
+		newTestPVC("default", "half", enabled, nil),
+		newTestPVC("default", "cache", enabled, map[string]string{"tier": "cache"}),
+		newTestPVC("default", "disabled", nil, nil),
+		newTestPVC("other", "full", enabled, nil),
+	)
+	pvcsMetrics := map[types.NamespacedName]*clients.PVCMetrics{
+		{Namespace: "default", Name: "full"}:  newTestMetrics(9),
+		{Namespace: "default", Name: "half"}:  newTestMetrics(5),
+		{Namespace: "default", Name: "cache"}: newTestMetrics(9),
+		{Namespace: "other", Name: "full"}:    newTestMetrics(9),
+	}
+
+	tests := []struct {
+		name      string
+		args      []string
+		config    string
+		namespace string
+		// expected are the first fields of the lines after the header
+		expected [][]string
+	}{
+		{
+			name:      "namespace",
+			namespace: "default",
+			expected: [][]string{
+				{"cache", "90.0%", "80%", "20Gi", "-", "ThresholdExceeded"},
+				{"full", "90.0%", "80%", "20Gi", "-", "ThresholdExceeded"},
+				{"half", "50.0%", "80%", "20Gi", "-", "BelowThreshold"},
+			},
+		},
+		{
+			name: "all namespaces",
+			expected: [][]string{
+				{"default", "cache", "90.0%", "80%", "20Gi", "-", "ThresholdExceeded"},
+				{"default", "full", "90.0%", "80%", "20Gi", "-", "ThresholdExceeded"},
+				{"default", "half", "50.0%", "80%", "20Gi", "-", "BelowThreshold"},
+				{"other", "full", "90.0%", "80%", "20Gi", "-", "ThresholdExceeded"},
+			},
+		},
+		{
+			name: "selectors",
+			args: []string{"--pvc-selector", "!tier", "--namespace-selector", "autoscaled=true"},
+			expected: [][]string{
+				{"default", "full", "90.0%", "80%", "20Gi", "-", "ThresholdExceeded"},
+				{"default", "half", "50.0%", "80%", "20Gi", "-", "BelowThreshold"},
+			},
+		},
+		{
+			name:      "config",
+			config:    "freeze: true\ndefaults:\n  threshold: 85%\n  increase: 20%\n",
+			namespace: "default",
+			expected: [][]string{
+				{"cache", "90.0%", "85%", "20Gi", "-", "ResizeFrozen"},
+				{"full", "90.0%", "85%", "20Gi", "-", "ResizeFrozen"},
+				{"half", "50.0%", "85%", "20Gi", "-", "BelowThreshold"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := newTestOptions(t, tt.args, tt.config)
+
+			var out bytes.Buffer
+			err := status(context.TODO(), &out, o, kubeClient, tt.namespace, pvcsMetrics)
+
+			assert.NoError(t, err)
+			lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+			if assert.Len(t, lines, len(tt.expected)+1) {
+				for i, expected := range tt.expected {
+					assert.Equal(t, expected, strings.Fields(lines[i+1]))
+				}
+			}
+		})
+	}
+}
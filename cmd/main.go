@@ -3,45 +3,147 @@ package main
 import (
 	"context"
 	"flag"
+	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
+	_ "time/tzdata"
 
+	"github.com/lorenzophys/pvc-autoscaler/internal/alertmanager"
+	"github.com/lorenzophys/pvc-autoscaler/internal/config"
+	"github.com/lorenzophys/pvc-autoscaler/internal/delegation"
+	"github.com/lorenzophys/pvc-autoscaler/internal/history"
 	clients "github.com/lorenzophys/pvc-autoscaler/internal/metrics_clients/clients"
+	"github.com/lorenzophys/pvc-autoscaler/internal/notifier"
+	"github.com/lorenzophys/pvc-autoscaler/internal/policy"
+	"github.com/lorenzophys/pvc-autoscaler/internal/provider"
 	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 )
 
 const (
-	PVCAutoscalerAnnotationPrefix           = "pvc-autoscaler.lorenzophys.io/"
-	PVCAutoscalerEnabledAnnotation          = PVCAutoscalerAnnotationPrefix + "enabled"
-	PVCAutoscalerThresholdAnnotation        = PVCAutoscalerAnnotationPrefix + "threshold"
-	PVCAutoscalerCeilingAnnotation          = PVCAutoscalerAnnotationPrefix + "ceiling"
-	PVCAutoscalerIncreaseAnnotation         = PVCAutoscalerAnnotationPrefix + "increase"
-	PVCAutoscalerPreviousCapacityAnnotation = PVCAutoscalerAnnotationPrefix + "previous_capacity"
+	PVCAutoscalerAnnotationPrefix           = policy.AnnotationPrefix
+	PVCAutoscalerEnabledAnnotation          = policy.EnabledAnnotation
+	PVCAutoscalerThresholdAnnotation        = policy.ThresholdAnnotation
+	PVCAutoscalerCeilingAnnotation          = policy.CeilingAnnotation
+	PVCAutoscalerIncreaseAnnotation         = policy.IncreaseAnnotation
+	PVCAutoscalerPreviousCapacityAnnotation = policy.PreviousCapacityAnnotation
+	PVCAutoscalerSnapshotAnnotation         = policy.SnapshotAnnotation
+	PVCAutoscalerLastResizeAnnotation       = policy.LastResizeAnnotation
 
 	DefaultThreshold = "80%"
 	DefaultIncrease  = "20%"
 
-	DefaultReconcileTimeOut = 1 * time.Minute
-	DefaultPollingInterval  = 30 * time.Second
-	DefaultLogLevel         = "INFO"
-	DefaultMetricsProvider  = "prometheus"
+	DefaultReconcileTimeOut   = 1 * time.Minute
+	DefaultPollingInterval    = 30 * time.Second
+	DefaultLogLevel           = "INFO"
+	DefaultMetricsProvider    = "prometheus"
+	DefaultMetricsAggregation = "max"
+	DefaultWebhookAddress     = ":8443"
+	DefaultSnapshotTimeout    = 5 * time.Minute
+	DefaultSnapshotRetain     = 3
+	DefaultNotifyRepeat       = 1 * time.Hour
+	DefaultResizeStall        = 30 * time.Minute
+	DefaultWorkers            = 1
+	DefaultKubeAPIQPS         = 5
+	DefaultKubeAPIBurst       = 10
+	DefaultShutdownGrace      = 30 * time.Second
+	DefaultHTTPAddress        = ":8080"
+	DefaultConfigCheck        = 10 * time.Second
+	DefaultHistorySize        = 20
+	DefaultAgentAddress       = ":9100"
+	DefaultKubeletDir         = "/var/lib/kubelet"
+	DefaultMaxMetricsAge      = 0
 )
 
 type PVCAutoscaler struct {
-	kubeClient      kubernetes.Interface
-	metricsClient   clients.MetricsClient
-	logger          *log.Logger
-	pollingInterval time.Duration
+	restConfig           *rest.Config
+	kubeClient           kubernetes.Interface
+	dynamicClient        dynamic.Interface
+	metricsClient        clients.MetricsClient
+	logger               *log.Logger
+	pollingInterval      time.Duration
+	reconcileTimeout     time.Duration
+	defaultThreshold     string
+	defaultIncrease      string
+	defaultTiers         string
+	defaultCeilingFactor float64
+	offlineWindow        string
+	resizeWindow         string
+	emergencyThreshold   string
+	freeze               bool
+	namespaceSelector    labels.Selector
+	pvcSelector          labels.Selector
+	snapshotTimeout      time.Duration
+	snapshotRetention    int
+	maxMetricsAge        time.Duration
+	workers              int
+	notifier             *notifier.Dispatcher
+	resizeStallTimeout   time.Duration
+	history              *history.Recorder
+	delegations          []delegation.Rule
+	providerLimits       provider.Table
+	alerts               *alertmanager.Receiver
+
+	config           *config.Config
+	configWatcher    *config.Watcher
+	configHash       string
+	failedConfigHash string
+
+	// clientMu guards kubeClient, read by the webhook during the reloads
+	clientMu sync.RWMutex
 }
 
 func main() {
-	metricsClient := flag.String("metrics-client", DefaultMetricsProvider, "specify the metrics client to use to query volume stats")
-	metricsClientURL := flag.String("metrics-client-url", "", "Specify the metrics client URL to use to query volume stats")
+	mode := flag.String("mode", "controller", "specify the mode, controller or agent to serve the usage of the volumes of the node")
+	agentAddress := flag.String("agent-address", DefaultAgentAddress, "specify the address the agent listens on")
+	kubeletDir := flag.String("kubelet-root-dir", DefaultKubeletDir, "specify the kubelet root directory the agent reads the volumes from")
+	metricsClient := flag.String("metrics-client", DefaultMetricsProvider, "specify the metrics client to use to query volume stats, a comma separated list like prometheus,kubelet is a fallback chain")
+	metricsAggregation := flag.String("metrics-aggregation", DefaultMetricsAggregation, "specify how the duplicate prometheus series of a pvc, e.g. from several nodes, are combined: max, min or avg")
+	metricsClientURL := flag.String("metrics-client-url", "", "Specify the metrics client URL to use to query volume stats, a comma separated list for a fallback chain")
 	pollingInterval := flag.Duration("polling-interval", DefaultPollingInterval, "specify how often to check pvc stats")
 	reconcileTimeout := flag.Duration("reconcile-timeout", DefaultReconcileTimeOut, "specify the time after which the reconciliation is considered failed")
 	logLevel := flag.String("log-level", DefaultLogLevel, "specify the log level")
+	configFile := flag.String("config", "", "specify a yaml config file overriding the flags, reloaded when it changes")
+	configCheckInterval := flag.Duration("config-check-interval", DefaultConfigCheck, "specify how often to check the config file for changes")
+	httpAddress := flag.String("http-address", DefaultHTTPAddress, "specify the address serving the prometheus metrics")
+	namespaceSelector := flag.String("namespace-selector", "", "specify a label selector restricting the namespaces of the managed pvcs")
+	pvcSelector := flag.String("pvc-selector", "", "specify a label selector restricting the managed pvcs")
+	historySize := flag.Int("decision-history-size", DefaultHistorySize, "specify how many decisions per pvc are kept for the /debug/pvcs endpoint")
+	shutdownGracePeriod := flag.Duration("shutdown-grace-period", DefaultShutdownGrace, "specify how long to wait in total for the running reconciliation, the http servers and the pending notifications on shutdown")
+	workers := flag.Int("workers", DefaultWorkers, "specify how many pvcs are processed in parallel")
+	kubeAPIQPS := flag.Float64("kube-api-qps", DefaultKubeAPIQPS, "specify the maximum queries per second to the kubernetes api server")
+	kubeAPIBurst := flag.Int("kube-api-burst", DefaultKubeAPIBurst, "specify the maximum burst of queries to the kubernetes api server")
+	defaultThreshold := flag.String("default-threshold", DefaultThreshold, "specify the threshold used when neither the pvc nor its storageclass set one")
+	defaultIncrease := flag.String("default-increase", DefaultIncrease, "specify the increase used when neither the pvc nor its storageclass set one")
+	defaultTiers := flag.String("default-tiers", "", "specify the threshold:increase tiers, e.g. 75%:10%,90%:30%, used when neither the pvc nor its storageclass set tiers, a threshold or an increase")
+	defaultCeilingFactor := flag.Float64("default-ceiling-factor", 0, "specify the multiple of the original size used as ceiling when neither the pvc nor its storageclass set one, 0 disables it")
+	defaultOfflineWindow := flag.String("default-offline-expansion-window", "", "specify the maintenance windows, e.g. \"0 2 * * sat,sun 4h\", in which the pods of the pvcs opted in to offline expansion are restarted when neither the pvc nor its storageclass set one, empty allows any time")
+	defaultResizeWindow := flag.String("default-resize-window", "", "specify the maintenance windows, e.g. \"CRON_TZ=Europe/Paris 0 20 * * mon-fri 10h\", restricting the resizes when neither the pvc nor its storageclass set one, empty allows any time")
+	defaultEmergencyThreshold := flag.String("default-emergency-threshold", "", "specify the usage above which the resize windows and the freeze are bypassed when neither the pvc nor its storageclass set one, e.g. 97%, empty disables it")
+	freeze := flag.Bool("freeze", false, "defer the resizes and the offline expansion restarts of all the pvcs below their emergency threshold")
+	snapshotTimeout := flag.Duration("snapshot-timeout", DefaultSnapshotTimeout, "specify how long to wait for a pre-resize snapshot to be ready to use")
+	maxMetricsAge := flag.Duration("max-metrics-age", DefaultMaxMetricsAge, "specify the age above which the metrics of a pvc are stale and the pvc is skipped, 0 disables the check")
+	snapshotRetention := flag.Int("snapshot-retention", DefaultSnapshotRetain, "specify how many autoscaler-created snapshots to keep per pvc")
+	notifyWebhookURL := flag.String("notify-webhook-url", "", "specify the url of a generic json webhook to notify")
+	notifyWebhookEvents := flag.String("notify-webhook-events", "", "specify the comma separated events sent to the generic webhook (default: all)")
+	notifySlackURL := flag.String("notify-slack-url", "", "specify the url of a slack incoming webhook to notify")
+	notifySlackEvents := flag.String("notify-slack-events", "", "specify the comma separated events sent to slack (default: all)")
+	notifyTeamsURL := flag.String("notify-teams-url", "", "specify the url of a microsoft teams incoming webhook to notify")
+	notifyTeamsEvents := flag.String("notify-teams-events", "", "specify the comma separated events sent to microsoft teams (default: all)")
+	notifyRepeatInterval := flag.Duration("notify-repeat-interval", DefaultNotifyRepeat, "specify after how long the same notification can be sent again")
+	resizeStallTimeout := flag.Duration("resize-stall-timeout", DefaultResizeStall, "specify after how long a resize not yet accepted is considered stalled")
+	webhookConfig := flag.String("webhook-config", "", "specify the rules file of the mutating webhook, the webhook is disabled if empty")
+	webhookAddress := flag.String("webhook-address", DefaultWebhookAddress, "specify the address the mutating webhook listens on")
+	webhookTLSCert := flag.String("webhook-tls-cert", "", "specify the TLS certificate file of the mutating webhook")
+	webhookTLSKey := flag.String("webhook-tls-key", "", "specify the TLS private key file of the mutating webhook")
 
 	flag.Parse()
 
@@ -62,39 +164,144 @@ func main() {
 		Level:     loggerLevel,
 	}
 
-	kubeClient, err := newKubeClient()
-	if err != nil {
-		logger.Fatalf("an error occurred while creating the Kubernetes client: %s", err)
+	switch *mode {
+	case "controller":
+	case "agent":
+		runAgent(*agentAddress, *kubeletDir, *shutdownGracePeriod, logger)
+		return
+	default:
+		logger.Fatalf("unknown mode: %s", *mode)
 	}
-	logger.Info("kubernetes client ready")
 
-	PVCMetricsClient, err := MetricsClientFactory(*metricsClient, *metricsClientURL)
-	if err != nil {
-		logger.Fatalf("metrics client error: %s", err)
+	if *snapshotRetention < 1 {
+		logger.Fatalf("the snapshot retention must be at least 1")
+	}
+	if *historySize < 1 {
+		logger.Fatalf("the decision history size must be at least 1")
+	}
+
+	baseConfig := config.Config{
+		MetricsClient:    config.MetricsClientConfig{Name: *metricsClient, URL: *metricsClientURL, Aggregation: *metricsAggregation},
+		PollingInterval:  metav1.Duration{Duration: *pollingInterval},
+		ReconcileTimeout: metav1.Duration{Duration: *reconcileTimeout},
+		Defaults:         config.DefaultsConfig{Threshold: *defaultThreshold, Increase: *defaultIncrease, Tiers: *defaultTiers, CeilingFactor: *defaultCeilingFactor, OfflineExpansionWindow: *defaultOfflineWindow, ResizeWindow: *defaultResizeWindow, EmergencyThreshold: *defaultEmergencyThreshold},
+		Notifications: config.NotificationsConfig{
+			RepeatInterval:     metav1.Duration{Duration: *notifyRepeatInterval},
+			ResizeStallTimeout: metav1.Duration{Duration: *resizeStallTimeout},
+			Notifiers: notifiersFromFlags(map[string][2]string{
+				"webhook": {*notifyWebhookURL, *notifyWebhookEvents},
+				"slack":   {*notifySlackURL, *notifySlackEvents},
+				"teams":   {*notifyTeamsURL, *notifyTeamsEvents},
+			}),
+		},
+		RateLimits: config.RateLimitsConfig{
+			Workers:      *workers,
+			KubeAPIQPS:   float32(*kubeAPIQPS),
+			KubeAPIBurst: *kubeAPIBurst,
+		},
+		Freeze: *freeze,
+	}
+	if *namespaceSelector != "" {
+		selector, err := metav1.ParseToLabelSelector(*namespaceSelector)
+		if err != nil {
+			logger.Fatalf("invalid namespace selector: %s", err)
+		}
+		baseConfig.Selectors.NamespaceSelector = selector
+	}
+	if *pvcSelector != "" {
+		selector, err := metav1.ParseToLabelSelector(*pvcSelector)
+		if err != nil {
+			logger.Fatalf("invalid pvc selector: %s", err)
+		}
+		baseConfig.Selectors.PVCSelector = selector
+	}
+	if err := baseConfig.Validate(); err != nil {
+		logger.Fatalf("invalid configuration: %s", err)
 	}
 
-	logger.Infof("metrics client (%s) ready at address %s", *metricsClient, *metricsClientURL)
+	registry := newMetricsRegistry()
+
+	restConfig, err := newKubeConfig()
+	if err != nil {
+		logger.Fatalf("an error occurred while loading the Kubernetes config: %s", err)
+	}
 
 	pvcAutoscaler := &PVCAutoscaler{
-		kubeClient:      kubeClient,
-		metricsClient:   PVCMetricsClient,
-		logger:          logger,
-		pollingInterval: *pollingInterval,
+		restConfig:        restConfig,
+		logger:            logger,
+		snapshotTimeout:   *snapshotTimeout,
+		snapshotRetention: *snapshotRetention,
+		maxMetricsAge:     *maxMetricsAge,
+		history:           history.NewRecorder(*historySize),
+		alerts:            alertmanager.NewReceiver(logger),
 	}
 
-	logger.Info("pvc-autoscaler ready")
+	currentConfig := &baseConfig
+	if *configFile != "" {
+		watcher, err := config.NewWatcher(*configFile, baseConfig, logger, observeConfigReload)
+		if err != nil {
+			logger.Fatalf("config file error: %s", err)
+		}
+		pvcAutoscaler.configWatcher = watcher
+		currentConfig, pvcAutoscaler.configHash = watcher.Current()
+		setConfigHash(pvcAutoscaler.configHash)
+		logger.Infof("loaded config file %s with hash %s", *configFile, pvcAutoscaler.configHash)
+	}
 
-	ticker := time.NewTicker(pvcAutoscaler.pollingInterval)
-	defer ticker.Stop()
+	if err := pvcAutoscaler.applyConfig(currentConfig); err != nil {
+		logger.Fatalf("configuration error: %s", err)
+	}
+	logger.Info("kubernetes client ready")
+
+	httpServer, mux := newHTTPServer(*httpAddress, registry)
+	pvcAutoscaler.history.RegisterHandlers(mux)
+	pvcAutoscaler.alerts.RegisterHandlers(mux)
+	go func() {
+		err := httpServer.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			logger.Fatalf("http server error: %s", err)
+		}
+	}()
+	logger.Infof("metrics, debug and alertmanager endpoints served on %s", *httpAddress)
 
-	for range ticker.C {
-		ctx, cancel := context.WithTimeout(context.Background(), *reconcileTimeout)
+	servers := []*http.Server{httpServer}
 
-		err := pvcAutoscaler.reconcile(ctx)
+	if *webhookConfig != "" {
+		server, err := newWebhookServer(pvcAutoscaler.currentKubeClient, *webhookConfig, *webhookAddress, logger)
 		if err != nil {
-			pvcAutoscaler.logger.Errorf("failed to reconcile: %v", err)
+			logger.Fatalf("webhook error: %s", err)
 		}
 
-		cancel()
+		go func() {
+			err := server.ListenAndServeTLS(*webhookTLSCert, *webhookTLSKey)
+			if err != nil && err != http.ErrServerClosed {
+				logger.Fatalf("webhook server error: %s", err)
+			}
+		}()
+		servers = append(servers, server)
+		logger.Infof("mutating webhook listening on %s", *webhookAddress)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	if pvcAutoscaler.configWatcher != nil {
+		go pvcAutoscaler.configWatcher.Run(ctx, *configCheckInterval)
+	}
+
+	logger.Info("pvc-autoscaler ready")
+
+	// The running reconciliation, the http servers and the notifications
+	// share the grace period, it starts with the signal
+	shutdownCtx, cancel := shutdownContext(ctx, *shutdownGracePeriod)
+	defer cancel()
+
+	pvcAutoscaler.run(ctx, shutdownCtx)
+
+	logger.Info("shutting down")
+	if err := shutdownServers(shutdownCtx, servers); err != nil {
+		logger.Errorf("failed to shut down the http servers: %v", err)
 	}
+	pvcAutoscaler.notifier.Close(shutdownCtx)
+	logger.Info("pvc-autoscaler stopped")
 }
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lorenzophys/pvc-autoscaler/internal/config"
+	"github.com/lorenzophys/pvc-autoscaler/internal/notifier"
+	log "github.com/sirupsen/logrus"
+)
+
+func newNotificationDispatcher(notifications config.NotificationsConfig, logger *log.Logger) (*notifier.Dispatcher, error) {
+	dispatcher := notifier.NewDispatcher(notifications.RepeatInterval.Duration, logger)
+
+	for _, n := range notifications.Notifiers {
+		events, err := notifier.ParseEventTypes(strings.Join(n.Events, ","))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s events: %w", n.Kind, err)
+		}
+
+		formatter, err := notifier.NewFormatter(n.TemplateOverrides())
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s templates: %w", n.Kind, err)
+		}
+
+		switch n.Kind {
+		case "webhook":
+			dispatcher.Register(notifier.NewWebhookNotifier(n.URL, formatter), events)
+		case "slack":
+			dispatcher.Register(notifier.NewSlackNotifier(n.URL, formatter), events)
+		case "teams":
+			dispatcher.Register(notifier.NewTeamsNotifier(n.URL, formatter), events)
+		default:
+			return nil, fmt.Errorf("unknown notifier: %s", n.Kind)
+		}
+		logger.Infof("%s notifier enabled for events %v", n.Kind, events)
+	}
+
+	return dispatcher, nil
+}
+
+// notifiersFromFlags returns the notifiers set with the command line flags,
+// each flag pair being url and comma separated events
+func notifiersFromFlags(flags map[string][2]string) []config.NotifierConfig {
+	var notifiers []config.NotifierConfig
+
+	for _, kind := range []string{"webhook", "slack", "teams"} {
+		url, events := flags[kind][0], flags[kind][1]
+		if url == "" {
+			continue
+		}
+
+		n := config.NotifierConfig{Kind: kind, URL: url}
+		if events != "" {
+			n.Events = strings.Split(events, ",")
+		}
+		notifiers = append(notifiers, n)
+	}
+
+	return notifiers
+}
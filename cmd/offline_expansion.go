@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lorenzophys/pvc-autoscaler/internal/policy"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// expandOffline evicts the pods of a PVC opted in to offline expansion whose
+// driver waits for the volume to be unmounted to expand its filesystem. The
+// pods are only evicted if their PodDisruptionBudgets allow evicting all of
+// them, otherwise the restart is retried at the next reconciliation. The pods are evicted again if the
+// filesystem is still not expanded after the stall timeout.
+func (a *PVCAutoscaler) expandOffline(ctx context.Context, pvc *corev1.PersistentVolumeClaim, sc *storagev1.StorageClass) {
+	pvcId := fmt.Sprintf("%s/%s", pvc.Namespace, pvc.Name)
+
+	enabled, windows, err := policy.OfflineExpansion(pvc, sc, a.offlineWindow)
+	if !enabled || !policy.FileSystemResizePending(pvc) {
+		return
+	}
+	if err != nil {
+		a.logger.Errorf("could not restart the pods of %s: %v", pvcId, err)
+		return
+	}
+
+	if a.freeze {
+		a.logger.Infof("restart of the pods of %s for the filesystem expansion deferred until the end of the freeze", pvcId)
+		return
+	}
+
+	now := time.Now()
+	if restart, err := time.Parse(time.RFC3339, pvc.Annotations[policy.OfflineExpansionRestartAnnotation]); err == nil && now.Sub(restart) < a.resizeStallTimeout {
+		a.logger.Debugf("pods of %s restarted at %s, waiting for the filesystem expansion", pvcId, restart.Format(time.RFC3339))
+		return
+	}
+	if len(windows) > 0 && !windows.Contains(now) {
+		a.logger.Infof("restart of the pods of %s for the filesystem expansion deferred until %s", pvcId, windows.Next(now).Format(time.RFC3339))
+		return
+	}
+
+	pods, err := a.kubeClient.CoreV1().Pods(pvc.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		a.logger.Errorf("could not list the pods of %s: %v", pvcId, err)
+		return
+	}
+	consuming := policy.ConsumingPods(pvc, pods.Items)
+	if len(consuming) == 0 {
+		a.logger.Infof("no running pod mounts %s, its filesystem is expanded on the next mount", pvcId)
+		return
+	}
+	for _, pod := range consuming {
+		if metav1.GetControllerOf(&pod) == nil {
+			a.logger.Warnf("not restarting the pods of %s because pod %s has no controller to recreate it", pvcId, pod.Name)
+			return
+		}
+	}
+
+	// Each eviction is checked against the whole PodDisruptionBudget, two
+	// pods sharing a budget that allows a single disruption would both pass
+	// the dry run and the second eviction would fail. The budgets are checked
+	// for all the pods together first, so that one of them blocking does not
+	// leave the others restarted for nothing.
+	if err := a.checkDisruptionBudgets(ctx, pvc.Namespace, consuming); err != nil {
+		a.logger.Infof("not restarting the pods of %s, retrying at the next reconciliation: %v", pvcId, err)
+		return
+	}
+	for _, pod := range consuming {
+		if err := a.evictPod(ctx, pod, true); err != nil {
+			a.logEvictionError(pod, err)
+			return
+		}
+	}
+
+	// Recorded before the evictions, so that a failure to record it does not
+	// evict the pods again at the next reconciliation
+	restart := now.UTC().Format(time.RFC3339)
+	pvc, err = a.patchAnnotations(ctx, pvc, map[string]*string{policy.OfflineExpansionRestartAnnotation: &restart})
+	if err != nil {
+		a.logger.Errorf("could not record the restart of the pods of %s, not restarting them: %v", pvcId, err)
+		return
+	}
+
+	evicted := 0
+	for _, pod := range consuming {
+		if err := a.evictPod(ctx, pod, false); err != nil {
+			a.logEvictionError(pod, err)
+			break
+		}
+		evicted++
+		offlineExpansionEvictions.Inc()
+		a.logger.Infof("evicted pod %s/%s to expand the filesystem of %s", pod.Namespace, pod.Name, pvcId)
+	}
+
+	// The restart stays recorded when some pods were evicted, so that they
+	// are not evicted again before the stall timeout. When none was, the
+	// restart is retried at the next reconciliation.
+	if evicted == 0 {
+		if _, err := a.patchAnnotations(ctx, pvc, map[string]*string{policy.OfflineExpansionRestartAnnotation: nil}); err != nil {
+			a.logger.Warnf("could not clear the restart annotation of %s: %v", pvcId, err)
+		}
+	}
+}
+
+// checkDisruptionBudgets returns an error when a PodDisruptionBudget of the
+// namespace does not allow all the pods to be evicted together
+func (a *PVCAutoscaler) checkDisruptionBudgets(ctx context.Context, namespace string, pods []corev1.Pod) error {
+	pdbs, err := a.kubeClient.PolicyV1().PodDisruptionBudgets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("could not list the PodDisruptionBudgets: %w", err)
+	}
+
+	for _, pdb := range pdbs.Items {
+		// A budget without selector selects no pod
+		if pdb.Spec.Selector == nil {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+		if err != nil {
+			return fmt.Errorf("invalid selector of PodDisruptionBudget %s: %w", pdb.Name, err)
+		}
+
+		matching := 0
+		for _, pod := range pods {
+			if selector.Matches(labels.Set(pod.Labels)) {
+				matching++
+			}
+		}
+		if matching > int(pdb.Status.DisruptionsAllowed) {
+			return fmt.Errorf("PodDisruptionBudget %s allows %d disruptions but %d of the pods are selected by it", pdb.Name, pdb.Status.DisruptionsAllowed, matching)
+		}
+	}
+
+	return nil
+}
+
+func (a *PVCAutoscaler) evictPod(ctx context.Context, pod corev1.Pod, dryRun bool) error {
+	eviction := &policyv1.Eviction{ObjectMeta: metav1.ObjectMeta{Namespace: pod.Namespace, Name: pod.Name}}
+	if dryRun {
+		eviction.DeleteOptions = &metav1.DeleteOptions{DryRun: []string{metav1.DryRunAll}}
+	}
+
+	return a.kubeClient.PolicyV1().Evictions(pod.Namespace).Evict(ctx, eviction)
+}
+
+func (a *PVCAutoscaler) logEvictionError(pod corev1.Pod, err error) {
+	if apierrors.IsTooManyRequests(err) {
+		a.logger.Infof("eviction of pod %s/%s blocked by its PodDisruptionBudget, retrying at the next reconciliation", pod.Namespace, pod.Name)
+		return
+	}
+	a.logger.Errorf("could not evict pod %s/%s: %v", pod.Namespace, pod.Name, err)
+}
+
+// confirmOfflineExpansion clears the restart annotation of a PVC whose
+// metrics report the expanded filesystem. The PVC is returned unchanged if
+// the annotation could not be removed.
+func (a *PVCAutoscaler) confirmOfflineExpansion(ctx context.Context, pvc *corev1.PersistentVolumeClaim, capacityBytes int64) *corev1.PersistentVolumeClaim {
+	pvcId := fmt.Sprintf("%s/%s", pvc.Namespace, pvc.Name)
+	a.logger.Infof("filesystem of %s expanded to %s after the restart of its pods", pvcId, resource.NewQuantity(capacityBytes, resource.BinarySI))
+
+	patched, err := a.patchAnnotations(ctx, pvc, map[string]*string{policy.OfflineExpansionRestartAnnotation: nil})
+	if err != nil {
+		a.logger.Warnf("could not clear the restart annotation of %s: %v", pvcId, err)
+		return pvc
+	}
+
+	return patched
+}
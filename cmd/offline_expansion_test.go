@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lorenzophys/pvc-autoscaler/internal/policy"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newOfflineTestPVC() *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "default",
+			Name:        "data",
+			Annotations: map[string]string{policy.OfflineExpansionAnnotation: "true"},
+		},
+		Status: corev1.PersistentVolumeClaimStatus{
+			Conditions: []corev1.PersistentVolumeClaimCondition{
+				{Type: corev1.PersistentVolumeClaimFileSystemResizePending, Status: corev1.ConditionTrue},
+			},
+		},
+	}
+}
+
+func newOfflineTestPod(name string, controlled bool) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+		Spec: corev1.PodSpec{Volumes: []corev1.Volume{
+			{Name: "data", VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data"}}},
+		}},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	if controlled {
+		isController := true
+		pod.OwnerReferences = []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "StatefulSet", Name: "db", Controller: &isController}}
+	}
+	return pod
+}
+
+// evictionRecorder records the evictions and blocks the ones of the pods in
+// blocked, in dry run too unless onlyReal is set
+type evictionRecorder struct {
+	blocked  map[string]bool
+	onlyReal bool
+	dryRuns  []string
+	evicted  []string
+}
+
+func (r *evictionRecorder) react(action k8stesting.Action) (bool, runtime.Object, error) {
+	if action.GetSubresource() != "eviction" {
+		return false, nil, nil
+	}
+	eviction := action.(k8stesting.CreateAction).GetObject().(*policyv1.Eviction)
+	dryRun := eviction.DeleteOptions != nil && len(eviction.DeleteOptions.DryRun) > 0
+
+	if r.blocked[eviction.Name] && (!dryRun || !r.onlyReal) {
+		return true, nil, apierrors.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption budget.", 10)
+	}
+	if dryRun {
+		r.dryRuns = append(r.dryRuns, eviction.Name)
+	} else {
+		r.evicted = append(r.evicted, eviction.Name)
+	}
+	return true, nil, nil
+}
+
+func newOfflineTestAutoscaler(recorder *evictionRecorder, objects ...runtime.Object) (*PVCAutoscaler, *fake.Clientset) {
+	client := fake.NewSimpleClientset(objects...)
+	client.PrependReactor("create", "pods", recorder.react)
+
+	return &PVCAutoscaler{
+		kubeClient:         client,
+		logger:             newTestLogger(),
+		resizeStallTimeout: 30 * time.Minute,
+	}, client
+}
+
+func getOfflineTestPVC(t *testing.T, client *fake.Clientset) *corev1.PersistentVolumeClaim {
+	t.Helper()
+
+	pvc, err := client.CoreV1().PersistentVolumeClaims("default").Get(context.TODO(), "data", metav1.GetOptions{})
+	assert.NoError(t, err)
+	return pvc
+}
+
+func TestExpandOffline(t *testing.T) {
+	t.Run("evicts the pods and records the restart", func(t *testing.T) {
+		pvc := newOfflineTestPVC()
+		recorder := &evictionRecorder{}
+		a, client := newOfflineTestAutoscaler(recorder, pvc, newOfflineTestPod("db-0", true), newOfflineTestPod("db-1", true))
+
+		a.expandOffline(context.TODO(), pvc, nil)
+
+		assert.Equal(t, []string{"db-0", "db-1"}, recorder.dryRuns)
+		assert.Equal(t, []string{"db-0", "db-1"}, recorder.evicted)
+		assert.Contains(t, getOfflineTestPVC(t, client).Annotations, policy.OfflineExpansionRestartAnnotation)
+	})
+
+	t.Run("pod disruption budget blocking a pod", func(t *testing.T) {
+		pvc := newOfflineTestPVC()
+		recorder := &evictionRecorder{blocked: map[string]bool{"db-1": true}}
+		a, client := newOfflineTestAutoscaler(recorder, pvc, newOfflineTestPod("db-0", true), newOfflineTestPod("db-1", true))
+
+		a.expandOffline(context.TODO(), pvc, nil)
+
+		// The dry run fails, no pod is evicted
+		assert.Empty(t, recorder.evicted)
+		assert.NotContains(t, getOfflineTestPVC(t, client).Annotations, policy.OfflineExpansionRestartAnnotation)
+	})
+
+	t.Run("pod disruption budget blocking after the dry run", func(t *testing.T) {
+		pvc := newOfflineTestPVC()
+		recorder := &evictionRecorder{blocked: map[string]bool{"db-1": true}, onlyReal: true}
+		a, client := newOfflineTestAutoscaler(recorder, pvc, newOfflineTestPod("db-0", true), newOfflineTestPod("db-1", true))
+
+		a.expandOffline(context.TODO(), pvc, nil)
+
+		// The restart is recorded so that db-0 is not evicted again
+		assert.Equal(t, []string{"db-0"}, recorder.evicted)
+		assert.Contains(t, getOfflineTestPVC(t, client).Annotations, policy.OfflineExpansionRestartAnnotation)
+	})
+
+	t.Run("first eviction failing after the dry run", func(t *testing.T) {
+		pvc := newOfflineTestPVC()
+		recorder := &evictionRecorder{blocked: map[string]bool{"db-0": true}, onlyReal: true}
+		a, client := newOfflineTestAutoscaler(recorder, pvc, newOfflineTestPod("db-0", true), newOfflineTestPod("db-1", true))
+
+		a.expandOffline(context.TODO(), pvc, nil)
+
+		// No pod was restarted, the restart is retried at the next reconciliation
+		assert.Empty(t, recorder.evicted)
+		assert.NotContains(t, getOfflineTestPVC(t, client).Annotations, policy.OfflineExpansionRestartAnnotation)
+	})
+
+	t.Run("pod disruption budget shared by the pods", func(t *testing.T) {
+		tests := []struct {
+			name               string
+			disruptionsAllowed int32
+			evicted            []string
+		}{
+			{name: "one disruption allowed", disruptionsAllowed: 1},
+			{name: "all the disruptions allowed", disruptionsAllowed: 2, evicted: []string{"db-0", "db-1"}},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				pvc := newOfflineTestPVC()
+				pdb := &policyv1.PodDisruptionBudget{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "db"},
+					Spec:       policyv1.PodDisruptionBudgetSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}}},
+					Status:     policyv1.PodDisruptionBudgetStatus{DisruptionsAllowed: tt.disruptionsAllowed},
+				}
+				var objects []runtime.Object
+				for _, name := range []string{"db-0", "db-1"} {
+					pod := newOfflineTestPod(name, true)
+					pod.Labels = map[string]string{"app": "db"}
+					objects = append(objects, pod)
+				}
+				// Each eviction on its own is allowed
+				recorder := &evictionRecorder{}
+				a, client := newOfflineTestAutoscaler(recorder, append(objects, pvc, pdb)...)
+
+				a.expandOffline(context.TODO(), pvc, nil)
+
+				assert.Equal(t, tt.evicted, recorder.evicted)
+				if tt.evicted == nil {
+					assert.Empty(t, recorder.dryRuns)
+					assert.NotContains(t, getOfflineTestPVC(t, client).Annotations, policy.OfflineExpansionRestartAnnotation)
+				}
+			})
+		}
+	})
+
+	t.Run("restart not recorded", func(t *testing.T) {
+		pvc := newOfflineTestPVC()
+		recorder := &evictionRecorder{}
+		a, client := newOfflineTestAutoscaler(recorder, pvc, newOfflineTestPod("db-0", true))
+		client.PrependReactor("patch", "persistentvolumeclaims", func(k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierrors.NewInternalError(errors.New("etcd unavailable"))
+		})
+
+		a.expandOffline(context.TODO(), pvc, nil)
+
+		// The pods would be evicted again at the next reconciliation
+		assert.Empty(t, recorder.evicted)
+	})
+
+	t.Run("pod without controller", func(t *testing.T) {
+		pvc := newOfflineTestPVC()
+		recorder := &evictionRecorder{}
+		a, _ := newOfflineTestAutoscaler(recorder, pvc, newOfflineTestPod("db-0", true), newOfflineTestPod("debug", false))
+
+		a.expandOffline(context.TODO(), pvc, nil)
+
+		assert.Empty(t, recorder.dryRuns)
+		assert.Empty(t, recorder.evicted)
+	})
+
+	t.Run("recently restarted", func(t *testing.T) {
+		pvc := newOfflineTestPVC()
+		pvc.Annotations[policy.OfflineExpansionRestartAnnotation] = time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+		recorder := &evictionRecorder{}
+		a, _ := newOfflineTestAutoscaler(recorder, pvc, newOfflineTestPod("db-0", true))
+
+		a.expandOffline(context.TODO(), pvc, nil)
+
+		assert.Empty(t, recorder.evicted)
+	})
+
+	t.Run("outside of the window", func(t *testing.T) {
+		pvc := newOfflineTestPVC()
+		// Only the minute before now
+		start := time.Now().UTC().Add(-2 * time.Minute)
+		pvc.Annotations[policy.OfflineExpansionWindowAnnotation] = start.Format("4 15 2 1") + " * 1m"
+		recorder := &evictionRecorder{}
+		a, _ := newOfflineTestAutoscaler(recorder, pvc, newOfflineTestPod("db-0", true))
+
+		a.expandOffline(context.TODO(), pvc, nil)
+
+		assert.Empty(t, recorder.evicted)
+	})
+
+	t.Run("freeze", func(t *testing.T) {
+		pvc := newOfflineTestPVC()
+		recorder := &evictionRecorder{}
+		a, _ := newOfflineTestAutoscaler(recorder, pvc, newOfflineTestPod("db-0", true))
+		a.freeze = true
+
+		a.expandOffline(context.TODO(), pvc, nil)
+
+		assert.Empty(t, recorder.evicted)
+	})
+
+	t.Run("filesystem resize not pending", func(t *testing.T) {
+		pvc := newOfflineTestPVC()
+		pvc.Status.Conditions = nil
+		recorder := &evictionRecorder{}
+		a, _ := newOfflineTestAutoscaler(recorder, pvc, newOfflineTestPod("db-0", true))
+
+		a.expandOffline(context.TODO(), pvc, nil)
+
+		assert.Empty(t, recorder.evicted)
+	})
+}
+
+func TestConfirmOfflineExpansion(t *testing.T) {
+	pvc := newOfflineTestPVC()
+	pvc.Annotations[policy.OfflineExpansionRestartAnnotation] = time.Now().UTC().Format(time.RFC3339)
+	a, client := newOfflineTestAutoscaler(&evictionRecorder{}, pvc)
+
+	confirmed := a.confirmOfflineExpansion(context.TODO(), pvc, 20<<30)
+
+	assert.NotContains(t, confirmed.Annotations, policy.OfflineExpansionRestartAnnotation)
+	assert.Equal(t, "true", confirmed.Annotations[policy.OfflineExpansionAnnotation])
+	assert.NotContains(t, getOfflineTestPVC(t, client).Annotations, policy.OfflineExpansionRestartAnnotation)
+}
@@ -2,142 +2,344 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"math"
+	"slices"
 	"strconv"
+	"sync"
 	"time"
 
+	"github.com/lorenzophys/pvc-autoscaler/internal/delegation"
+	"github.com/lorenzophys/pvc-autoscaler/internal/history"
+	clients "github.com/lorenzophys/pvc-autoscaler/internal/metrics_clients/clients"
+	"github.com/lorenzophys/pvc-autoscaler/internal/notifier"
+	"github.com/lorenzophys/pvc-autoscaler/internal/policy"
 	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 )
 
-func (a *PVCAutoscaler) reconcile(ctx context.Context) error {
-	pvcl, err := getAnnotatedPVCs(ctx, a.kubeClient)
+// reconcile evaluates the managed PVCs, or only the ones listed if only is
+// not nil. The PVCs listed but not managed are ignored. Once stop is closed
+// no new PVC is processed, the ones being processed are finished.
+func (a *PVCAutoscaler) reconcile(ctx context.Context, stop <-chan struct{}, only []types.NamespacedName) error {
+	pvcl, err := getAnnotatedPVCs(ctx, a.kubeClient, a.namespaceSelector, a.pvcSelector)
 	if err != nil {
 		return fmt.Errorf("could not get PersistentVolumeClaims: %w", err)
 	}
 	a.logger.Debugf("fetched %d annotated pvcs", pvcl.Size())
 
-	pvcsMetrics, err := a.metricsClient.FetchPVCsMetrics(ctx, time.Now())
+	if only != nil {
+		pvcl.Items = slices.DeleteFunc(pvcl.Items, func(pvc corev1.PersistentVolumeClaim) bool {
+			return !slices.Contains(only, types.NamespacedName{Namespace: pvc.Namespace, Name: pvc.Name})
+		})
+		if len(pvcl.Items) == 0 {
+			a.logger.Debug("none of the requested pvcs is managed")
+			return nil
+		}
+	}
+
+	keys := make([]types.NamespacedName, 0, len(pvcl.Items))
+	for _, pvc := range pvcl.Items {
+		keys = append(keys, types.NamespacedName{Namespace: pvc.Namespace, Name: pvc.Name})
+	}
+	pvcsMetrics, err := clients.FetchMetricsOf(ctx, a.metricsClient, time.Now(), keys)
 	if err != nil {
 		a.logger.Errorf("could not fetch the PersistentVolumeClaims metrics: %v", err)
 		return nil
 	}
 	a.logger.Debug("fetched pvc metrics")
 
-	for _, pvc := range pvcl.Items {
-		pvcId := fmt.Sprintf("%s/%s", pvc.Namespace, pvc.Name)
-		a.logger.Debugf("processing pvc %s", pvcId)
+	pvcs := sortPVCsByUsage(pvcl.Items, pvcsMetrics)
 
-		// Determine if the StorageClass allows volume expansion
-		storageClassName := *pvc.Spec.StorageClassName
-		isExpandable, err := isStorageClassExpandable(ctx, a.kubeClient, storageClassName)
-		if err != nil {
-			a.logger.Errorf("could not get StorageClass %s for %s: %v", storageClassName, pvcId, err)
-			continue
-		}
-		if !isExpandable {
-			a.logger.Errorf("the StorageClass %s of %s does not allow volume expansion", storageClassName, pvcId)
-			continue
+	if only == nil {
+		managed := make([]types.NamespacedName, 0, len(pvcs))
+		for _, pvc := range pvcs {
+			managed = append(managed, types.NamespacedName{Namespace: pvc.Namespace, Name: pvc.Name})
 		}
-		a.logger.Debugf("storageclass for %s allows volume expansion", pvcId)
+		a.history.Retain(managed)
+	}
 
-		// Determine if pvc the meets the condition for resize
-		err = isPVCResizable(&pvc)
-		if err != nil {
-			a.logger.Errorf("the PersistentVolumeClaim %s is not resizable: %v", pvcId, err)
-			continue
-		}
-		a.logger.Debugf("pvc %s meets the resizing conditions", pvcId)
+	var (
+		mu   sync.Mutex
+		errs []error
+		wg   sync.WaitGroup
+	)
+
+	queue := make(chan *corev1.PersistentVolumeClaim)
+	for i := 0; i < a.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pvc := range queue {
+				if err := a.processPVC(ctx, pvc, pvcsMetrics); err != nil {
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
 
-		namespacedName := types.NamespacedName{
-			Namespace: pvc.Namespace,
-			Name:      pvc.Name,
+	skipped := 0
+dispatch:
+	for i := range pvcs {
+		// Checked first, select picks randomly among the ready cases
+		select {
+		case <-stop:
+			skipped = len(pvcs) - i
+			break dispatch
+		default:
 		}
-		if _, ok := pvcsMetrics[namespacedName]; !ok {
-			a.logger.Errorf("could not fetch the metrics for %s", pvcId)
-			continue
+
+		select {
+		case queue <- &pvcs[i]:
+		case <-stop:
+			skipped = len(pvcs) - i
+			break dispatch
+		case <-ctx.Done():
+			break dispatch
 		}
-		a.logger.Debugf("metrics for %s received", pvcId)
+	}
+	close(queue)
+	wg.Wait()
+
+	if skipped > 0 {
+		a.logger.Infof("reconciliation stopped, %d pvcs left for the next run", skipped)
+	}
+
+	if ctx.Err() != nil {
+		errs = append(errs, fmt.Errorf("reconciliation interrupted: %w", ctx.Err()))
+	}
+
+	return errors.Join(errs...)
+}
 
-		pvcCurrentCapacityBytes := pvcsMetrics[namespacedName].VolumeCapacityBytes
+func (a *PVCAutoscaler) processPVC(ctx context.Context, pvc *corev1.PersistentVolumeClaim, pvcsMetrics map[types.NamespacedName]*clients.PVCMetrics) error {
+	pvcId := fmt.Sprintf("%s/%s", pvc.Namespace, pvc.Name)
+	a.logger.Debugf("processing pvc %s", pvcId)
 
-		threshold, err := convertPercentageToBytes(pvc.Annotations[PVCAutoscalerThresholdAnnotation], pvcCurrentCapacityBytes, DefaultThreshold)
+	namespacedName := types.NamespacedName{
+		Namespace: pvc.Namespace,
+		Name:      pvc.Name,
+	}
+	obs := a.history.Begin(namespacedName)
+	defer obs.Commit()
+
+	pvc = a.recordOriginalSize(ctx, pvc)
+
+	var sc *storagev1.StorageClass
+	if pvc.Spec.StorageClassName != nil {
+		var err error
+		sc, err = a.kubeClient.StorageV1().StorageClasses().Get(ctx, *pvc.Spec.StorageClassName, metav1.GetOptions{})
 		if err != nil {
-			a.logger.Errorf("failed to convert threshold annotation for %s: %v", pvcId, err)
-			continue
+			obs.Decide(string(policy.ReasonStorageClassNotFound), err.Error())
+			return fmt.Errorf("could not get StorageClass %s for %s: %w", *pvc.Spec.StorageClassName, pvcId, err)
 		}
+	}
 
-		capacity, exists := pvc.Status.Capacity[corev1.ResourceStorage]
-		if !exists {
-			a.logger.Infof("skip %s because its capacity is not set yet", pvcId)
-			continue
+	metrics := pvcsMetrics[namespacedName]
+	result := policy.Evaluate(pvc, sc, metrics, policy.Config{
+		DefaultThreshold:          a.defaultThreshold,
+		DefaultIncrease:           a.defaultIncrease,
+		DefaultTiers:              a.defaultTiers,
+		DefaultCeilingFactor:      a.defaultCeilingFactor,
+		ProviderLimits:            a.providerLimits,
+		DefaultResizeWindow:       a.resizeWindow,
+		DefaultEmergencyThreshold: a.emergencyThreshold,
+		Freeze:                    a.freeze,
+		Backend:                   a.backendCapacity(ctx, pvc),
+		MaxMetricsAge:             a.maxMetricsAge,
+		Now:                       time.Now(),
+	})
+
+	obs.Policy = history.Policy(result.Policy)
+	obs.ThresholdBytes = result.ThresholdBytes
+	obs.CeilingBytes = result.CeilingBytes
+	if metrics != nil {
+		obs.Metrics = &history.Metrics{
+			UsedBytes:     metrics.VolumeUsedBytes,
+			CapacityBytes: metrics.VolumeCapacityBytes,
+			ObservedAt:    metrics.Timestamp,
 		}
-		if capacity.Value() == 0 {
-			a.logger.Infof("skip %s because its capacity is zero", pvcId)
-			continue
+		if obs.Metrics.ObservedAt.IsZero() {
+			obs.Metrics.ObservedAt = time.Now()
 		}
+	}
 
-		increase, err := convertPercentageToBytes(pvc.Annotations[PVCAutoscalerIncreaseAnnotation], capacity.Value(), DefaultIncrease)
-		if err != nil {
-			a.logger.Errorf("failed to convert increase annotation for %s: %v", pvcId, err)
-			continue
-		}
+	// The metrics report the new capacity once the filesystem is expanded
+	_, restarted := pvc.Annotations[policy.OfflineExpansionRestartAnnotation]
+	if restarted && metrics != nil && result.Reason() != policy.ReasonResizePending && result.Reason() != policy.ReasonMetricsStale {
+		pvc = a.confirmOfflineExpansion(ctx, pvc, metrics.VolumeCapacityBytes)
+	}
 
-		previousCapacity, exist := pvc.Annotations[PVCAutoscalerPreviousCapacityAnnotation]
-		if exist {
-			parsedPreviousCapacity, err := strconv.ParseInt(previousCapacity, 10, 64)
-			if err != nil {
-				a.logger.Errorf("failed to parse 'previous_capacity' annotation: %v", err)
-				continue
-			}
-			if parsedPreviousCapacity == pvcCurrentCapacityBytes {
-				a.logger.Infof("pvc %s is still waiting to accept the resize", pvcId)
-				continue
-			}
+	if err := result.Err(); err != nil {
+		obs.Decide(string(result.Reason()), err.Error())
+		switch result.Reason() {
+		case policy.ReasonInvalidCeiling, policy.ReasonInvalidThreshold, policy.ReasonInvalidIncrease, policy.ReasonInvalidTiers, policy.ReasonInvalidAttributesClasses, policy.ReasonInvalidResizeWindow:
+			a.notifyInvalidConfig(ctx, pvc, err)
+		case policy.ReasonMetricsStale:
+			staleMetrics.Inc()
+		case policy.ReasonInsufficientBackendCapacity:
+			insufficientBackendCapacity.Inc()
+		case policy.ReasonResizeRequestRejected:
+			a.rejectResizeRequest(ctx, pvc, err)
 		}
+		return err
+	}
+	if !result.NextResizeAt.IsZero() {
+		obs.Decide(string(result.Reason()), "deferred until "+result.NextResizeAt.Format(time.RFC3339))
+	} else {
+		obs.Decide(string(result.Reason()), "")
+	}
 
-		ceiling, err := getPVCStorageCeiling(&pvc)
-		if err != nil {
-			a.logger.Errorf("failed to fetch storage ceiling for %s: %v", pvcId, err)
-			continue
-		}
-		if capacity.Cmp(ceiling) >= 0 {
-			a.logger.Infof("volume storage limit (%s) reached for %s", ceiling.String(), pvcId)
-			continue
+	if resize, ok := result.Decision.(policy.Resize); ok {
+		return a.resizePVC(ctx, pvc, metrics, result.CurrentSizeBytes, resize, obs)
+	}
+
+	switch result.Reason() {
+	case policy.ReasonCapacityNotSet:
+		a.logger.Infof("skip %s because its capacity is not set yet", pvcId)
+	case policy.ReasonCapacityZero:
+		a.logger.Infof("skip %s because its capacity is zero", pvcId)
+	case policy.ReasonProviderCooldown:
+		a.logger.Infof("resize of %s deferred until %s by the cooldown of its provisioner", pvcId, result.NextResizeAt.Format(time.RFC3339))
+	case policy.ReasonOutsideResizeWindow:
+		a.logger.Infof("resize of %s deferred until %s by its resize windows", pvcId, result.NextResizeAt.Format(time.RFC3339))
+	case policy.ReasonResizeFrozen:
+		a.logger.Infof("resize of %s deferred until the end of the freeze", pvcId)
+	case policy.ReasonResizePending:
+		a.logger.Infof("pvc %s is still waiting to accept the resize", pvcId)
+		a.checkResizeStalled(ctx, pvc, metrics.VolumeCapacityBytes)
+		a.expandOffline(ctx, pvc, sc)
+	case policy.ReasonCeilingReached:
+		a.logger.Infof("volume storage limit (%s) reached for %s", resource.NewQuantity(result.CeilingBytes, resource.BinarySI), pvcId)
+		if metrics.VolumeUsedBytes >= result.ThresholdBytes {
+			a.notifier.Dispatch(notifier.Event{
+				Type:          notifier.EventCeilingReached,
+				Namespace:     pvc.Namespace,
+				PVC:           pvc.Name,
+				NewSizeBytes:  result.CeilingBytes,
+				UsedBytes:     metrics.VolumeUsedBytes,
+				CapacityBytes: metrics.VolumeCapacityBytes,
+			})
 		}
+	}
+
+	return nil
+}
 
-		currentUsedBytes := pvcsMetrics[namespacedName].VolumeUsedBytes
-		if currentUsedBytes >= threshold {
-			a.logger.Infof("pvc %s usage bigger than threshold", pvcId)
+func (a *PVCAutoscaler) resizePVC(ctx context.Context, pvc *corev1.PersistentVolumeClaim, metrics *clients.PVCMetrics, currentSizeBytes int64, resize policy.Resize, obs *history.Observation) error {
+	pvcId := fmt.Sprintf("%s/%s", pvc.Namespace, pvc.Name)
+	newStorage := resize.NewSize
 
-			// 1<<30 is a bit shift operation that represents 2^30, i.e. 1Gi
-			newStorageBytes := int64(math.Ceil(float64(capacity.Value()+increase)/(1<<30))) << 30
-			newStorage := resource.NewQuantity(newStorageBytes, resource.BinarySI)
-			if newStorage.Cmp(ceiling) > 0 {
-				newStorage = &ceiling
-			}
+	// The annotations applied along with the resize
+	annotations := make(map[string]*string)
+	if resize.Requested {
+		a.logger.Infof("pvc %s resize to %s requested", pvcId, newStorage.String())
+		if err := a.checkResizeRequestQuota(ctx, pvc, newStorage); err != nil {
+			obs.Decide(string(policy.ReasonResizeRequestRejected), err.Error())
+			a.rejectResizeRequest(ctx, pvc, err)
+			return fmt.Errorf("resize request of %s rejected: %w", pvcId, err)
+		}
+		annotations = resizeRequestDone("resized to " + newStorage.String())
+	} else if resize.Tier != "" {
+		a.logger.Infof("pvc %s usage reached tier %s", pvcId, resize.Tier)
+	} else {
+		a.logger.Infof("pvc %s usage bigger than threshold", pvcId)
+	}
+	if resize.Emergency {
+		a.logger.Warnf("pvc %s usage reached its emergency threshold or a nocooldown tier, resizing despite its resize windows or the freeze", pvcId)
+	}
 
-			err := a.updatePVCWithNewStorageSize(ctx, &pvc, pvcCurrentCapacityBytes, newStorage)
-			if err != nil {
-				a.logger.Errorf("failed to resize pvc %s: %v", pvcId, err)
-			}
+	if resize.BackendLimited {
+		a.logger.Infof("resize of %s lowered to %s to fit in the backend capacity", pvcId, newStorage.String())
+	}
+
+	if snapshotClass := pvc.Annotations[PVCAutoscalerSnapshotAnnotation]; snapshotClass != "" {
+		ready, err := a.snapshotPVC(ctx, pvc, snapshotClass, metrics.VolumeCapacityBytes)
+		if err != nil {
+			obs.Decide(string(policy.ReasonSnapshotFailed), err.Error())
+			return fmt.Errorf("skip resizing %s because the snapshot failed: %w", pvcId, err)
+		}
+		if !ready {
+			a.logger.Infof("resize of %s deferred until its snapshot is ready to use", pvcId)
+			obs.Decide(string(policy.ReasonSnapshotPending), "")
+			return nil
+		}
+	}
 
-			a.logger.Infof("pvc %s resized from %d to %d ", pvcId, capacity.Value(), newStorage.Value())
+	message := fmt.Sprintf("from %d to %d bytes", currentSizeBytes, newStorage.Value())
+	if resize.Requested {
+		message = "on request " + message
+	}
+	if resize.Tier != "" {
+		message += fmt.Sprintf(" at tier %s", resize.Tier)
+	}
+	if resize.AttributesClass != "" {
+		message += fmt.Sprintf(" with attributes class %s", resize.AttributesClass)
+	}
+	target, delegated, err := delegation.Resolve(ctx, a.kubeClient, pvc, a.delegations)
+	if err != nil {
+		obs.Decide(string(policy.ReasonResizeFailed), err.Error())
+		return fmt.Errorf("failed to resize pvc %s: %w", pvcId, err)
+	}
+	if delegated {
+		if resize.AttributesClass != "" {
+			a.logger.Warnf("the attributes class of %s is managed by %s, not switching it to %s", pvcId, target, resize.AttributesClass)
 		}
+		err = a.delegateResize(ctx, pvc, target, metrics.VolumeCapacityBytes, newStorage, annotations)
+		message += fmt.Sprintf(" through %s", target)
+	} else {
+		err = a.updatePVCWithNewStorageSize(ctx, pvc, metrics.VolumeCapacityBytes, &newStorage, resize.AttributesClass, annotations)
+	}
+	if err != nil {
+		obs.Decide(string(policy.ReasonResizeFailed), err.Error())
+		return fmt.Errorf("failed to resize pvc %s: %w", pvcId, err)
 	}
 
+	obs.Decide(string(policy.ReasonResized), message)
+	a.logger.Infof("pvc %s resized from %d to %d ", pvcId, currentSizeBytes, newStorage.Value())
+	a.notifier.Dispatch(notifier.Event{
+		Type:          notifier.EventResized,
+		Namespace:     pvc.Namespace,
+		PVC:           pvc.Name,
+		OldSizeBytes:  currentSizeBytes,
+		NewSizeBytes:  newStorage.Value(),
+		UsedBytes:     metrics.VolumeUsedBytes,
+		CapacityBytes: metrics.VolumeCapacityBytes,
+		Tier:          resize.Tier,
+	})
+
 	return nil
 }
 
-func (a *PVCAutoscaler) updatePVCWithNewStorageSize(ctx context.Context, pvcToResize *corev1.PersistentVolumeClaim, capacityBytes int64, newStorageBytes *resource.Quantity) error {
+func (a *PVCAutoscaler) updatePVCWithNewStorageSize(ctx context.Context, pvcToResize *corev1.PersistentVolumeClaim, capacityBytes int64, newStorageBytes *resource.Quantity, attributesClass string, annotations map[string]*string) error {
 	pvcId := fmt.Sprintf("%s/%s", pvcToResize.Namespace, pvcToResize.Name)
 
 	pvcToResize.Spec.Resources.Requests[corev1.ResourceStorage] = *newStorageBytes
+	// The class is switched in the same update, the pending resize tracking
+	// covers both
+	if attributesClass != "" {
+		a.logger.Infof("switching pvc %s to attributes class %s", pvcId, attributesClass)
+		pvcToResize.Spec.VolumeAttributesClassName = &attributesClass
+	}
 
+	// The PVC may be enabled through its StorageClass and carry no annotations
+	if pvcToResize.Annotations == nil {
+		pvcToResize.Annotations = make(map[string]string)
+	}
 	pvcToResize.Annotations[PVCAutoscalerPreviousCapacityAnnotation] = strconv.FormatInt(capacityBytes, 10)
+	pvcToResize.Annotations[PVCAutoscalerLastResizeAnnotation] = time.Now().UTC().Format(time.RFC3339)
+	for key, value := range annotations {
+		if value == nil {
+			delete(pvcToResize.Annotations, key)
+			continue
+		}
+		pvcToResize.Annotations[key] = *value
+	}
 	a.logger.Debugf("PVCAutoscalerPreviousCapacityAnnotation annotation written for %s ok", pvcId)
 
 	_, err := a.kubeClient.CoreV1().PersistentVolumeClaims(pvcToResize.Namespace).Update(ctx, pvcToResize, metav1.UpdateOptions{})
@@ -148,3 +350,86 @@ func (a *PVCAutoscaler) updatePVCWithNewStorageSize(ctx context.Context, pvcToRe
 
 	return nil
 }
+
+func (a *PVCAutoscaler) notifyInvalidConfig(ctx context.Context, pvc *corev1.PersistentVolumeClaim, err error) {
+	a.notifier.Dispatch(notifier.Event{
+		Type:      notifier.EventInvalidConfig,
+		Namespace: pvc.Namespace,
+		PVC:       pvc.Name,
+		Reason:    err.Error(),
+	})
+}
+
+// checkResizeStalled notifies when the last resize of the PVC has not been
+// accepted within the stall timeout
+func (a *PVCAutoscaler) checkResizeStalled(ctx context.Context, pvc *corev1.PersistentVolumeClaim, capacityBytes int64) {
+	lastResize, err := time.Parse(time.RFC3339, pvc.Annotations[PVCAutoscalerLastResizeAnnotation])
+	if err != nil || time.Since(lastResize) < a.resizeStallTimeout {
+		return
+	}
+
+	event := notifier.Event{
+		Type:         notifier.EventResizeStalled,
+		Namespace:    pvc.Namespace,
+		PVC:          pvc.Name,
+		OldSizeBytes: capacityBytes,
+		Reason:       fmt.Sprintf("waiting since %s", lastResize.Format(time.RFC3339)),
+	}
+	if requested, ok := pvc.Spec.Resources.Requests[corev1.ResourceStorage]; ok {
+		event.NewSizeBytes = requested.Value()
+	}
+	for _, condition := range pvc.Status.Conditions {
+		if condition.Status == corev1.ConditionTrue && condition.Message != "" {
+			event.Reason = fmt.Sprintf("%s (%s: %s)", event.Reason, condition.Type, condition.Message)
+		}
+	}
+
+	a.notifier.Dispatch(event)
+}
+
+// sortPVCsByUsage orders the PVCs by usage ratio, the fullest first. PVCs
+// without metrics go last.
+func sortPVCsByUsage(pvcs []corev1.PersistentVolumeClaim, pvcsMetrics map[types.NamespacedName]*clients.PVCMetrics) []corev1.PersistentVolumeClaim {
+	usage := func(pvc *corev1.PersistentVolumeClaim) float64 {
+		metrics, ok := pvcsMetrics[types.NamespacedName{Namespace: pvc.Namespace, Name: pvc.Name}]
+		if !ok || metrics.VolumeCapacityBytes == 0 {
+			return -1
+		}
+		return float64(metrics.VolumeUsedBytes) / float64(metrics.VolumeCapacityBytes)
+	}
+
+	sorted := slices.Clone(pvcs)
+	slices.SortStableFunc(sorted, func(x, y corev1.PersistentVolumeClaim) int {
+		ux, uy := usage(&x), usage(&y)
+		switch {
+		case ux > uy:
+			return -1
+		case ux < uy:
+			return 1
+		default:
+			return 0
+		}
+	})
+
+	return sorted
+}
+
+// recordOriginalSize writes the requested size of a PVC seen for the first
+// time in the original size annotation, relative ceilings are based on it.
+// The PVC is returned unchanged if the annotation could not be written.
+func (a *PVCAutoscaler) recordOriginalSize(ctx context.Context, pvc *corev1.PersistentVolumeClaim) *corev1.PersistentVolumeClaim {
+	if _, ok := pvc.Annotations[policy.OriginalSizeAnnotation]; ok {
+		return pvc
+	}
+	pvcId := fmt.Sprintf("%s/%s", pvc.Namespace, pvc.Name)
+
+	originalSize := pvc.Spec.Resources.Requests.Storage().String()
+	patched, err := a.patchAnnotations(ctx, pvc, map[string]*string{policy.OriginalSizeAnnotation: &originalSize})
+	if err != nil {
+		a.logger.Warnf("could not record the original size of %s: %v", pvcId, err)
+		return pvc
+	}
+	a.logger.Debugf("original size %s recorded for %s", originalSize, pvcId)
+
+	return patched
+}
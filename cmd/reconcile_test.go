@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/lorenzophys/pvc-autoscaler/internal/history"
+	clients "github.com/lorenzophys/pvc-autoscaler/internal/metrics_clients/clients"
+	"github.com/lorenzophys/pvc-autoscaler/internal/policy"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+type staticMetricsClient map[types.NamespacedName]*clients.PVCMetrics
+
+func (c staticMetricsClient) FetchPVCsMetrics(context.Context, time.Time) (map[types.NamespacedName]*clients.PVCMetrics, error) {
+	return c, nil
+}
+
+func newReconcileTestPVC(name string) *corev1.PersistentVolumeClaim {
+	storageClassName := "standard"
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      name,
+			Annotations: map[string]string{
+				policy.EnabledAnnotation: "true",
+				policy.CeilingAnnotation: "20Gi",
+			},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			StorageClassName: &storageClassName,
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")},
+			},
+		},
+		Status: corev1.PersistentVolumeClaimStatus{
+			Phase:    corev1.ClaimBound,
+			Capacity: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")},
+		},
+	}
+}
+
+func TestSortPVCsByUsage(t *testing.T) {
+	var pvcs []corev1.PersistentVolumeClaim
+	for _, name := range []string{"no-metrics", "half", "zero-capacity", "full", "quarter", "also-half"} {
+		pvcs = append(pvcs, *newReconcileTestPVC(name))
+	}
+	metrics := map[types.NamespacedName]*clients.PVCMetrics{
+		{Namespace: "default", Name: "half"}:          {VolumeUsedBytes: 50, VolumeCapacityBytes: 100},
+		{Namespace: "default", Name: "zero-capacity"}: {VolumeUsedBytes: 50},
+		{Namespace: "default", Name: "full"}:          {VolumeUsedBytes: 99, VolumeCapacityBytes: 100},
+		{Namespace: "default", Name: "quarter"}:       {VolumeUsedBytes: 25, VolumeCapacityBytes: 100},
+		{Namespace: "default", Name: "also-half"}:     {VolumeUsedBytes: 100, VolumeCapacityBytes: 200},
+	}
+
+	sorted := sortPVCsByUsage(pvcs, metrics)
+
+	var names []string
+	for _, pvc := range sorted {
+		names = append(names, pvc.Name)
+	}
+	// Ties and the PVCs without usage keep their order
+	assert.Equal(t, []string{"full", "half", "also-half", "quarter", "no-metrics", "zero-capacity"}, names)
+	assert.Equal(t, "no-metrics", pvcs[0].Name, "the input is left unsorted")
+}
+
+func TestReconcileWorkers(t *testing.T) {
+	const count = 50
+
+	expandable := true
+	objects := []runtime.Object{&storagev1.StorageClass{
+		ObjectMeta:           metav1.ObjectMeta{Name: "standard"},
+		AllowVolumeExpansion: &expandable,
+	}}
+	metrics := staticMetricsClient{}
+	for i := 0; i < count; i++ {
+		name := fmt.Sprintf("data-%d", i)
+		objects = append(objects, newReconcileTestPVC(name))
+		// Some PVCs have no metrics, they are processed too
+		if i%5 != 0 {
+			metrics[types.NamespacedName{Namespace: "default", Name: name}] = &clients.PVCMetrics{VolumeUsedBytes: int64(i) << 20, VolumeCapacityBytes: 10 << 30}
+		}
+	}
+
+	for _, workers := range []int{1, 4, 16} {
+		t.Run(fmt.Sprintf("%d workers", workers), func(t *testing.T) {
+			a := &PVCAutoscaler{
+				kubeClient:        fake.NewSimpleClientset(objects...),
+				metricsClient:     metrics,
+				logger:            newTestLogger(),
+				defaultThreshold:  "80%",
+				defaultIncrease:   "20%",
+				namespaceSelector: labels.Everything(),
+				pvcSelector:       labels.Everything(),
+				workers:           workers,
+				history:           history.NewRecorder(10),
+			}
+
+			err := a.reconcile(context.TODO(), nil, nil)
+
+			// The PVCs without metrics return an error, once each
+			joined, ok := err.(interface{ Unwrap() []error })
+			if assert.True(t, ok) {
+				assert.Len(t, joined.Unwrap(), count/5)
+			}
+			assert.Len(t, a.history.List(), count)
+			for i := 0; i < count; i++ {
+				state, ok := a.history.Get(types.NamespacedName{Namespace: "default", Name: fmt.Sprintf("data-%d", i)})
+				assert.True(t, ok)
+				assert.Len(t, state.Decisions, 1, "data-%d should be processed exactly once", i)
+			}
+		})
+	}
+}
+
+func TestReconcileStop(t *testing.T) {
+	expandable := true
+	objects := []runtime.Object{&storagev1.StorageClass{
+		ObjectMeta:           metav1.ObjectMeta{Name: "standard"},
+		AllowVolumeExpansion: &expandable,
+	}}
+	metrics := staticMetricsClient{}
+	for i := 0; i < 5; i++ {
+		name := fmt.Sprintf("data-%d", i)
+		objects = append(objects, newReconcileTestPVC(name))
+		metrics[types.NamespacedName{Namespace: "default", Name: name}] = &clients.PVCMetrics{VolumeUsedBytes: 9 << 30, VolumeCapacityBytes: 10 << 30}
+	}
+	kubeClient := fake.NewSimpleClientset(objects...)
+	a := &PVCAutoscaler{
+		kubeClient:        kubeClient,
+		metricsClient:     metrics,
+		logger:            newTestLogger(),
+		defaultThreshold:  "80%",
+		defaultIncrease:   "20%",
+		namespaceSelector: labels.Everything(),
+		pvcSelector:       labels.Everything(),
+		workers:           1,
+		history:           history.NewRecorder(10),
+	}
+	stop := make(chan struct{})
+	close(stop)
+
+	err := a.reconcile(context.TODO(), stop, nil)
+
+	assert.NoError(t, err)
+	assert.Empty(t, a.history.List(), "no pvc is processed after the shutdown started")
+	for _, action := range kubeClient.Actions() {
+		assert.NotEqual(t, "update", action.GetVerb())
+	}
+}
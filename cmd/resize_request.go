@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lorenzophys/pvc-autoscaler/internal/policy"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// checkResizeRequestQuota checks that a requested resize fits in the
+// ResourceQuotas of the namespace of the PVC
+func (a *PVCAutoscaler) checkResizeRequestQuota(ctx context.Context, pvc *corev1.PersistentVolumeClaim, newStorage resource.Quantity) error {
+	quotas, err := a.kubeClient.CoreV1().ResourceQuotas(pvc.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("could not list the ResourceQuotas of %s: %w", pvc.Namespace, err)
+	}
+
+	return policy.CheckStorageQuota(pvc, newStorage, quotas.Items)
+}
+
+// resizeRequestDone returns the annotations to apply once a resize request
+// is handled: the request is removed and the outcome recorded
+func resizeRequestDone(result string) map[string]*string {
+	result = fmt.Sprintf("%s: %s", time.Now().UTC().Format(time.RFC3339), result)
+	return map[string]*string{
+		policy.ResizeRequestAnnotation:       nil,
+		policy.ResizeRequestResultAnnotation: &result,
+	}
+}
+
+// rejectResizeRequest removes a resize request that cannot be applied, so
+// that the PVC goes back to the automatic resizes
+func (a *PVCAutoscaler) rejectResizeRequest(ctx context.Context, pvc *corev1.PersistentVolumeClaim, reason error) {
+	pvcId := fmt.Sprintf("%s/%s", pvc.Namespace, pvc.Name)
+
+	a.logger.Warnf("resize request of %s rejected: %v", pvcId, reason)
+	if _, err := a.patchAnnotations(ctx, pvc, resizeRequestDone("rejected, "+reason.Error())); err != nil {
+		a.logger.Errorf("could not remove the resize request of %s: %v", pvcId, err)
+	}
+}
+
+// patchAnnotations merge patches the annotations of the PVC, a nil value
+// removes the annotation. It returns the patched PVC.
+func (a *PVCAutoscaler) patchAnnotations(ctx context.Context, pvc *corev1.PersistentVolumeClaim, annotations map[string]*string) (*corev1.PersistentVolumeClaim, error) {
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return a.kubeClient.CoreV1().PersistentVolumeClaims(pvc.Namespace).Patch(ctx, pvc.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// run reconciles right away and then every polling interval, until ctx is
+// cancelled. Config changes are applied before each reconciliation. In
+// between, the PVCs received from Alertmanager are reconciled right away.
+// The running reconciliation is cancelled with shutdownCtx.
+func (a *PVCAutoscaler) run(ctx, shutdownCtx context.Context) {
+	pollingInterval := a.pollingInterval
+	ticker := time.NewTicker(pollingInterval)
+	defer ticker.Stop()
+
+	for {
+		a.reloadConfig()
+		if a.pollingInterval != pollingInterval {
+			pollingInterval = a.pollingInterval
+			ticker.Reset(pollingInterval)
+		}
+
+		a.runReconcile(ctx, shutdownCtx, nil, a.reconcileTimeout)
+
+		if !a.waitForTick(ctx, shutdownCtx, ticker) {
+			return
+		}
+	}
+}
+
+// waitForTick reconciles the PVCs received from Alertmanager until the next
+// tick, it returns false when ctx is cancelled
+func (a *PVCAutoscaler) waitForTick(ctx, shutdownCtx context.Context, ticker *time.Ticker) bool {
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+			return true
+		case <-a.alerts.Triggered():
+			pvcs := a.alerts.Take()
+			if len(pvcs) == 0 {
+				continue
+			}
+			a.logger.Infof("reconciling %d pvcs on alertmanager request", len(pvcs))
+			a.runReconcile(ctx, shutdownCtx, pvcs, a.reconcileTimeout)
+		}
+	}
+}
+
+// runReconcile runs a single reconciliation, of all the PVCs if only is nil.
+// Its context is not a child of ctx: when ctx is cancelled no new PVC is
+// processed, and the ones being processed get until shutdownCtx is cancelled
+// to finish, so that in-flight updates are not interrupted.
+func (a *PVCAutoscaler) runReconcile(ctx, shutdownCtx context.Context, only []types.NamespacedName, reconcileTimeout time.Duration) {
+	if ctx.Err() != nil {
+		return
+	}
+
+	reconcileCtx, cancel := context.WithTimeout(shutdownCtx, reconcileTimeout)
+	defer cancel()
+
+	stop := context.AfterFunc(ctx, func() {
+		a.logger.Info("shutdown requested, waiting for the pvcs being processed")
+	})
+	defer stop()
+
+	err := a.reconcile(reconcileCtx, ctx.Done(), only)
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, pvcErr := range joined.Unwrap() {
+			a.logger.Errorf("failed to reconcile: %v", pvcErr)
+		}
+	} else if err != nil {
+		a.logger.Errorf("failed to reconcile: %v", err)
+	}
+}
+
+// shutdownContext returns a context cancelled gracePeriod after ctx. The
+// steps of the shutdown share it, so that together they finish within the
+// grace period.
+func shutdownContext(ctx context.Context, gracePeriod time.Duration) (context.Context, context.CancelFunc) {
+	shutdownCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, func() {
+		time.AfterFunc(gracePeriod, cancel)
+	})
+
+	return shutdownCtx, func() {
+		stop()
+		cancel()
+	}
+}
+
+func shutdownServers(ctx context.Context, servers []*http.Server) error {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for _, server := range servers {
+		wg.Add(1)
+		go func(server *http.Server) {
+			defer wg.Done()
+			if err := server.Shutdown(ctx); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}(server)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShutdownContext(t *testing.T) {
+	ctx, signal := context.WithCancel(context.Background())
+	shutdownCtx, cancel := shutdownContext(ctx, 50*time.Millisecond)
+	defer cancel()
+
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, shutdownCtx.Err(), "the grace period starts with the signal")
+
+	signal()
+	start := time.Now()
+	<-shutdownCtx.Done()
+
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	snapshotManagedByLabel = PVCAutoscalerAnnotationPrefix + "managed-by"
+	// snapshotPVCLabel holds the UID of the PVC, its name may be longer
+	// than a label value
+	snapshotPVCLabel = PVCAutoscalerAnnotationPrefix + "pvc-uid"
+)
+
+var volumeSnapshotResource = schema.GroupVersionResource{
+	Group:    "snapshot.storage.k8s.io",
+	Version:  "v1",
+	Resource: "volumesnapshots",
+}
+
+// snapshotPVC creates a VolumeSnapshot of the PVC, or gets the one created
+// by a previous reconciliation, and reports whether it is ready to use. It
+// does not wait, the snapshot name depends on the current capacity so the
+// next reconciliation checks the same snapshot again. A snapshot not ready
+// within the snapshot timeout or reporting an error is an error, it is
+// deleted so that the next reconciliation takes a new one.
+func (a *PVCAutoscaler) snapshotPVC(ctx context.Context, pvc *corev1.PersistentVolumeClaim, snapshotClass string, capacityBytes int64) (bool, error) {
+	pvcId := fmt.Sprintf("%s/%s", pvc.Namespace, pvc.Name)
+	snapshotName := fmt.Sprintf("%s-autoscaler-%d", pvc.Name, capacityBytes)
+	snapshots := a.dynamicClient.Resource(volumeSnapshotResource).Namespace(pvc.Namespace)
+
+	snapshot, err := snapshots.Get(ctx, snapshotName, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return false, fmt.Errorf("failed to get VolumeSnapshot %s: %w", snapshotName, err)
+	}
+	if apierrors.IsNotFound(err) {
+		snapshot = newVolumeSnapshot(pvc, snapshotName, snapshotClass)
+		snapshot, err = snapshots.Create(ctx, snapshot, metav1.CreateOptions{})
+		if err != nil {
+			return false, fmt.Errorf("failed to create VolumeSnapshot %s: %w", snapshotName, err)
+		}
+		a.logger.Infof("created VolumeSnapshot %s for %s", snapshotName, pvcId)
+	}
+
+	var failure error
+	if message, failed := snapshotError(snapshot); failed {
+		failure = fmt.Errorf("VolumeSnapshot %s failed: %s", snapshotName, message)
+	} else if !isSnapshotReady(snapshot) {
+		created := snapshot.GetCreationTimestamp()
+		if created.IsZero() || time.Since(created.Time) <= a.snapshotTimeout {
+			return false, nil
+		}
+		failure = fmt.Errorf("VolumeSnapshot %s not ready after %s", snapshotName, a.snapshotTimeout)
+	}
+	if failure != nil {
+		if err := snapshots.Delete(ctx, snapshotName, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return false, fmt.Errorf("%w, and could not be deleted: %w", failure, err)
+		}
+		a.logger.Infof("deleted failed VolumeSnapshot %s of %s, a new one is taken at the next reconciliation", snapshotName, pvcId)
+		return false, failure
+	}
+	a.logger.Infof("VolumeSnapshot %s for %s is ready to use", snapshotName, pvcId)
+
+	if err := a.pruneSnapshots(ctx, pvc); err != nil {
+		// The snapshot we need is there, so the resize can go on anyway
+		a.logger.Errorf("failed to prune old snapshots of %s: %v", pvcId, err)
+	}
+
+	return true, nil
+}
+
+// pruneSnapshots deletes the oldest autoscaler-created snapshots of the PVC
+// exceeding the retention count, the failed ones do not count
+func (a *PVCAutoscaler) pruneSnapshots(ctx context.Context, pvc *corev1.PersistentVolumeClaim) error {
+	snapshots := a.dynamicClient.Resource(volumeSnapshotResource).Namespace(pvc.Namespace)
+
+	list, err := snapshots.List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=pvc-autoscaler,%s=%s", snapshotManagedByLabel, snapshotPVCLabel, pvc.UID),
+	})
+	if err != nil {
+		return err
+	}
+	items := slices.DeleteFunc(list.Items, func(item unstructured.Unstructured) bool {
+		_, failed := snapshotError(&item)
+		return failed
+	})
+	if len(items) <= a.snapshotRetention {
+		return nil
+	}
+
+	slices.SortFunc(items, func(x, y unstructured.Unstructured) int {
+		return x.GetCreationTimestamp().Compare(y.GetCreationTimestamp().Time)
+	})
+
+	for _, item := range items[:len(items)-a.snapshotRetention] {
+		err := snapshots.Delete(ctx, item.GetName(), metav1.DeleteOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to delete VolumeSnapshot %s: %w", item.GetName(), err)
+		}
+		a.logger.Infof("deleted old VolumeSnapshot %s/%s", pvc.Namespace, item.GetName())
+	}
+
+	return nil
+}
+
+func newVolumeSnapshot(pvc *corev1.PersistentVolumeClaim, name, snapshotClass string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]any{
+			"apiVersion": "snapshot.storage.k8s.io/v1",
+			"kind":       "VolumeSnapshot",
+			"metadata": map[string]any{
+				"name":      name,
+				"namespace": pvc.Namespace,
+				"labels": map[string]any{
+					snapshotManagedByLabel: "pvc-autoscaler",
+					snapshotPVCLabel:       string(pvc.UID),
+				},
+			},
+			"spec": map[string]any{
+				"volumeSnapshotClassName": snapshotClass,
+				"source": map[string]any{
+					"persistentVolumeClaimName": pvc.Name,
+				},
+			},
+		},
+	}
+}
+
+func isSnapshotReady(snapshot *unstructured.Unstructured) bool {
+	ready, found, _ := unstructured.NestedBool(snapshot.Object, "status", "readyToUse")
+	return found && ready
+}
+
+// snapshotError returns the error message of a failed snapshot
+func snapshotError(snapshot *unstructured.Unstructured) (string, bool) {
+	message, found, _ := unstructured.NestedString(snapshot.Object, "status", "error", "message")
+	return message, found
+}
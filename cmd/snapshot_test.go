@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func newTestLogger() *log.Logger {
+	logger := log.New()
+	logger.Out = io.Discard
+	return logger
+}
+
+func newSnapshotTestPVC(name string) *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, UID: types.UID("0b6e3f5c-6f0a-4b43-9a55-7c2d1c8f1e2a")},
+	}
+}
+
+// newTestSnapshot returns an autoscaler-created snapshot of pvc, created age
+// ago, with the given status
+func newTestSnapshot(pvc *corev1.PersistentVolumeClaim, name string, age time.Duration, status map[string]any) *unstructured.Unstructured {
+	snapshot := newVolumeSnapshot(pvc, name, "csi-vsc")
+	snapshot.SetCreationTimestamp(metav1.NewTime(time.Now().Add(-age)))
+	if status != nil {
+		snapshot.Object["status"] = status
+	}
+	return snapshot
+}
+
+func newSnapshotTestAutoscaler(objects ...runtime.Object) (*PVCAutoscaler, *dynamicfake.FakeDynamicClient) {
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		volumeSnapshotResource: "VolumeSnapshotList",
+	}, objects...)
+
+	return &PVCAutoscaler{
+		dynamicClient:     client,
+		logger:            newTestLogger(),
+		snapshotTimeout:   5 * time.Minute,
+		snapshotRetention: 2,
+	}, client
+}
+
+func listSnapshots(t *testing.T, client *dynamicfake.FakeDynamicClient) []string {
+	t.Helper()
+
+	list, err := client.Resource(volumeSnapshotResource).Namespace("default").List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+
+	var names []string
+	for _, item := range list.Items {
+		names = append(names, item.GetName())
+	}
+	return names
+}
+
+func TestSnapshotPVC(t *testing.T) {
+	t.Run("creates the snapshot without waiting", func(t *testing.T) {
+		pvc := newSnapshotTestPVC(strings.Repeat("a", 100))
+		a, client := newSnapshotTestAutoscaler()
+
+		ready, err := a.snapshotPVC(context.TODO(), pvc, "csi-vsc", 10<<30)
+
+		assert.NoError(t, err)
+		assert.False(t, ready)
+		snapshot, err := client.Resource(volumeSnapshotResource).Namespace("default").Get(context.TODO(), pvc.Name+"-autoscaler-10737418240", metav1.GetOptions{})
+		assert.NoError(t, err)
+		// The name of the pvc is too long for a label value
+		assert.Equal(t, string(pvc.UID), snapshot.GetLabels()[snapshotPVCLabel])
+		class, _, _ := unstructured.NestedString(snapshot.Object, "spec", "volumeSnapshotClassName")
+		assert.Equal(t, "csi-vsc", class)
+	})
+
+	t.Run("resumes a pending snapshot", func(t *testing.T) {
+		pvc := newSnapshotTestPVC("data")
+		a, client := newSnapshotTestAutoscaler(newTestSnapshot(pvc, "data-autoscaler-100", time.Minute, map[string]any{"readyToUse": false}))
+
+		ready, err := a.snapshotPVC(context.TODO(), pvc, "csi-vsc", 100)
+
+		assert.NoError(t, err)
+		assert.False(t, ready)
+		assert.Equal(t, []string{"data-autoscaler-100"}, listSnapshots(t, client))
+	})
+
+	t.Run("ready snapshot", func(t *testing.T) {
+		pvc := newSnapshotTestPVC("data")
+		a, _ := newSnapshotTestAutoscaler(newTestSnapshot(pvc, "data-autoscaler-100", time.Minute, map[string]any{"readyToUse": true}))
+
+		ready, err := a.snapshotPVC(context.TODO(), pvc, "csi-vsc", 100)
+
+		assert.NoError(t, err)
+		assert.True(t, ready)
+	})
+
+	t.Run("error status", func(t *testing.T) {
+		pvc := newSnapshotTestPVC("data")
+		a, client := newSnapshotTestAutoscaler(newTestSnapshot(pvc, "data-autoscaler-100", time.Minute, map[string]any{
+			"readyToUse": false,
+			"error":      map[string]any{"message": "quota exceeded"},
+		}))
+
+		ready, err := a.snapshotPVC(context.TODO(), pvc, "csi-vsc", 100)
+
+		assert.ErrorContains(t, err, "quota exceeded")
+		assert.False(t, ready)
+		assert.Empty(t, listSnapshots(t, client), "the failed snapshot is deleted")
+
+		// The next reconciliation takes a new snapshot
+		ready, err = a.snapshotPVC(context.TODO(), pvc, "csi-vsc", 100)
+
+		assert.NoError(t, err)
+		assert.False(t, ready)
+		assert.Equal(t, []string{"data-autoscaler-100"}, listSnapshots(t, client))
+	})
+
+	t.Run("not ready after the timeout", func(t *testing.T) {
+		pvc := newSnapshotTestPVC("data")
+		a, client := newSnapshotTestAutoscaler(newTestSnapshot(pvc, "data-autoscaler-100", 10*time.Minute, map[string]any{"readyToUse": false}))
+
+		ready, err := a.snapshotPVC(context.TODO(), pvc, "csi-vsc", 100)
+
+		assert.ErrorContains(t, err, "not ready after 5m0s")
+		assert.False(t, ready)
+		assert.Empty(t, listSnapshots(t, client), "the timed out snapshot is deleted")
+	})
+
+	t.Run("prunes the oldest snapshots once ready", func(t *testing.T) {
+		pvc := newSnapshotTestPVC("data")
+		other := newSnapshotTestPVC("other")
+		other.UID = "4c1d0e8a-2f7b-4d0e-8a55-1b2c3d4e5f60"
+		a, client := newSnapshotTestAutoscaler(
+			newTestSnapshot(pvc, "data-autoscaler-25", 3*time.Hour, nil),
+			newTestSnapshot(pvc, "data-autoscaler-50", 2*time.Hour, nil),
+			newTestSnapshot(pvc, "data-autoscaler-75", time.Hour, nil),
+			newTestSnapshot(pvc, "data-autoscaler-100", time.Minute, map[string]any{"readyToUse": true}),
+			newTestSnapshot(other, "other-autoscaler-10", 4*time.Hour, nil),
+		)
+
+		ready, err := a.snapshotPVC(context.TODO(), pvc, "csi-vsc", 100)
+
+		assert.NoError(t, err)
+		assert.True(t, ready)
+		assert.ElementsMatch(t, []string{"data-autoscaler-75", "data-autoscaler-100", "other-autoscaler-10"}, listSnapshots(t, client))
+	})
+
+	t.Run("failed snapshots do not count in the retention", func(t *testing.T) {
+		pvc := newSnapshotTestPVC("data")
+		failed := map[string]any{"readyToUse": false, "error": map[string]any{"message": "quota exceeded"}}
+		a, client := newSnapshotTestAutoscaler(
+			newTestSnapshot(pvc, "data-autoscaler-50", 2*time.Hour, nil),
+			newTestSnapshot(pvc, "data-autoscaler-75", time.Hour, failed),
+			newTestSnapshot(pvc, "data-autoscaler-100", time.Minute, map[string]any{"readyToUse": true}),
+		)
+
+		ready, err := a.snapshotPVC(context.TODO(), pvc, "csi-vsc", 100)
+
+		assert.NoError(t, err)
+		assert.True(t, ready)
+		assert.ElementsMatch(t, []string{"data-autoscaler-50", "data-autoscaler-75", "data-autoscaler-100"}, listSnapshots(t, client))
+	})
+}
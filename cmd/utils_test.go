@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/lorenzophys/pvc-autoscaler/internal/policy"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newUtilsTestPVC(name, storageClassName string, annotations map[string]string) *corev1.PersistentVolumeClaim {
+	pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, Annotations: annotations}}
+	if storageClassName != "" {
+		pvc.Spec.StorageClassName = &storageClassName
+	}
+	return pvc
+}
+
+func TestGetAnnotatedPVCs(t *testing.T) {
+	kubeClient := fake.NewSimpleClientset(
+		&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "managed", Annotations: map[string]string{policy.EnabledAnnotation: "true"}}},
+		&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "unmanaged"}},
+		newUtilsTestPVC("through-storageclass", "managed", nil),
+		newUtilsTestPVC("opted-out", "managed", map[string]string{policy.EnabledAnnotation: "false"}),
+		newUtilsTestPVC("annotated", "unmanaged", map[string]string{policy.EnabledAnnotation: "true"}),
+		newUtilsTestPVC("not-annotated", "unmanaged", nil),
+		newUtilsTestPVC("missing-storageclass", "deleted", nil),
+		newUtilsTestPVC("no-storageclass", "", map[string]string{policy.EnabledAnnotation: "true"}),
+	)
+
+	pvcs, err := getAnnotatedPVCs(context.TODO(), kubeClient, labels.Everything(), labels.Everything())
+
+	assert.NoError(t, err)
+	var names []string
+	for _, pvc := range pvcs.Items {
+		names = append(names, pvc.Name)
+	}
+	assert.ElementsMatch(t, []string{"through-storageclass", "annotated", "no-storageclass"}, names)
+}
+
+func TestUpdatePVCWithNewStorageSize(t *testing.T) {
+	// Enabled through its StorageClass, the PVC has no annotations
+	pvc := newUtilsTestPVC("data", "managed", nil)
+	pvc.Spec.Resources.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+	kubeClient := fake.NewSimpleClientset(pvc)
+	a := &PVCAutoscaler{kubeClient: kubeClient, logger: newTestLogger()}
+
+	newSize := resource.MustParse("12Gi")
+	err := a.updatePVCWithNewStorageSize(context.TODO(), pvc.DeepCopy(), 10<<30, &newSize, "", nil)
+
+	assert.NoError(t, err)
+	updated, err := kubeClient.CoreV1().PersistentVolumeClaims("default").Get(context.TODO(), "data", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "12Gi", updated.Spec.Resources.Requests.Storage().String())
+	assert.Equal(t, "10737418240", updated.Annotations[PVCAutoscalerPreviousCapacityAnnotation])
+	assert.NotEmpty(t, updated.Annotations[PVCAutoscalerLastResizeAnnotation])
+}
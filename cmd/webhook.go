@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/lorenzophys/pvc-autoscaler/internal/webhook"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes"
+)
+
+// newWebhookServer serves the mutating webhook, kubeClient returns the client
+// of the current config
+func newWebhookServer(kubeClient func() kubernetes.Interface, configPath, address string, logger *log.Logger) (*http.Server, error) {
+	config, err := webhook.LoadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	logger.Infof("loaded %d webhook rules from %s", len(config.Rules), configPath)
+
+	mux := http.NewServeMux()
+	mux.Handle("/mutate", webhook.NewMutator(kubeClient, config, PVCAutoscalerAnnotationPrefix, logger))
+
+	return &http.Server{
+		Addr:              address,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}, nil
+}
@@ -0,0 +1,141 @@
+// Package agent collects the filesystem usage of the persistent volumes
+// mounted on a node, it runs as a DaemonSet next to the kubelet
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const VolumesPath = "/volumes"
+
+// The volume plugins that never hold a persistent volume
+var ephemeralPlugins = []string{
+	"kubernetes.io~empty-dir",
+	"kubernetes.io~configmap",
+	"kubernetes.io~secret",
+	"kubernetes.io~projected",
+	"kubernetes.io~downward-api",
+}
+
+// Volume is the usage of a persistent volume mounted on the node
+type Volume struct {
+	Namespace     string    `json:"namespace"`
+	PVC           string    `json:"pvc"`
+	PV            string    `json:"pv"`
+	UsedBytes     int64     `json:"usedBytes"`
+	CapacityBytes int64     `json:"capacityBytes"`
+	Timestamp     time.Time `json:"timestamp"`
+}
+
+type Collector struct {
+	kubeletDir string
+	logger     *log.Logger
+
+	// The persistent volumes are watched, listing them on each request
+	// would load the API server with one list per node and poll
+	informerFactory informers.SharedInformerFactory
+	pvLister        corelisters.PersistentVolumeLister
+	pvSynced        cache.InformerSynced
+
+	// statfs returns the used and total bytes of the filesystem at path
+	statfs func(path string) (int64, int64, error)
+}
+
+func NewCollector(kubeletDir string, kubeClient kubernetes.Interface, logger *log.Logger) *Collector {
+	informerFactory := informers.NewSharedInformerFactory(kubeClient, 0)
+	pvInformer := informerFactory.Core().V1().PersistentVolumes()
+
+	return &Collector{
+		kubeletDir:      kubeletDir,
+		logger:          logger,
+		informerFactory: informerFactory,
+		pvLister:        pvInformer.Lister(),
+		pvSynced:        pvInformer.Informer().HasSynced,
+		statfs:          statfs,
+	}
+}
+
+// Start watches the persistent volumes until ctx is done, it returns once
+// they are all cached
+func (c *Collector) Start(ctx context.Context) error {
+	c.informerFactory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), c.pvSynced) {
+		return errors.New("could not sync the persistent volume cache")
+	}
+
+	return nil
+}
+
+// Collect statfs-es the volumes under <kubelet dir>/pods/*/volumes. The
+// directory of a persistent volume is named after it, the PVC is found
+// through the claim of the cached PersistentVolume.
+func (c *Collector) Collect(ctx context.Context) ([]Volume, error) {
+	if !c.pvSynced() {
+		return nil, errors.New("the persistent volume cache is not synced yet")
+	}
+
+	dirs, err := filepath.Glob(filepath.Join(c.kubeletDir, "pods", "*", "volumes", "*", "*"))
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var volumes []Volume
+	for _, dir := range dirs {
+		if slices.Contains(ephemeralPlugins, filepath.Base(filepath.Dir(dir))) {
+			continue
+		}
+		pv, err := c.pvLister.Get(filepath.Base(dir))
+		if err != nil || pv.Spec.ClaimRef == nil || seen[pv.Name] {
+			continue
+		}
+		volume := Volume{Namespace: pv.Spec.ClaimRef.Namespace, PVC: pv.Spec.ClaimRef.Name, PV: pv.Name}
+
+		// CSI volumes are mounted in a subdirectory
+		path := dir
+		if info, err := os.Stat(filepath.Join(dir, "mount")); err == nil && info.IsDir() {
+			path = filepath.Join(dir, "mount")
+		}
+
+		used, capacity, err := c.statfs(path)
+		if err != nil {
+			c.logger.Debugf("could not statfs %s: %v", path, err)
+			continue
+		}
+
+		seen[volume.PV] = true
+		volume.UsedBytes = used
+		volume.CapacityBytes = capacity
+		volume.Timestamp = time.Now()
+		volumes = append(volumes, volume)
+	}
+
+	return volumes, nil
+}
+
+func (c *Collector) RegisterHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("GET "+VolumesPath, func(w http.ResponseWriter, r *http.Request) {
+		volumes, err := c.Collect(r.Context())
+		if err != nil {
+			c.logger.Errorf("could not collect the volumes: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(volumes)
+	})
+}
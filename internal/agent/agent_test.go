@@ -0,0 +1,127 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newPV(name, namespace, pvc string) *corev1.PersistentVolume {
+	return &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: corev1.PersistentVolumeSpec{
+			ClaimRef: &corev1.ObjectReference{Namespace: namespace, Name: pvc},
+		},
+	}
+}
+
+func mkdir(t *testing.T, path ...string) string {
+	t.Helper()
+	dir := filepath.Join(path...)
+	assert.NoError(t, os.MkdirAll(dir, 0o755))
+	return dir
+}
+
+func newTestCollector(t *testing.T) (*Collector, map[string]bool) {
+	c, statted, _ := newUnstartedTestCollector(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	assert.NoError(t, c.Start(ctx))
+
+	return c, statted
+}
+
+func newUnstartedTestCollector(t *testing.T) (*Collector, map[string]bool, *fake.Clientset) {
+	kubeletDir := t.TempDir()
+	mkdir(t, kubeletDir, "pods", "pod-a", "volumes", "kubernetes.io~csi", "pv-data", "mount")
+	mkdir(t, kubeletDir, "pods", "pod-b", "volumes", "kubernetes.io~csi", "pv-data", "mount")
+	mkdir(t, kubeletDir, "pods", "pod-b", "volumes", "kubernetes.io~aws-ebs", "pv-logs")
+	mkdir(t, kubeletDir, "pods", "pod-b", "volumes", "kubernetes.io~empty-dir", "pv-logs")
+	mkdir(t, kubeletDir, "pods", "pod-b", "volumes", "kubernetes.io~csi", "pv-unknown", "mount")
+
+	kubeClient := fake.NewSimpleClientset(
+		newPV("pv-data", "default", "data"),
+		newPV("pv-logs", "monitoring", "logs"),
+		&corev1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pv-unbound"}},
+	)
+
+	logger := log.New()
+	logger.Out = io.Discard
+
+	statted := make(map[string]bool)
+	c := NewCollector(kubeletDir, kubeClient, logger)
+	c.statfs = func(path string) (int64, int64, error) {
+		rel, _ := filepath.Rel(kubeletDir, path)
+		statted[rel] = true
+		return 40, 100, nil
+	}
+
+	return c, statted, kubeClient
+}
+
+func TestCollect(t *testing.T) {
+	c, statted := newTestCollector(t)
+
+	volumes, err := c.Collect(context.TODO())
+
+	assert.NoError(t, err)
+	assert.Len(t, volumes, 2)
+	assert.Equal(t, "default", volumes[0].Namespace)
+	assert.Equal(t, "data", volumes[0].PVC)
+	assert.Equal(t, int64(40), volumes[0].UsedBytes)
+	assert.Equal(t, int64(100), volumes[0].CapacityBytes)
+	assert.Equal(t, "logs", volumes[1].PVC)
+	// CSI volumes are statted in the mount directory, each volume once
+	assert.Equal(t, map[string]bool{
+		"pods/pod-a/volumes/kubernetes.io~csi/pv-data/mount": true,
+		"pods/pod-b/volumes/kubernetes.io~aws-ebs/pv-logs":   true,
+	}, statted)
+}
+
+func TestCollectFromCache(t *testing.T) {
+	c, _, kubeClient := newUnstartedTestCollector(t)
+
+	_, err := c.Collect(context.TODO())
+	assert.Error(t, err, "the cache is not synced before Start")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.NoError(t, c.Start(ctx))
+	actions := len(kubeClient.Actions())
+
+	for i := 0; i < 3; i++ {
+		volumes, err := c.Collect(context.TODO())
+		assert.NoError(t, err)
+		assert.Len(t, volumes, 2)
+	}
+	assert.Len(t, kubeClient.Actions(), actions, "the volumes are served from the cache")
+}
+
+func TestHandler(t *testing.T) {
+	c, _ := newTestCollector(t)
+	mux := http.NewServeMux()
+	c.RegisterHandlers(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + VolumesPath)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	var volumes []Volume
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&volumes))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Len(t, volumes, 2)
+}
@@ -0,0 +1,17 @@
+//go:build linux
+
+package agent
+
+import "syscall"
+
+// statfs computes the usage like the kubelet volume stats
+func statfs(path string) (int64, int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+
+	capacity := int64(st.Blocks) * st.Frsize
+	used := int64(st.Blocks-st.Bfree) * st.Frsize
+	return used, capacity, nil
+}
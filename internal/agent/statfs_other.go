@@ -0,0 +1,9 @@
+//go:build !linux
+
+package agent
+
+import "errors"
+
+func statfs(path string) (int64, int64, error) {
+	return 0, 0, errors.New("statfs is only supported on linux")
+}
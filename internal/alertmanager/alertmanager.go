@@ -0,0 +1,132 @@
+// Package alertmanager receives the Alertmanager webhook notifications, e.g.
+// of the KubePersistentVolumeFillingUp alert, so that the PVCs they are
+// about are evaluated right away instead of at the next polling interval
+package alertmanager
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	Path = "/alertmanager"
+
+	// Labels identifying the PVC of an alert, as set by kube-state-metrics
+	// and the kubelet volume stats
+	NamespaceLabel = "namespace"
+	PVCLabel       = "persistentvolumeclaim"
+
+	// Alertmanager sends at most a few hundred alerts per notification
+	maxPayloadBytes = 1 << 20
+)
+
+// Message is the subset of the Alertmanager webhook payload that is used
+type Message struct {
+	Version string  `json:"version"`
+	Status  string  `json:"status"`
+	Alerts  []Alert `json:"alerts"`
+}
+
+type Alert struct {
+	Status string            `json:"status"`
+	Labels map[string]string `json:"labels"`
+}
+
+// PVCs returns the PVCs of the firing alerts, the resolved ones are ignored
+func (m *Message) PVCs() []types.NamespacedName {
+	var pvcs []types.NamespacedName
+	for _, alert := range m.Alerts {
+		if alert.Status != "firing" {
+			continue
+		}
+		key := types.NamespacedName{Namespace: alert.Labels[NamespaceLabel], Name: alert.Labels[PVCLabel]}
+		if key.Namespace == "" || key.Name == "" || slices.Contains(pvcs, key) {
+			continue
+		}
+		pvcs = append(pvcs, key)
+	}
+
+	return pvcs
+}
+
+// Receiver collects the PVCs of the received alerts until they are taken by
+// the reconciliation loop. The PVCs received while a reconciliation runs are
+// merged so that each one is evaluated once.
+type Receiver struct {
+	logger *log.Logger
+
+	mu        sync.Mutex
+	pending   map[types.NamespacedName]struct{}
+	triggered chan struct{}
+}
+
+func NewReceiver(logger *log.Logger) *Receiver {
+	return &Receiver{
+		logger:    logger,
+		pending:   make(map[types.NamespacedName]struct{}),
+		triggered: make(chan struct{}, 1),
+	}
+}
+
+// Enqueue adds the PVCs to the pending ones and wakes up the loop
+func (r *Receiver) Enqueue(pvcs []types.NamespacedName) {
+	if len(pvcs) == 0 {
+		return
+	}
+
+	r.mu.Lock()
+	for _, key := range pvcs {
+		r.pending[key] = struct{}{}
+	}
+	r.mu.Unlock()
+
+	select {
+	case r.triggered <- struct{}{}:
+	default:
+	}
+}
+
+// Triggered receives a value when there are pending PVCs
+func (r *Receiver) Triggered() <-chan struct{} {
+	return r.triggered
+}
+
+// Take returns the pending PVCs sorted by namespace and name, and forgets
+// them
+func (r *Receiver) Take() []types.NamespacedName {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	pvcs := make([]types.NamespacedName, 0, len(r.pending))
+	for key := range r.pending {
+		pvcs = append(pvcs, key)
+	}
+	clear(r.pending)
+
+	slices.SortFunc(pvcs, func(a, b types.NamespacedName) int {
+		return strings.Compare(a.String(), b.String())
+	})
+	return pvcs
+}
+
+func (r *Receiver) RegisterHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("POST "+Path, func(w http.ResponseWriter, req *http.Request) {
+		var message Message
+		if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxPayloadBytes)).Decode(&message); err != nil {
+			http.Error(w, "invalid alertmanager payload: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		pvcs := message.PVCs()
+		r.logger.Debugf("received %d alerts from alertmanager, %d pvcs to evaluate", len(message.Alerts), len(pvcs))
+		r.Enqueue(pvcs)
+
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
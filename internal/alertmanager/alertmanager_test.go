@@ -0,0 +1,116 @@
+package alertmanager
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const payload = `{
+  "version": "4",
+  "status": "firing",
+  "receiver": "pvc-autoscaler",
+  "alerts": [
+    {
+      "status": "firing",
+      "labels": {"alertname": "KubePersistentVolumeFillingUp", "namespace": "monitoring", "persistentvolumeclaim": "prometheus-db", "severity": "warning"}
+    },
+    {
+      "status": "firing",
+      "labels": {"alertname": "KubePersistentVolumeFillingUp", "namespace": "default", "persistentvolumeclaim": "data", "severity": "critical"}
+    },
+    {
+      "status": "firing",
+      "labels": {"alertname": "KubePersistentVolumeFillingUp", "namespace": "default", "persistentvolumeclaim": "data", "severity": "warning"}
+    },
+    {
+      "status": "resolved",
+      "labels": {"alertname": "KubePersistentVolumeFillingUp", "namespace": "default", "persistentvolumeclaim": "logs"}
+    },
+    {
+      "status": "firing",
+      "labels": {"alertname": "NodeFilesystemAlmostOutOfSpace", "instance": "node-1"}
+    }
+  ]
+}`
+
+func newTestReceiver() *Receiver {
+	logger := log.New()
+	logger.Out = io.Discard
+	return NewReceiver(logger)
+}
+
+func post(t *testing.T, r *Receiver, body string) int {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	r.RegisterHandlers(mux)
+
+	req := httptest.NewRequest(http.MethodPost, Path, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+
+	return rec.Code
+}
+
+func TestHandler(t *testing.T) {
+	t.Run("firing alerts are enqueued", func(t *testing.T) {
+		r := newTestReceiver()
+
+		assert.Equal(t, http.StatusAccepted, post(t, r, payload))
+
+		select {
+		case <-r.Triggered():
+		default:
+			t.Fatal("the receiver was not triggered")
+		}
+		assert.Equal(t, []types.NamespacedName{
+			{Namespace: "default", Name: "data"},
+			{Namespace: "monitoring", Name: "prometheus-db"},
+		}, r.Take())
+		assert.Empty(t, r.Take())
+	})
+
+	t.Run("no pvc in the alerts", func(t *testing.T) {
+		r := newTestReceiver()
+
+		assert.Equal(t, http.StatusAccepted, post(t, r, `{"version": "4", "alerts": [{"status": "firing", "labels": {"alertname": "Watchdog"}}]}`))
+
+		select {
+		case <-r.Triggered():
+			t.Fatal("the receiver should not be triggered")
+		default:
+		}
+	})
+
+	t.Run("invalid payload", func(t *testing.T) {
+		r := newTestReceiver()
+
+		assert.Equal(t, http.StatusBadRequest, post(t, r, `{"alerts": "nope"}`))
+	})
+}
+
+func TestEnqueue(t *testing.T) {
+	r := newTestReceiver()
+
+	// Notifications received while the loop is busy are merged
+	r.Enqueue([]types.NamespacedName{{Namespace: "default", Name: "data"}})
+	r.Enqueue([]types.NamespacedName{{Namespace: "default", Name: "data"}, {Namespace: "default", Name: "logs"}})
+
+	<-r.Triggered()
+	select {
+	case <-r.Triggered():
+		t.Fatal("the receiver should be triggered once")
+	default:
+	}
+	assert.Equal(t, []types.NamespacedName{
+		{Namespace: "default", Name: "data"},
+		{Namespace: "default", Name: "logs"},
+	}, r.Take())
+}
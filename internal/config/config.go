@@ -0,0 +1,258 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/lorenzophys/pvc-autoscaler/internal/delegation"
+	"github.com/lorenzophys/pvc-autoscaler/internal/maintenance"
+	"github.com/lorenzophys/pvc-autoscaler/internal/notifier"
+	"github.com/lorenzophys/pvc-autoscaler/internal/policy"
+	"github.com/lorenzophys/pvc-autoscaler/internal/provider"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	notifierKinds       = []string{"webhook", "slack", "teams"}
+	metricsAggregations = []string{"", "max", "min", "avg"}
+)
+
+// Config holds the settings that can be changed without restarting the
+// autoscaler. Fields missing from the file keep the value of the command
+// line flags.
+type Config struct {
+	MetricsClient    MetricsClientConfig `json:"metricsClient"`
+	PollingInterval  metav1.Duration     `json:"pollingInterval"`
+	ReconcileTimeout metav1.Duration     `json:"reconcileTimeout"`
+	Defaults         DefaultsConfig      `json:"defaults"`
+	Selectors        SelectorsConfig     `json:"selectors"`
+	Notifications    NotificationsConfig `json:"notifications"`
+	RateLimits       RateLimitsConfig    `json:"rateLimits"`
+	// Delegations resize the PVCs owned by an operator through their owner
+	Delegations []delegation.Rule `json:"delegations,omitempty"`
+	// ProviderLimits replace the built-in limits of the listed provisioners
+	ProviderLimits provider.Table `json:"providerLimits,omitempty"`
+	// Freeze defers the resizes of all the PVCs below their
+	// emergency threshold, e.g. during a release freeze
+	Freeze bool `json:"freeze,omitempty"`
+}
+
+type MetricsClientConfig struct {
+	Name        string `json:"name"`
+	URL         string `json:"url"`
+	Aggregation string `json:"aggregation,omitempty"`
+}
+
+type DefaultsConfig struct {
+	Threshold string `json:"threshold"`
+	Increase  string `json:"increase"`
+	// Tiers is a list of threshold:increase rules replacing Threshold and
+	// Increase, e.g. "75%:10%,90%:30%"
+	Tiers string `json:"tiers,omitempty"`
+	// CeilingFactor bounds the PVCs without ceiling to this multiple of their
+	// original size, zero disables it
+	CeilingFactor float64 `json:"ceilingFactor,omitempty"`
+	// OfflineExpansionWindow restricts when the pods of the PVCs opted in to
+	// offline expansion are restarted, e.g. "0 2 * * sat,sun 4h"
+	OfflineExpansionWindow string `json:"offlineExpansionWindow,omitempty"`
+	// ResizeWindow restricts the resizes to maintenance windows,
+	// e.g. "CRON_TZ=Europe/Paris 0 20 * * mon-fri 10h"
+	ResizeWindow string `json:"resizeWindow,omitempty"`
+	// EmergencyThreshold is the usage above which the resize windows and the
+	// freeze are bypassed, e.g. "97%"
+	EmergencyThreshold string `json:"emergencyThreshold,omitempty"`
+}
+
+type SelectorsConfig struct {
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	PVCSelector       *metav1.LabelSelector `json:"pvcSelector,omitempty"`
+}
+
+type NotificationsConfig struct {
+	RepeatInterval     metav1.Duration  `json:"repeatInterval"`
+	ResizeStallTimeout metav1.Duration  `json:"resizeStallTimeout"`
+	Notifiers          []NotifierConfig `json:"notifiers,omitempty"`
+}
+
+type NotifierConfig struct {
+	Kind      string            `json:"kind"`
+	URL       string            `json:"url"`
+	Events    []string          `json:"events,omitempty"`
+	Templates map[string]string `json:"templates,omitempty"`
+}
+
+type RateLimitsConfig struct {
+	Workers      int     `json:"workers"`
+	KubeAPIQPS   float32 `json:"kubeAPIQPS"`
+	KubeAPIBurst int     `json:"kubeAPIBurst"`
+}
+
+func (n *NotifierConfig) TemplateOverrides() map[notifier.EventType]string {
+	overrides := make(map[notifier.EventType]string, len(n.Templates))
+	for event, text := range n.Templates {
+		overrides[notifier.EventType(event)] = text
+	}
+
+	return overrides
+}
+
+// Load reads the YAML file at path on top of base and validates the result.
+// It also returns a hash of the file content, even when it is invalid.
+func Load(path string, base Config) (*Config, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	sum := hash(data)
+
+	config := base.DeepCopy()
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, sum, fmt.Errorf("could not parse config file: %w", err)
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, sum, fmt.Errorf("invalid config file: %w", err)
+	}
+
+	return config, sum, nil
+}
+
+func hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+func (c *Config) DeepCopy() *Config {
+	out := *c
+
+	if c.Selectors.NamespaceSelector != nil {
+		out.Selectors.NamespaceSelector = c.Selectors.NamespaceSelector.DeepCopy()
+	}
+	if c.Selectors.PVCSelector != nil {
+		out.Selectors.PVCSelector = c.Selectors.PVCSelector.DeepCopy()
+	}
+
+	out.Notifications.Notifiers = make([]NotifierConfig, 0, len(c.Notifications.Notifiers))
+	for _, n := range c.Notifications.Notifiers {
+		n.Events = slices.Clone(n.Events)
+		if n.Templates != nil {
+			templates := make(map[string]string, len(n.Templates))
+			for k, v := range n.Templates {
+				templates[k] = v
+			}
+			n.Templates = templates
+		}
+		out.Notifications.Notifiers = append(out.Notifications.Notifiers, n)
+	}
+	out.Delegations = slices.Clone(c.Delegations)
+	out.ProviderLimits = c.ProviderLimits.DeepCopy()
+
+	return &out
+}
+
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.MetricsClient.Name == "" {
+		errs = append(errs, errors.New("metricsClient.name must be set"))
+	}
+	if !slices.Contains(metricsAggregations, c.MetricsClient.Aggregation) {
+		errs = append(errs, fmt.Errorf("metricsClient.aggregation: unknown aggregation %q", c.MetricsClient.Aggregation))
+	}
+	if c.PollingInterval.Duration <= 0 {
+		errs = append(errs, errors.New("pollingInterval must be positive"))
+	}
+	if c.ReconcileTimeout.Duration <= 0 {
+		errs = append(errs, errors.New("reconcileTimeout must be positive"))
+	}
+
+	if _, err := policy.ParsePercentage(c.Defaults.Threshold); err != nil {
+		errs = append(errs, fmt.Errorf("defaults.threshold: %w", err))
+	}
+	if _, err := policy.ParsePercentage(c.Defaults.Increase); err != nil {
+		errs = append(errs, fmt.Errorf("defaults.increase: %w", err))
+	}
+	if c.Defaults.Tiers != "" {
+		if _, err := policy.ParseTiers(c.Defaults.Tiers); err != nil {
+			errs = append(errs, fmt.Errorf("defaults.tiers: %w", err))
+		}
+	}
+	if c.Defaults.CeilingFactor != 0 && c.Defaults.CeilingFactor < 1 {
+		errs = append(errs, errors.New("defaults.ceilingFactor must be 0 or at least 1"))
+	}
+	if _, err := maintenance.ParseWindows(c.Defaults.OfflineExpansionWindow); err != nil {
+		errs = append(errs, fmt.Errorf("defaults.offlineExpansionWindow: %w", err))
+	}
+	if _, err := maintenance.ParseWindows(c.Defaults.ResizeWindow); err != nil {
+		errs = append(errs, fmt.Errorf("defaults.resizeWindow: %w", err))
+	}
+	if c.Defaults.EmergencyThreshold != "" {
+		if _, err := policy.ParsePercentage(c.Defaults.EmergencyThreshold); err != nil {
+			errs = append(errs, fmt.Errorf("defaults.emergencyThreshold: %w", err))
+		}
+	}
+
+	if c.Selectors.NamespaceSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(c.Selectors.NamespaceSelector); err != nil {
+			errs = append(errs, fmt.Errorf("selectors.namespaceSelector: %w", err))
+		}
+	}
+	if c.Selectors.PVCSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(c.Selectors.PVCSelector); err != nil {
+			errs = append(errs, fmt.Errorf("selectors.pvcSelector: %w", err))
+		}
+	}
+
+	if c.Notifications.RepeatInterval.Duration < 0 {
+		errs = append(errs, errors.New("notifications.repeatInterval must not be negative"))
+	}
+	if c.Notifications.ResizeStallTimeout.Duration <= 0 {
+		errs = append(errs, errors.New("notifications.resizeStallTimeout must be positive"))
+	}
+	for i, n := range c.Notifications.Notifiers {
+		if !slices.Contains(notifierKinds, n.Kind) {
+			errs = append(errs, fmt.Errorf("notifications.notifiers[%d]: unknown kind %q", i, n.Kind))
+		}
+		if n.URL == "" {
+			errs = append(errs, fmt.Errorf("notifications.notifiers[%d]: url must be set", i))
+		}
+		if _, err := notifier.ParseEventTypes(strings.Join(n.Events, ",")); err != nil {
+			errs = append(errs, fmt.Errorf("notifications.notifiers[%d]: %w", i, err))
+		}
+		if _, err := notifier.NewFormatter(n.TemplateOverrides()); err != nil {
+			errs = append(errs, fmt.Errorf("notifications.notifiers[%d]: %w", i, err))
+		}
+		for event := range n.Templates {
+			if !slices.Contains(notifier.AllEventTypes, notifier.EventType(event)) {
+				errs = append(errs, fmt.Errorf("notifications.notifiers[%d]: template for unknown event %q", i, event))
+			}
+		}
+	}
+
+	if c.RateLimits.Workers < 1 {
+		errs = append(errs, errors.New("rateLimits.workers must be at least 1"))
+	}
+	if c.RateLimits.KubeAPIQPS <= 0 {
+		errs = append(errs, errors.New("rateLimits.kubeAPIQPS must be positive"))
+	}
+	if c.RateLimits.KubeAPIBurst < 1 {
+		errs = append(errs, errors.New("rateLimits.kubeAPIBurst must be at least 1"))
+	}
+
+	for i, r := range c.Delegations {
+		if err := r.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("delegations[%d]: %w", i, err))
+		}
+	}
+	if err := c.ProviderLimits.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("providerLimits: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
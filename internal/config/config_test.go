@@ -0,0 +1,189 @@
+package config
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newBaseConfig() Config {
+	return Config{
+		MetricsClient:    MetricsClientConfig{Name: "prometheus", URL: "http://prometheus"},
+		PollingInterval:  metav1.Duration{Duration: 30 * time.Second},
+		ReconcileTimeout: metav1.Duration{Duration: time.Minute},
+		Defaults:         DefaultsConfig{Threshold: "80%", Increase: "20%"},
+		Notifications: NotificationsConfig{
+			RepeatInterval:     metav1.Duration{Duration: time.Hour},
+			ResizeStallTimeout: metav1.Duration{Duration: 30 * time.Minute},
+		},
+		RateLimits: RateLimitsConfig{Workers: 1, KubeAPIQPS: 5, KubeAPIBurst: 10},
+	}
+}
+
+func writeConfig(t *testing.T, path, content string) {
+	t.Helper()
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("file overrides base", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		writeConfig(t, path, `
+pollingInterval: 1m
+defaults:
+  threshold: 90%
+selectors:
+  pvcSelector:
+    matchLabels:
+      team: storage
+notifications:
+  notifiers:
+    - kind: slack
+      url: https://hooks.slack.com/services/XXX
+      events: [ceiling_reached]
+rateLimits:
+  workers: 8
+`)
+
+		config, hash, err := Load(path, newBaseConfig())
+
+		assert.NoError(t, err)
+		assert.Len(t, hash, 16)
+		assert.Equal(t, time.Minute, config.PollingInterval.Duration)
+		assert.Equal(t, "90%", config.Defaults.Threshold)
+		assert.Equal(t, "20%", config.Defaults.Increase)
+		assert.Equal(t, "storage", config.Selectors.PVCSelector.MatchLabels["team"])
+		assert.Equal(t, []string{"ceiling_reached"}, config.Notifications.Notifiers[0].Events)
+		assert.Equal(t, 8, config.RateLimits.Workers)
+		assert.Equal(t, 10, config.RateLimits.KubeAPIBurst)
+		assert.Equal(t, "prometheus", config.MetricsClient.Name)
+	})
+
+	t.Run("invalid values", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		writeConfig(t, path, `
+defaults:
+  threshold: 80
+  increase: NaN%
+  tiers: 90%:30%,75%:10%
+  ceilingFactor: 0.5
+  offlineExpansionWindow: "0 2 * * sat,sun"
+  resizeWindow: "CRON_TZ=Mars/Olympus 0 2 * * * 1h"
+  emergencyThreshold: 120%
+notifications:
+  notifiers:
+    - kind: pager
+      url: ""
+      events: [exploded]
+rateLimits:
+  workers: 0
+delegations:
+  - apiVersion: postgresql.cnpg.io/v1
+    path: spec.instances[0].size
+providerLimits:
+  csi.example.com:
+    cooldown: -1h
+`)
+
+		_, _, err := Load(path, newBaseConfig())
+
+		assert.ErrorContains(t, err, "defaults.threshold")
+		assert.ErrorContains(t, err, "defaults.increase")
+		assert.ErrorContains(t, err, "defaults.tiers")
+		assert.ErrorContains(t, err, "defaults.ceilingFactor")
+		assert.ErrorContains(t, err, "defaults.offlineExpansionWindow")
+		assert.ErrorContains(t, err, "defaults.resizeWindow")
+		assert.ErrorContains(t, err, "defaults.emergencyThreshold")
+		assert.ErrorContains(t, err, `unknown kind "pager"`)
+		assert.ErrorContains(t, err, "url must be set")
+		assert.ErrorContains(t, err, "unknown event type: exploded")
+		assert.ErrorContains(t, err, "rateLimits.workers")
+		assert.ErrorContains(t, err, "delegations[0]: kind must be set")
+		assert.ErrorContains(t, err, `path "spec.instances[0].size"`)
+		assert.ErrorContains(t, err, "providerLimits: csi.example.com: cooldown must not be negative")
+	})
+
+	t.Run("unknown field", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		writeConfig(t, path, "pollingIntervall: 1m\n")
+
+		_, _, err := Load(path, newBaseConfig())
+
+		assert.Error(t, err)
+	})
+
+	t.Run("base is not modified", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		writeConfig(t, path, `
+selectors:
+  pvcSelector:
+    matchLabels:
+      team: storage
+`)
+		base := newBaseConfig()
+		base.Selectors.PVCSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}}
+
+		config, _, err := Load(path, base)
+
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{"app": "db"}, base.Selectors.PVCSelector.MatchLabels)
+		assert.Equal(t, "storage", config.Selectors.PVCSelector.MatchLabels["team"])
+	})
+}
+
+func TestWatcher(t *testing.T) {
+	logger := log.New()
+	logger.Out = io.Discard
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, "pollingInterval: 1m\n")
+
+	var reloads []string
+	var errs []error
+	onReload := func(hash string, err error) {
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		reloads = append(reloads, hash)
+	}
+
+	w, err := NewWatcher(path, newBaseConfig(), logger, onReload)
+	assert.NoError(t, err)
+
+	config, firstHash := w.Current()
+	assert.Equal(t, time.Minute, config.PollingInterval.Duration)
+	assert.Equal(t, []string{firstHash}, reloads)
+
+	// Unchanged file
+	w.reload()
+	assert.Len(t, reloads, 1)
+
+	// Invalid file keeps the previous config, it is reported once
+	writeConfig(t, path, "pollingInterval: -1m\n")
+	w.reload()
+	w.reload()
+	assert.Len(t, errs, 1)
+	_, hash := w.Current()
+	assert.Equal(t, firstHash, hash)
+
+	// Another invalid version is reported again
+	writeConfig(t, path, "pollingInterval: -2m\n")
+	w.reload()
+	w.reload()
+	assert.Len(t, errs, 2)
+
+	// Valid change
+	writeConfig(t, path, "pollingInterval: 2m\n")
+	w.reload()
+	config, hash = w.Current()
+	assert.NotEqual(t, firstHash, hash)
+	assert.Equal(t, 2*time.Minute, config.PollingInterval.Duration)
+	assert.Equal(t, []string{firstHash, hash}, reloads)
+}
@@ -0,0 +1,102 @@
+package config
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Watcher polls the config file and keeps the last valid version of it.
+// Polling the content instead of relying on filesystem events also works
+// with the symlink swaps of mounted ConfigMaps.
+type Watcher struct {
+	path     string
+	base     Config
+	logger   *log.Logger
+	onReload func(hash string, err error)
+
+	mu      sync.RWMutex
+	current *Config
+	hash    string
+
+	// failure identifies the last invalid file, reported only once
+	failure string
+}
+
+// NewWatcher loads the config file for the first time, onReload is called
+// after every load attempt with the hash of the loaded file or the error
+func NewWatcher(path string, base Config, logger *log.Logger, onReload func(hash string, err error)) (*Watcher, error) {
+	w := &Watcher{
+		path:     path,
+		base:     base,
+		logger:   logger,
+		onReload: onReload,
+	}
+
+	config, hash, err := Load(path, base)
+	w.onReload(hash, err)
+	if err != nil {
+		return nil, err
+	}
+	w.current = config
+	w.hash = hash
+
+	return w, nil
+}
+
+// Current returns the last valid config and the hash of its file
+func (w *Watcher) Current() (*Config, string) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return w.current, w.hash
+}
+
+func (w *Watcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.reload()
+		}
+	}
+}
+
+func (w *Watcher) reload() {
+	config, hash, err := Load(w.path, w.base)
+	if err != nil {
+		// The hash is empty when the file could not be read
+		failure := hash
+		if failure == "" {
+			failure = err.Error()
+		}
+		if failure == w.failure {
+			return
+		}
+		w.failure = failure
+
+		w.logger.Errorf("failed to reload config file %s, keeping the previous config: %v", w.path, err)
+		w.onReload(hash, err)
+		return
+	}
+	w.failure = ""
+
+	w.mu.Lock()
+	changed := hash != w.hash
+	if changed {
+		w.current = config
+		w.hash = hash
+	}
+	w.mu.Unlock()
+
+	if changed {
+		w.logger.Infof("config file %s changed, new hash %s", w.path, hash)
+		w.onReload(hash, nil)
+	}
+}
@@ -0,0 +1,195 @@
+// Package delegation resizes the PVCs managed by an operator through their
+// owning custom resource, the operator then propagates the new size to the
+// PVC. Patching such a PVC directly is reverted or leaves the custom resource
+// out of sync.
+package delegation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Rule delegates the resize of the PVCs owned by a kind of custom resource
+type Rule struct {
+	// APIVersion and Kind of the owner, e.g. postgresql.cnpg.io/v1 Cluster
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	// Resource is the plural name of the kind, guessed from the kind if empty
+	Resource string `json:"resource,omitempty"`
+	// Path is the field holding the size in the owner, e.g. spec.storage.size.
+	// Only maps are supported, not lists.
+	Path string `json:"path"`
+}
+
+func (r *Rule) Validate() error {
+	var errs []error
+
+	gv, err := schema.ParseGroupVersion(r.APIVersion)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("apiVersion: %w", err))
+	} else if gv.Version == "" {
+		errs = append(errs, fmt.Errorf("apiVersion %q has no version", r.APIVersion))
+	}
+	if r.Kind == "" {
+		errs = append(errs, errors.New("kind must be set"))
+	}
+	for _, field := range r.fields() {
+		if field == "" || strings.ContainsAny(field, "[]{}") {
+			errs = append(errs, fmt.Errorf("path %q should be a dot separated list of fields", r.Path))
+			break
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// fields splits the path, the JSONPath forms .spec.size and {.spec.size} are
+// accepted too
+func (r *Rule) fields() []string {
+	path := strings.TrimSuffix(strings.TrimPrefix(r.Path, "{"), "}")
+	return strings.Split(strings.TrimPrefix(path, "."), ".")
+}
+
+func (r *Rule) groupVersionResource() schema.GroupVersionResource {
+	gv, _ := schema.ParseGroupVersion(r.APIVersion)
+	if r.Resource != "" {
+		return gv.WithResource(r.Resource)
+	}
+
+	gvr, _ := meta.UnsafeGuessKindToResource(gv.WithKind(r.Kind))
+	return gvr
+}
+
+// Target is the owner of a PVC to patch instead of the PVC
+type Target struct {
+	Rule      *Rule
+	Namespace string
+	Name      string
+}
+
+func (t Target) String() string {
+	return fmt.Sprintf("%s %s/%s", t.Rule.Kind, t.Namespace, t.Name)
+}
+
+// Match returns the owner of the PVC matching the first rule, the controller
+// owner is tried first
+func Match(pvc *corev1.PersistentVolumeClaim, rules []Rule) (Target, bool) {
+	return matchOwners(pvc.Namespace, pvc.OwnerReferences, rules)
+}
+
+// Resolve returns the owner of the PVC like Match. The PVCs created from the
+// volumeClaimTemplates of a StatefulSet, e.g. by the Prometheus Operator or
+// ECK, have no owner reference to the custom resource: the owners of their
+// StatefulSet are matched instead.
+func Resolve(ctx context.Context, kubeClient kubernetes.Interface, pvc *corev1.PersistentVolumeClaim, rules []Rule) (Target, bool, error) {
+	if target, ok := Match(pvc, rules); ok || len(rules) == 0 {
+		return target, ok, nil
+	}
+
+	statefulSets, err := kubeClient.AppsV1().StatefulSets(pvc.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return Target{}, false, fmt.Errorf("could not list the statefulsets in %s: %w", pvc.Namespace, err)
+	}
+	for i := range statefulSets.Items {
+		if createdBy(pvc, &statefulSets.Items[i]) {
+			target, ok := matchOwners(pvc.Namespace, statefulSets.Items[i].OwnerReferences, rules)
+			return target, ok, nil
+		}
+	}
+
+	return Target{}, false, nil
+}
+
+// createdBy returns whether the PVC comes from a volumeClaimTemplate of the
+// StatefulSet, such PVCs are named <template>-<statefulset>-<ordinal>
+func createdBy(pvc *corev1.PersistentVolumeClaim, statefulSet *appsv1.StatefulSet) bool {
+	for _, template := range statefulSet.Spec.VolumeClaimTemplates {
+		ordinal, found := strings.CutPrefix(pvc.Name, template.Name+"-"+statefulSet.Name+"-")
+		if !found {
+			continue
+		}
+		if _, err := strconv.ParseUint(ordinal, 10, 32); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchOwners(namespace string, ownerReferences []metav1.OwnerReference, rules []Rule) (Target, bool) {
+	owners := make([]metav1.OwnerReference, 0, len(ownerReferences))
+	for _, owner := range ownerReferences {
+		if owner.Controller != nil && *owner.Controller {
+			owners = append([]metav1.OwnerReference{owner}, owners...)
+			continue
+		}
+		owners = append(owners, owner)
+	}
+
+	for _, owner := range owners {
+		for i := range rules {
+			if rules[i].APIVersion == owner.APIVersion && rules[i].Kind == owner.Kind {
+				return Target{Rule: &rules[i], Namespace: namespace, Name: owner.Name}, true
+			}
+		}
+	}
+
+	return Target{}, false
+}
+
+// Resize sets the size in the owner to newSize. The owner is left untouched
+// when it already asks for as much, e.g. when another PVC it owns was resized
+// first. It returns whether the owner was patched.
+func Resize(ctx context.Context, dynamicClient dynamic.Interface, target Target, newSize resource.Quantity) (bool, error) {
+	client := dynamicClient.Resource(target.Rule.groupVersionResource()).Namespace(target.Namespace)
+	fields := target.Rule.fields()
+
+	owner, err := client.Get(ctx, target.Name, metav1.GetOptions{})
+	if err != nil {
+		return false, fmt.Errorf("could not get %s: %w", target, err)
+	}
+
+	current, found, err := unstructured.NestedString(owner.Object, fields...)
+	if err != nil {
+		return false, fmt.Errorf("could not read %s in %s: %w", target.Rule.Path, target, err)
+	}
+	if found {
+		currentSize, err := resource.ParseQuantity(current)
+		if err != nil {
+			return false, fmt.Errorf("could not parse %s in %s: %w", target.Rule.Path, target, err)
+		}
+		if currentSize.Cmp(newSize) >= 0 {
+			return false, nil
+		}
+	}
+
+	patch := map[string]any{}
+	if err := unstructured.SetNestedField(patch, newSize.String(), fields...); err != nil {
+		return false, err
+	}
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := client.Patch(ctx, target.Name, types.MergePatchType, data, metav1.PatchOptions{}); err != nil {
+		return false, fmt.Errorf("could not patch %s: %w", target, err)
+	}
+
+	return true, nil
+}
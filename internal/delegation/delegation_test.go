@@ -0,0 +1,244 @@
+package delegation
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var cnpgRule = Rule{APIVersion: "postgresql.cnpg.io/v1", Kind: "Cluster", Path: "spec.storage.size"}
+
+func newCluster(size string) *unstructured.Unstructured {
+	cluster := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "postgresql.cnpg.io/v1",
+		"kind":       "Cluster",
+		"metadata":   map[string]any{"namespace": "default", "name": "pg"},
+		"spec":       map[string]any{"instances": int64(3)},
+	}}
+	if size != "" {
+		_ = unstructured.SetNestedField(cluster.Object, size, "spec", "storage", "size")
+	}
+	return cluster
+}
+
+func newFakeDynamicClient(objects ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		{Group: "postgresql.cnpg.io", Version: "v1", Resource: "clusters"}: "ClusterList",
+	}, objects...)
+}
+
+func getSize(t *testing.T, client *dynamicfake.FakeDynamicClient) string {
+	t.Helper()
+
+	cluster, err := client.Resource(schema.GroupVersionResource{Group: "postgresql.cnpg.io", Version: "v1", Resource: "clusters"}).
+		Namespace("default").
+		Get(context.TODO(), "pg", metav1.GetOptions{})
+	assert.NoError(t, err)
+
+	size, _, _ := unstructured.NestedString(cluster.Object, "spec", "storage", "size")
+	return size
+}
+
+func TestMatch(t *testing.T) {
+	isController := true
+	rules := []Rule{
+		{APIVersion: "apps/v1", Kind: "StatefulSet", Path: "spec.size"},
+		cnpgRule,
+	}
+
+	t.Run("controller owner first", func(t *testing.T) {
+		pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "pg-1",
+			OwnerReferences: []metav1.OwnerReference{
+				{APIVersion: "apps/v1", Kind: "StatefulSet", Name: "other"},
+				{APIVersion: "postgresql.cnpg.io/v1", Kind: "Cluster", Name: "pg", Controller: &isController},
+			},
+		}}
+
+		target, ok := Match(pvc, rules)
+
+		assert.True(t, ok)
+		assert.Equal(t, "Cluster", target.Rule.Kind)
+		assert.Equal(t, "Cluster default/pg", target.String())
+	})
+
+	t.Run("no matching owner", func(t *testing.T) {
+		pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+			OwnerReferences: []metav1.OwnerReference{
+				{APIVersion: "postgresql.cnpg.io/v2", Kind: "Cluster", Name: "pg"},
+			},
+		}}
+
+		_, ok := Match(pvc, rules)
+
+		assert.False(t, ok)
+	})
+}
+
+func TestResolve(t *testing.T) {
+	isController := true
+	prometheusRule := Rule{APIVersion: "monitoring.coreos.com/v1", Kind: "Prometheus", Path: "spec.storage.volumeClaimTemplate.spec.resources.requests.storage"}
+	statefulSet := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "monitoring",
+			Name:      "prometheus-k8s",
+			OwnerReferences: []metav1.OwnerReference{
+				{APIVersion: "monitoring.coreos.com/v1", Kind: "Prometheus", Name: "k8s", Controller: &isController},
+			},
+		},
+		Spec: appsv1.StatefulSetSpec{
+			VolumeClaimTemplates: []corev1.PersistentVolumeClaim{
+				{ObjectMeta: metav1.ObjectMeta{Name: "prometheus-k8s-db"}},
+			},
+		},
+	}
+
+	testCases := []struct {
+		name           string
+		pvc            *corev1.PersistentVolumeClaim
+		rules          []Rule
+		expectedOk     bool
+		expectedTarget string
+	}{
+		{
+			name: "direct owner",
+			pvc: &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+				Namespace: "default",
+				Name:      "pg-1",
+				OwnerReferences: []metav1.OwnerReference{
+					{APIVersion: "postgresql.cnpg.io/v1", Kind: "Cluster", Name: "pg", Controller: &isController},
+				},
+			}},
+			rules:          []Rule{cnpgRule},
+			expectedOk:     true,
+			expectedTarget: "Cluster default/pg",
+		},
+		{
+			name:           "owner of the statefulset",
+			pvc:            &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Namespace: "monitoring", Name: "prometheus-k8s-db-prometheus-k8s-1"}},
+			rules:          []Rule{cnpgRule, prometheusRule},
+			expectedOk:     true,
+			expectedTarget: "Prometheus monitoring/k8s",
+		},
+		{
+			name:  "owner of the statefulset without rule",
+			pvc:   &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Namespace: "monitoring", Name: "prometheus-k8s-db-prometheus-k8s-1"}},
+			rules: []Rule{cnpgRule},
+		},
+		{
+			name:  "not created from a volume claim template",
+			pvc:   &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Namespace: "monitoring", Name: "prometheus-k8s-db-prometheus-k8s-backup"}},
+			rules: []Rule{prometheusRule},
+		},
+		{
+			name:  "other namespace",
+			pvc:   &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "prometheus-k8s-db-prometheus-k8s-1"}},
+			rules: []Rule{prometheusRule},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			kubeClient := fake.NewSimpleClientset(statefulSet)
+
+			target, ok, err := Resolve(context.TODO(), kubeClient, tc.pvc, tc.rules)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedOk, ok)
+			if tc.expectedOk {
+				assert.Equal(t, tc.expectedTarget, target.String())
+			}
+		})
+	}
+}
+
+func TestResize(t *testing.T) {
+	testCases := []struct {
+		name            string
+		rule            Rule
+		currentSize     string
+		newSize         string
+		expectedPatched bool
+		expectedSize    string
+	}{
+		{
+			name:            "grow the owner",
+			rule:            cnpgRule,
+			currentSize:     "10Gi",
+			newSize:         "12Gi",
+			expectedPatched: true,
+			expectedSize:    "12Gi",
+		},
+		{
+			name:            "jsonpath form",
+			rule:            Rule{APIVersion: "postgresql.cnpg.io/v1", Kind: "Cluster", Resource: "clusters", Path: "{.spec.storage.size}"},
+			currentSize:     "10Gi",
+			newSize:         "12Gi",
+			expectedPatched: true,
+			expectedSize:    "12Gi",
+		},
+		{
+			name:            "size not set yet",
+			rule:            cnpgRule,
+			newSize:         "12Gi",
+			expectedPatched: true,
+			expectedSize:    "12Gi",
+		},
+		{
+			name:            "owner already grown by another pvc",
+			rule:            cnpgRule,
+			currentSize:     "12288Mi",
+			newSize:         "12Gi",
+			expectedPatched: false,
+			expectedSize:    "12288Mi",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := newFakeDynamicClient(newCluster(tc.currentSize))
+			target := Target{Rule: &tc.rule, Namespace: "default", Name: "pg"}
+
+			patched, err := Resize(context.TODO(), client, target, resource.MustParse(tc.newSize))
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedPatched, patched)
+			assert.Equal(t, tc.expectedSize, getSize(t, client))
+		})
+	}
+
+	t.Run("owner not found", func(t *testing.T) {
+		client := newFakeDynamicClient()
+		target := Target{Rule: &cnpgRule, Namespace: "default", Name: "pg"}
+
+		_, err := Resize(context.TODO(), client, target, resource.MustParse("12Gi"))
+
+		assert.ErrorContains(t, err, "could not get Cluster default/pg")
+	})
+
+	t.Run("invalid current size", func(t *testing.T) {
+		client := newFakeDynamicClient(newCluster("large"))
+		target := Target{Rule: &cnpgRule, Namespace: "default", Name: "pg"}
+
+		_, err := Resize(context.TODO(), client, target, resource.MustParse("12Gi"))
+
+		assert.ErrorContains(t, err, "could not parse spec.storage.size")
+	})
+}
+
+func TestRuleValidate(t *testing.T) {
+	assert.NoError(t, cnpgRule.Validate())
+	assert.Error(t, (&Rule{APIVersion: "postgresql.cnpg.io/v1/clusters", Kind: "Cluster", Path: "spec.storage.size"}).Validate())
+	assert.Error(t, (&Rule{APIVersion: "kafka.strimzi.io/v1beta2", Kind: "Kafka", Path: "spec.kafka.storage.volumes[0].size"}).Validate())
+}
@@ -0,0 +1,202 @@
+package history
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Policy is the effective autoscaling policy of a PVC, after merging the
+// annotations of the PVC, of its StorageClass and the global defaults
+type Policy struct {
+	Threshold          string `json:"threshold,omitempty"`
+	Increase           string `json:"increase,omitempty"`
+	Ceiling            string `json:"ceiling,omitempty"`
+	Tiers              string `json:"tiers,omitempty"`
+	AttributesClasses  string `json:"attributesClasses,omitempty"`
+	ResizeWindow       string `json:"resizeWindow,omitempty"`
+	EmergencyThreshold string `json:"emergencyThreshold,omitempty"`
+}
+
+type Metrics struct {
+	UsedBytes     int64     `json:"usedBytes"`
+	CapacityBytes int64     `json:"capacityBytes"`
+	ObservedAt    time.Time `json:"observedAt"`
+}
+
+type Decision struct {
+	Time    time.Time `json:"time"`
+	Reason  string    `json:"reason"`
+	Message string    `json:"message,omitempty"`
+}
+
+type PVCState struct {
+	Namespace      string     `json:"namespace"`
+	Name           string     `json:"name"`
+	Policy         Policy     `json:"policy"`
+	LastMetrics    *Metrics   `json:"lastMetrics,omitempty"`
+	ThresholdBytes int64      `json:"thresholdBytes,omitempty"`
+	CeilingBytes   int64      `json:"ceilingBytes,omitempty"`
+	Decisions      []Decision `json:"decisions"`
+}
+
+// Observation collects what is learned about a PVC during a reconciliation.
+// It is recorded by Commit.
+type Observation struct {
+	recorder *Recorder
+	key      types.NamespacedName
+
+	Policy         Policy
+	Metrics        *Metrics
+	ThresholdBytes int64
+	CeilingBytes   int64
+	decision       *Decision
+}
+
+// Recorder keeps the last decisions for each PVC in a ring buffer
+type Recorder struct {
+	size int
+
+	mu   sync.RWMutex
+	pvcs map[types.NamespacedName]*record
+}
+
+type record struct {
+	state PVCState
+	// next is the position of the next decision in state.Decisions once
+	// the buffer is full
+	next int
+}
+
+func NewRecorder(size int) *Recorder {
+	return &Recorder{
+		size: size,
+		pvcs: make(map[types.NamespacedName]*record),
+	}
+}
+
+func (r *Recorder) Begin(key types.NamespacedName) *Observation {
+	return &Observation{recorder: r, key: key}
+}
+
+func (o *Observation) Decide(reason, message string) {
+	o.decision = &Decision{Time: time.Now(), Reason: reason, Message: message}
+}
+
+func (o *Observation) Commit() {
+	if o.decision == nil {
+		return
+	}
+	o.recorder.record(o)
+}
+
+func (r *Recorder) record(o *Observation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rec, ok := r.pvcs[o.key]
+	if !ok {
+		rec = &record{state: PVCState{Namespace: o.key.Namespace, Name: o.key.Name}}
+		r.pvcs[o.key] = rec
+	}
+
+	rec.state.Policy = o.Policy
+	if o.Metrics != nil {
+		rec.state.LastMetrics = o.Metrics
+	}
+	// Zero when the last evaluation stopped before computing them, the
+	// values of a previous evaluation would be served as current
+	rec.state.ThresholdBytes = o.ThresholdBytes
+	rec.state.CeilingBytes = o.CeilingBytes
+
+	if len(rec.state.Decisions) < r.size {
+		rec.state.Decisions = append(rec.state.Decisions, *o.decision)
+		return
+	}
+	rec.state.Decisions[rec.next] = *o.decision
+	rec.next = (rec.next + 1) % r.size
+}
+
+// Retain forgets the PVCs not in keys, e.g. the ones no longer managed
+func (r *Recorder) Retain(keys []types.NamespacedName) {
+	keep := make(map[types.NamespacedName]bool, len(keys))
+	for _, key := range keys {
+		keep[key] = true
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key := range r.pvcs {
+		if !keep[key] {
+			delete(r.pvcs, key)
+		}
+	}
+}
+
+// Get returns the state of the PVC with its decisions, oldest first
+func (r *Recorder) Get(key types.NamespacedName) (PVCState, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rec, ok := r.pvcs[key]
+	if !ok {
+		return PVCState{}, false
+	}
+
+	return rec.snapshot(), true
+}
+
+func (r *Recorder) List() []PVCState {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	states := make([]PVCState, 0, len(r.pvcs))
+	for _, rec := range r.pvcs {
+		states = append(states, rec.snapshot())
+	}
+	slices.SortFunc(states, func(x, y PVCState) int {
+		if c := strings.Compare(x.Namespace, y.Namespace); c != 0 {
+			return c
+		}
+		return strings.Compare(x.Name, y.Name)
+	})
+
+	return states
+}
+
+func (rec *record) snapshot() PVCState {
+	state := rec.state
+	state.Decisions = append(slices.Clone(rec.state.Decisions[rec.next:]), rec.state.Decisions[:rec.next]...)
+
+	return state
+}
+
+// RegisterHandlers serves the PVC states on /debug/pvcs and
+// /debug/pvcs/{namespace}/{name}
+func (r *Recorder) RegisterHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("GET /debug/pvcs", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, r.List())
+	})
+	mux.HandleFunc("GET /debug/pvcs/{namespace}/{name}", func(w http.ResponseWriter, req *http.Request) {
+		key := types.NamespacedName{Namespace: req.PathValue("namespace"), Name: req.PathValue("name")}
+
+		state, ok := r.Get(key)
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "pvc " + key.String() + " not managed or not evaluated yet"})
+			return
+		}
+		writeJSON(w, http.StatusOK, state)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
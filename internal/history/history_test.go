@@ -0,0 +1,127 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func decide(r *Recorder, key types.NamespacedName, reason string) {
+	obs := r.Begin(key)
+	obs.Policy = Policy{Threshold: "80%", Increase: "20%", Ceiling: "20Gi"}
+	obs.Decide(reason, "")
+	obs.Commit()
+}
+
+func reasons(state PVCState) []string {
+	var res []string
+	for _, d := range state.Decisions {
+		res = append(res, d.Reason)
+	}
+	return res
+}
+
+func TestRecorder(t *testing.T) {
+	key := types.NamespacedName{Namespace: "default", Name: "mypvc"}
+
+	t.Run("ring buffer keeps the last decisions in order", func(t *testing.T) {
+		r := NewRecorder(3)
+		for i := 0; i < 5; i++ {
+			decide(r, key, fmt.Sprintf("Reason%d", i))
+		}
+
+		state, ok := r.Get(key)
+
+		assert.True(t, ok)
+		assert.Equal(t, []string{"Reason2", "Reason3", "Reason4"}, reasons(state))
+		assert.Equal(t, "80%", state.Policy.Threshold)
+	})
+
+	t.Run("observation without decision is not recorded", func(t *testing.T) {
+		r := NewRecorder(3)
+		r.Begin(key).Commit()
+
+		_, ok := r.Get(key)
+
+		assert.False(t, ok)
+	})
+
+	t.Run("last metrics are kept, the computed values are not", func(t *testing.T) {
+		r := NewRecorder(3)
+
+		obs := r.Begin(key)
+		obs.Metrics = &Metrics{UsedBytes: 80, CapacityBytes: 100}
+		obs.ThresholdBytes = 80
+		obs.CeilingBytes = 200
+		obs.Decide("Resized", "")
+		obs.Commit()
+
+		obs = r.Begin(key)
+		obs.Decide("MetricsMissing", "")
+		obs.Commit()
+
+		state, _ := r.Get(key)
+		assert.Equal(t, int64(80), state.LastMetrics.UsedBytes)
+		assert.Zero(t, state.ThresholdBytes)
+		assert.Zero(t, state.CeilingBytes)
+	})
+
+	t.Run("retain forgets unmanaged pvcs", func(t *testing.T) {
+		r := NewRecorder(3)
+		other := types.NamespacedName{Namespace: "default", Name: "other"}
+		decide(r, key, "Resized")
+		decide(r, other, "Resized")
+
+		r.Retain([]types.NamespacedName{other})
+
+		assert.Len(t, r.List(), 1)
+		assert.Equal(t, "other", r.List()[0].Name)
+	})
+}
+
+func TestHandlers(t *testing.T) {
+	r := NewRecorder(5)
+	decide(r, types.NamespacedName{Namespace: "ns-b", Name: "data"}, "BelowThreshold")
+	decide(r, types.NamespacedName{Namespace: "ns-a", Name: "data"}, "CeilingReached")
+
+	mux := http.NewServeMux()
+	r.RegisterHandlers(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	t.Run("list", func(t *testing.T) {
+		resp, err := http.Get(ts.URL + "/debug/pvcs")
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		var states []PVCState
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&states))
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Len(t, states, 2)
+		assert.Equal(t, "ns-a", states[0].Namespace)
+	})
+
+	t.Run("single pvc", func(t *testing.T) {
+		resp, err := http.Get(ts.URL + "/debug/pvcs/ns-b/data")
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		var state PVCState
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&state))
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, []string{"BelowThreshold"}, reasons(state))
+	})
+
+	t.Run("unknown pvc", func(t *testing.T) {
+		resp, err := http.Get(ts.URL + "/debug/pvcs/ns-b/missing")
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
@@ -0,0 +1,291 @@
+// Package maintenance parses the maintenance windows restricting when the
+// autoscaler may disrupt a workload. A window is a cron schedule of its start
+// followed by its duration, e.g. "0 2 * * sat,sun 4h" for 02:00 to 06:00 on
+// weekends, optionally prefixed by a time zone like "CRON_TZ=Europe/Paris".
+package maintenance
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxDuration bounds the duration of a window
+const MaxDuration = 7 * 24 * time.Hour
+
+// Window is a recurring period starting at each match of its schedule
+type Window struct {
+	spec     string
+	schedule schedule
+	duration time.Duration
+	location *time.Location
+}
+
+func (w Window) String() string {
+	return w.spec
+}
+
+// Windows is a set of windows, a time is in the set if it is in any of them
+type Windows []Window
+
+func (ws Windows) String() string {
+	specs := make([]string, len(ws))
+	for i, w := range ws {
+		specs[i] = w.spec
+	}
+
+	return strings.Join(specs, "; ")
+}
+
+// Parse parses a window like "CRON_TZ=Europe/Paris 0 2 * * sat,sun 4h", the
+// time zone defaults to UTC
+func Parse(spec string) (Window, error) {
+	fields := strings.Fields(spec)
+	window := Window{spec: strings.Join(fields, " "), location: time.UTC}
+
+	if len(fields) > 0 {
+		if tz, ok := strings.CutPrefix(fields[0], "CRON_TZ="); ok {
+			location, err := time.LoadLocation(tz)
+			if err != nil {
+				return Window{}, fmt.Errorf("invalid time zone %q: %w", tz, err)
+			}
+			window.location = location
+			fields = fields[1:]
+		}
+	}
+	if len(fields) != 6 {
+		return Window{}, fmt.Errorf("window %q should be a cron schedule followed by a duration, e.g. \"0 2 * * sat,sun 4h\"", spec)
+	}
+
+	duration, err := time.ParseDuration(fields[5])
+	if err != nil {
+		return Window{}, fmt.Errorf("invalid duration in window %q: %w", spec, err)
+	}
+	if duration <= 0 || duration > MaxDuration {
+		return Window{}, fmt.Errorf("the duration of window %q should be positive and at most %s", spec, MaxDuration)
+	}
+	window.duration = duration
+
+	window.schedule, err = parseSchedule(fields[:5])
+	if err != nil {
+		return Window{}, fmt.Errorf("invalid schedule in window %q: %w", spec, err)
+	}
+
+	return window, nil
+}
+
+// ParseWindows parses a list of windows separated by semicolons, an empty
+// value is an empty list
+func ParseWindows(value string) (Windows, error) {
+	var windows Windows
+	for _, spec := range strings.Split(value, ";") {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+		window, err := Parse(spec)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, window)
+	}
+
+	return windows, nil
+}
+
+// Contains reports whether t is in the window
+func (w Window) Contains(t time.Time) bool {
+	t = t.In(w.location).Truncate(time.Minute)
+	for start := t; t.Sub(start) < w.duration; start = start.Add(-time.Minute) {
+		if w.schedule.matches(start) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Next returns the next start of the window after t, or the zero time if
+// there is none within a year
+func (w Window) Next(t time.Time) time.Time {
+	t = t.In(w.location).Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(1, 0, 0)
+
+	for t.Before(limit) {
+		switch {
+		case !w.schedule.month.has(int(t.Month())):
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, w.location)
+		case !w.schedule.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, w.location)
+		case !w.schedule.hour.has(t.Hour()):
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, w.location)
+		case !w.schedule.minute.has(t.Minute()):
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+// Contains reports whether t is in any of the windows
+func (ws Windows) Contains(t time.Time) bool {
+	for _, w := range ws {
+		if w.Contains(t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Next returns the earliest next start of the windows after t
+func (ws Windows) Next(t time.Time) time.Time {
+	var next time.Time
+	for _, w := range ws {
+		if start := w.Next(t); !start.IsZero() && (next.IsZero() || start.Before(next)) {
+			next = start
+		}
+	}
+
+	return next
+}
+
+// schedule holds the allowed values of the five cron fields
+type schedule struct {
+	minute, hour, dom, month, dow field
+	// domAny and dowAny are set when the field is "*", cron matches either
+	// the day of month or the day of week when both are restricted
+	domAny, dowAny bool
+}
+
+func (s schedule) matchesDay(t time.Time) bool {
+	dom := s.dom.has(t.Day())
+	dow := s.dow.has(int(t.Weekday()))
+
+	switch {
+	case s.domAny && s.dowAny:
+		return true
+	case s.domAny:
+		return dow
+	case s.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+func (s schedule) matches(t time.Time) bool {
+	return s.minute.has(t.Minute()) && s.hour.has(t.Hour()) && s.month.has(int(t.Month())) && s.matchesDay(t)
+}
+
+// field is a bit set of the allowed values
+type field uint64
+
+func (f field) has(value int) bool {
+	return f&(1<<uint(value)) != 0
+}
+
+var (
+	monthNames = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	dayNames   = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+func parseSchedule(fields []string) (schedule, error) {
+	var (
+		s    schedule
+		errs []error
+		err  error
+	)
+
+	if s.minute, err = parseField(fields[0], 0, 59, nil); err != nil {
+		errs = append(errs, fmt.Errorf("minute: %w", err))
+	}
+	if s.hour, err = parseField(fields[1], 0, 23, nil); err != nil {
+		errs = append(errs, fmt.Errorf("hour: %w", err))
+	}
+	if s.dom, err = parseField(fields[2], 1, 31, nil); err != nil {
+		errs = append(errs, fmt.Errorf("day of month: %w", err))
+	}
+	if s.month, err = parseField(fields[3], 1, 12, monthNames); err != nil {
+		errs = append(errs, fmt.Errorf("month: %w", err))
+	}
+	// 7 is sunday too
+	if s.dow, err = parseField(fields[4], 0, 7, dayNames); err != nil {
+		errs = append(errs, fmt.Errorf("day of week: %w", err))
+	}
+	if s.dow.has(7) {
+		s.dow |= 1
+	}
+	s.domAny = fields[2] == "*"
+	s.dowAny = fields[4] == "*"
+
+	return s, errors.Join(errs...)
+}
+
+// parseField parses a comma separated list of "*", values, ranges "a-b" and
+// steps "*/n" or "a-b/n". names are the names of the values from min.
+func parseField(value string, min, max int, names []string) (field, error) {
+	var f field
+	for _, part := range strings.Split(value, ",") {
+		rng, stepValue, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepValue)
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q", stepValue)
+			}
+		}
+
+		var lo, hi int
+		switch first, last, isRange := strings.Cut(rng, "-"); {
+		case rng == "*":
+			lo, hi = min, max
+		case isRange:
+			var err error
+			if lo, err = parseValue(first, min, max, names); err != nil {
+				return 0, err
+			}
+			if hi, err = parseValue(last, min, max, names); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("invalid range %q", rng)
+			}
+		default:
+			var err error
+			if lo, err = parseValue(rng, min, max, names); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if hasStep {
+				hi = max
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			f |= 1 << uint(v)
+		}
+	}
+
+	return f, nil
+}
+
+func parseValue(value string, min, max int, names []string) (int, error) {
+	for i, name := range names {
+		if strings.EqualFold(value, name) {
+			return min + i, nil
+		}
+	}
+
+	v, err := strconv.Atoi(value)
+	if err != nil || v < min || v > max {
+		return 0, fmt.Errorf("invalid value %q, it should be between %d and %d", value, min, max)
+	}
+
+	return v, nil
+}
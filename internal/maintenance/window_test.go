@@ -0,0 +1,118 @@
+package maintenance
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 2024-05-04 is a saturday
+var saturday = time.Date(2024, 5, 4, 0, 0, 0, 0, time.UTC)
+
+func TestParse(t *testing.T) {
+	for _, spec := range []string{
+		"0 2 * * sat,sun 4h",
+		"*/15 8-18 * * mon-fri 10m",
+		"CRON_TZ=Europe/Paris 30 23 1 jan-mar,dec * 1h30m",
+		"0 0 * * 7 24h",
+	} {
+		t.Run(spec, func(t *testing.T) {
+			window, err := Parse(spec)
+
+			assert.NoError(t, err)
+			assert.Equal(t, spec, window.String())
+		})
+	}
+
+	for _, spec := range []string{
+		"",
+		"0 2 * * sat",
+		"0 2 * * sat 0s",
+		"0 2 * * sat 8d",
+		"0 24 * * * 1h",
+		"60 2 * * * 1h",
+		"0 2 * * fun 1h",
+		"0 2 5-1 * * 1h",
+		"0 2 */0 * * 1h",
+		"CRON_TZ=Mars/Olympus 0 2 * * * 1h",
+	} {
+		t.Run(spec, func(t *testing.T) {
+			_, err := Parse(spec)
+
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestContains(t *testing.T) {
+	window, err := Parse("0 22 * * sat 4h")
+	assert.NoError(t, err)
+
+	tests := []struct {
+		time     time.Time
+		expected bool
+	}{
+		{saturday.Add(21*time.Hour + 59*time.Minute), false},
+		{saturday.Add(22 * time.Hour), true},
+		{saturday.Add(25*time.Hour + 59*time.Minute), true},
+		{saturday.Add(26 * time.Hour), false},
+		{saturday.Add(-2 * time.Hour), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.time.String(), func(t *testing.T) {
+			assert.Equal(t, tt.expected, window.Contains(tt.time))
+		})
+	}
+}
+
+func TestContainsTimeZone(t *testing.T) {
+	window, err := Parse("CRON_TZ=Asia/Kolkata 0 9 * * * 1h")
+	assert.NoError(t, err)
+
+	// 09:00 in Kolkata is 03:30 UTC
+	assert.True(t, window.Contains(saturday.Add(3*time.Hour+30*time.Minute)))
+	assert.False(t, window.Contains(saturday.Add(9*time.Hour)))
+	assert.Equal(t, saturday.Add(27*time.Hour+30*time.Minute), window.Next(saturday.Add(4*time.Hour)).UTC())
+}
+
+func TestNext(t *testing.T) {
+	tests := []struct {
+		spec     string
+		from     time.Time
+		expected time.Time
+	}{
+		{"0 22 * * sat 4h", saturday, saturday.Add(22 * time.Hour)},
+		{"0 22 * * sat 4h", saturday.Add(22 * time.Hour), saturday.Add(7*24*time.Hour + 22*time.Hour)},
+		{"*/15 8-18 * * mon-fri 10m", saturday, saturday.Add(2*24*time.Hour + 8*time.Hour)},
+		{"0 0 1 jan * 1h", saturday, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 feb * 1h", saturday, time.Time{}},
+		// Either the day of month or the day of week when both are set
+		{"0 0 13 * fri 1h", saturday, time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			window, err := Parse(tt.spec)
+			assert.NoError(t, err)
+
+			assert.Equal(t, tt.expected, window.Next(tt.from))
+		})
+	}
+}
+
+func TestWindows(t *testing.T) {
+	windows, err := ParseWindows("0 22 * * sat 4h; 0 12 * * * 30m;")
+	assert.NoError(t, err)
+	assert.Len(t, windows, 2)
+
+	assert.True(t, windows.Contains(saturday.Add(12*time.Hour+10*time.Minute)))
+	assert.True(t, windows.Contains(saturday.Add(23*time.Hour)))
+	assert.False(t, windows.Contains(saturday.Add(13*time.Hour)))
+	assert.Equal(t, saturday.Add(22*time.Hour), windows.Next(saturday.Add(13*time.Hour)))
+
+	empty, err := ParseWindows("")
+	assert.NoError(t, err)
+	assert.Empty(t, empty)
+}
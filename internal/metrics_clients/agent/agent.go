@@ -0,0 +1,124 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	volumeagent "github.com/lorenzophys/pvc-autoscaler/internal/agent"
+	clients "github.com/lorenzophys/pvc-autoscaler/internal/metrics_clients/clients"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const requestTimeout = 10 * time.Second
+
+// AgentClient queries every node agent behind a headless Service: its name
+// resolves to the addresses of all the agent pods
+type AgentClient struct {
+	url        *url.URL
+	httpClient *http.Client
+	lookupHost func(ctx context.Context, host string) ([]string, error)
+}
+
+func NewAgentClient(rawURL string) (clients.MetricsClient, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Hostname() == "" || u.Port() == "" {
+		return nil, fmt.Errorf("the agent url %q should have a host and a port", rawURL)
+	}
+
+	return &AgentClient{
+		url:        u,
+		httpClient: &http.Client{Timeout: requestTimeout},
+		lookupHost: net.DefaultResolver.LookupHost,
+	}, nil
+}
+
+// FetchPVCsMetrics returns the current usage, the agents have no history.
+// The metrics of the agents that could not be reached are missing, an error
+// is returned only if no agent answered.
+func (c *AgentClient) FetchPVCsMetrics(ctx context.Context, _ time.Time) (map[types.NamespacedName]*clients.PVCMetrics, error) {
+	addrs, err := c.lookupHost(ctx, c.url.Hostname())
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve the agents: %w", err)
+	}
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		errs    []error
+		ok      bool
+		metrics = make(map[types.NamespacedName]*clients.PVCMetrics)
+	)
+	for _, addr := range addrs {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+
+			volumes, err := c.fetchVolumes(ctx, addr)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("agent %s: %w", addr, err))
+				return
+			}
+			ok = true
+			for _, v := range volumes {
+				key := types.NamespacedName{Namespace: v.Namespace, Name: v.PVC}
+				// A ReadWriteMany volume is reported by every node mounting it
+				if m, found := metrics[key]; found {
+					m.VolumeUsedBytes = max(m.VolumeUsedBytes, v.UsedBytes)
+					m.VolumeCapacityBytes = max(m.VolumeCapacityBytes, v.CapacityBytes)
+					if v.Timestamp.After(m.Timestamp) {
+						m.Timestamp = v.Timestamp
+					}
+					continue
+				}
+				metrics[key] = &clients.PVCMetrics{VolumeUsedBytes: v.UsedBytes, VolumeCapacityBytes: v.CapacityBytes, Timestamp: v.Timestamp}
+			}
+		}(addr)
+	}
+	wg.Wait()
+
+	if !ok {
+		return nil, errors.Join(append([]error{errors.New("no agent answered")}, errs...)...)
+	}
+
+	return metrics, nil
+}
+
+func (c *AgentClient) fetchVolumes(ctx context.Context, addr string) ([]volumeagent.Volume, error) {
+	u := *c.url
+	u.Host = net.JoinHostPort(addr, c.url.Port())
+	u.Path = volumeagent.VolumesPath
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var volumes []volumeagent.Volume
+	if err := json.NewDecoder(resp.Body).Decode(&volumes); err != nil {
+		return nil, err
+	}
+
+	return volumes, nil
+}
@@ -0,0 +1,114 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	volumeagent "github.com/lorenzophys/pvc-autoscaler/internal/agent"
+	clients "github.com/lorenzophys/pvc-autoscaler/internal/metrics_clients/clients"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// newAgent serves volumes on 127.0.0.x at a random port, so that several
+// agents can share the port like on different nodes
+func newAgent(t *testing.T, ip string, port string, status int, volumes []volumeagent.Volume) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(ip, port))
+	if err != nil {
+		t.Skipf("cannot listen on %s: %v", ip, err)
+	}
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, volumeagent.VolumesPath, r.URL.Path)
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(volumes)
+	}))
+	ts.Listener.Close()
+	ts.Listener = listener
+	ts.Start()
+	t.Cleanup(ts.Close)
+}
+
+func freePort(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	return port
+}
+
+func newTestClient(t *testing.T, port string, addrs ...string) *AgentClient {
+	t.Helper()
+
+	client, err := NewAgentClient("http://agents.kube-system.svc:" + port)
+	assert.NoError(t, err)
+
+	c := client.(*AgentClient)
+	c.lookupHost = func(_ context.Context, host string) ([]string, error) {
+		assert.Equal(t, "agents.kube-system.svc", host)
+		return addrs, nil
+	}
+	return c
+}
+
+func TestFetchPVCsMetrics(t *testing.T) {
+	t.Run("aggregates the agents", func(t *testing.T) {
+		port := freePort(t)
+		newAgent(t, "127.0.0.1", port, http.StatusOK, []volumeagent.Volume{
+			{Namespace: "default", PVC: "data", UsedBytes: 40, CapacityBytes: 100},
+		})
+		newAgent(t, "127.0.0.2", port, http.StatusOK, []volumeagent.Volume{
+			{Namespace: "default", PVC: "data", UsedBytes: 45, CapacityBytes: 100},
+			{Namespace: "default", PVC: "logs", UsedBytes: 1, CapacityBytes: 10},
+		})
+
+		metrics, err := newTestClient(t, port, "127.0.0.1", "127.0.0.2").FetchPVCsMetrics(context.TODO(), time.Now())
+
+		assert.NoError(t, err)
+		assert.Equal(t, map[types.NamespacedName]*clients.PVCMetrics{
+			{Namespace: "default", Name: "data"}: {VolumeUsedBytes: 45, VolumeCapacityBytes: 100},
+			{Namespace: "default", Name: "logs"}: {VolumeUsedBytes: 1, VolumeCapacityBytes: 10},
+		}, metrics)
+	})
+
+	t.Run("unreachable agents are ignored", func(t *testing.T) {
+		port := freePort(t)
+		newAgent(t, "127.0.0.1", port, http.StatusOK, []volumeagent.Volume{
+			{Namespace: "default", PVC: "data", UsedBytes: 40, CapacityBytes: 100},
+		})
+		newAgent(t, "127.0.0.2", port, http.StatusInternalServerError, nil)
+
+		metrics, err := newTestClient(t, port, "127.0.0.1", "127.0.0.2").FetchPVCsMetrics(context.TODO(), time.Now())
+
+		assert.NoError(t, err)
+		assert.Len(t, metrics, 1)
+	})
+
+	t.Run("no agent answered", func(t *testing.T) {
+		port := freePort(t)
+		newAgent(t, "127.0.0.1", port, http.StatusInternalServerError, nil)
+
+		_, err := newTestClient(t, port, "127.0.0.1").FetchPVCsMetrics(context.TODO(), time.Now())
+
+		assert.ErrorContains(t, err, "no agent answered")
+	})
+}
+
+func TestNewAgentClient(t *testing.T) {
+	_, err := NewAgentClient("http://agents.kube-system.svc")
+	assert.Error(t, err)
+
+	client, err := NewAgentClient("http://agents.kube-system.svc:9100")
+	assert.NoError(t, err)
+	assert.Equal(t, &url.URL{Scheme: "http", Host: "agents.kube-system.svc:9100"}, client.(*AgentClient).url)
+}
@@ -10,8 +10,26 @@ import (
 type PVCMetrics struct {
 	VolumeUsedBytes     int64
 	VolumeCapacityBytes int64
+	// Timestamp is when the usage was sampled, zero if the client does not
+	// know it
+	Timestamp time.Time
 }
 
 type MetricsClient interface {
 	FetchPVCsMetrics(context.Context, time.Time) (map[types.NamespacedName]*PVCMetrics, error)
 }
+
+// TargetedMetricsClient is implemented by the clients that can query less when
+// they know the PVCs whose metrics are needed, e.g. a fallback chain
+type TargetedMetricsClient interface {
+	FetchMetricsOf(ctx context.Context, when time.Time, pvcs []types.NamespacedName) (map[types.NamespacedName]*PVCMetrics, error)
+}
+
+// FetchMetricsOf returns the metrics of pvcs, and possibly of other PVCs
+func FetchMetricsOf(ctx context.Context, client MetricsClient, when time.Time, pvcs []types.NamespacedName) (map[types.NamespacedName]*PVCMetrics, error) {
+	if targeted, ok := client.(TargetedMetricsClient); ok {
+		return targeted.FetchMetricsOf(ctx, when, pvcs)
+	}
+
+	return client.FetchPVCsMetrics(ctx, when)
+}
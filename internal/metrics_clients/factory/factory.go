@@ -0,0 +1,86 @@
+package factory
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lorenzophys/pvc-autoscaler/internal/metrics_clients/agent"
+	clients "github.com/lorenzophys/pvc-autoscaler/internal/metrics_clients/clients"
+	"github.com/lorenzophys/pvc-autoscaler/internal/metrics_clients/fallback"
+	"github.com/lorenzophys/pvc-autoscaler/internal/metrics_clients/kubelet"
+	"github.com/lorenzophys/pvc-autoscaler/internal/metrics_clients/prometheus"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes"
+)
+
+type Options struct {
+	// Name is a client name, or a comma separated list of names for a
+	// fallback chain, e.g. "prometheus,kubelet"
+	Name string
+	// URL is a comma separated list matched by position for a fallback chain
+	URL string
+	// Aggregation of the duplicate series of a PVC, used by prometheus
+	Aggregation string
+	// MaxAge is the age above which a fallback chain replaces a sample by the
+	// one of the next backends, zero disables it
+	MaxAge time.Duration
+
+	KubeClient kubernetes.Interface
+	Logger     *log.Logger
+	// Observe is called after each fetch of a backend of a fallback chain,
+	// it may be nil
+	Observe fallback.ObserveFunc
+}
+
+// MetricsClientFactory returns the metrics client described by opts, it is
+// shared by the autoscaler and the kubectl plugin
+func MetricsClientFactory(opts Options) (clients.MetricsClient, error) {
+	names := strings.Split(opts.Name, ",")
+	if len(names) == 1 {
+		return newMetricsClient(opts.Name, opts.URL, opts)
+	}
+
+	urls := strings.Split(opts.URL, ",")
+	var backends []fallback.Backend
+	for i, name := range names {
+		name = strings.TrimSpace(name)
+		if slices.ContainsFunc(backends, func(b fallback.Backend) bool { return b.Name == name }) {
+			return nil, fmt.Errorf("metrics client %s is listed twice", name)
+		}
+
+		var url string
+		if i < len(urls) {
+			url = strings.TrimSpace(urls[i])
+		}
+		client, err := newMetricsClient(name, url, opts)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		backends = append(backends, fallback.Backend{Name: name, Client: client})
+	}
+
+	return fallback.NewFallbackClient(backends, opts.MaxAge, opts.Observe)
+}
+
+func newMetricsClient(clientName, clientUrl string, opts Options) (clients.MetricsClient, error) {
+	switch clientName {
+	case "prometheus":
+		aggregation, err := prometheus.ParseAggregation(opts.Aggregation)
+		if err != nil {
+			return nil, err
+		}
+		prometheusClient, err := prometheus.NewPrometheusClient(clientUrl, aggregation, opts.Logger)
+		if err != nil {
+			return nil, err
+		}
+		return prometheusClient, nil
+	case "agent":
+		return agent.NewAgentClient(clientUrl)
+	case "kubelet":
+		return kubelet.NewKubeletClient(opts.KubeClient)
+	default:
+		return nil, fmt.Errorf("unknown metrics client: %s", clientName)
+	}
+}
@@ -0,0 +1,112 @@
+package fallback
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	clients "github.com/lorenzophys/pvc-autoscaler/internal/metrics_clients/clients"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Backend is a metrics client of the chain
+type Backend struct {
+	Name   string
+	Client clients.MetricsClient
+}
+
+// ObserveFunc is called after each fetch of a backend with the number of PVCs
+// it provided to the merged result, e.g. to export its health
+type ObserveFunc func(backend string, pvcs int, err error)
+
+// FallbackClient queries a chain of metrics clients. The metrics of the first
+// healthy backend are used, the next backends are only queried for the PVCs
+// it misses or reports with a stale sample.
+type FallbackClient struct {
+	backends []Backend
+	maxAge   time.Duration
+	observe  ObserveFunc
+}
+
+// NewFallbackClient returns a client querying the backends in order. The
+// samples older than maxAge are replaced by the fresh ones of the next
+// backends, zero disables it. observe may be nil.
+func NewFallbackClient(backends []Backend, maxAge time.Duration, observe ObserveFunc) (*FallbackClient, error) {
+	if len(backends) == 0 {
+		return nil, errors.New("the metrics client chain is empty")
+	}
+	if observe == nil {
+		observe = func(string, int, error) {}
+	}
+
+	return &FallbackClient{
+		backends: backends,
+		maxAge:   maxAge,
+		observe:  observe,
+	}, nil
+}
+
+// FetchPVCsMetrics queries every backend, the PVCs whose metrics are needed
+// being unknown. The PVCs missing from the primary are still evaluated.
+func (c *FallbackClient) FetchPVCsMetrics(ctx context.Context, when time.Time) (map[types.NamespacedName]*clients.PVCMetrics, error) {
+	return c.FetchMetricsOf(ctx, when, nil)
+}
+
+// FetchMetricsOf queries the backends in order until every PVC of pvcs has a
+// fresh sample, all of them when pvcs is nil. The sample of a backend is
+// only replaced by a next one when it is stale and the next one is not. An
+// error is returned only if all the queried backends failed.
+func (c *FallbackClient) FetchMetricsOf(ctx context.Context, when time.Time, pvcs []types.NamespacedName) (map[types.NamespacedName]*clients.PVCMetrics, error) {
+	var (
+		errs    []error
+		ok      bool
+		metrics = make(map[types.NamespacedName]*clients.PVCMetrics)
+	)
+	for _, backend := range c.backends {
+		if ok && pvcs != nil && c.complete(metrics, pvcs, when) {
+			break
+		}
+
+		backendMetrics, err := backend.Client.FetchPVCsMetrics(ctx, when)
+		if err != nil {
+			c.observe(backend.Name, 0, err)
+			errs = append(errs, fmt.Errorf("%s: %w", backend.Name, err))
+			continue
+		}
+
+		ok = true
+		added := 0
+		for key, m := range backendMetrics {
+			if current, found := metrics[key]; found && (!c.stale(current, when) || c.stale(m, when)) {
+				continue
+			}
+			metrics[key] = m
+			added++
+		}
+		c.observe(backend.Name, added, nil)
+	}
+
+	if !ok {
+		return nil, errors.Join(errs...)
+	}
+
+	return metrics, nil
+}
+
+// complete reports whether every PVC of pvcs has a fresh sample
+func (c *FallbackClient) complete(metrics map[types.NamespacedName]*clients.PVCMetrics, pvcs []types.NamespacedName, when time.Time) bool {
+	for _, key := range pvcs {
+		m, found := metrics[key]
+		if !found || c.stale(m, when) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// stale is false for the samples without timestamp, their age is unknown
+func (c *FallbackClient) stale(m *clients.PVCMetrics, when time.Time) bool {
+	return c.maxAge > 0 && !m.Timestamp.IsZero() && when.Sub(m.Timestamp) > c.maxAge
+}
@@ -0,0 +1,195 @@
+package fallback
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	clients "github.com/lorenzophys/pvc-autoscaler/internal/metrics_clients/clients"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+type staticClient struct {
+	metrics map[types.NamespacedName]*clients.PVCMetrics
+	err     error
+	calls   int
+}
+
+func (c *staticClient) FetchPVCsMetrics(context.Context, time.Time) (map[types.NamespacedName]*clients.PVCMetrics, error) {
+	c.calls++
+	return c.metrics, c.err
+}
+
+type observation struct {
+	backend string
+	pvcs    int
+	failed  bool
+}
+
+func TestFetchPVCsMetrics(t *testing.T) {
+	data := types.NamespacedName{Namespace: "default", Name: "data"}
+	logs := types.NamespacedName{Namespace: "default", Name: "logs"}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	fresh := now.Add(-time.Minute)
+	newer := now.Add(-10 * time.Second)
+	stale := now.Add(-10 * time.Minute)
+
+	testCases := []struct {
+		name             string
+		primary          *staticClient
+		secondary        *staticClient
+		pvcs             []types.NamespacedName
+		expected         map[types.NamespacedName]*clients.PVCMetrics
+		expectedErr      bool
+		secondaryQueried bool
+		observations     []observation
+	}{
+		{
+			name: "the primary wins and the secondary fills in",
+			primary: &staticClient{metrics: map[types.NamespacedName]*clients.PVCMetrics{
+				data: {VolumeUsedBytes: 40, VolumeCapacityBytes: 100},
+			}},
+			secondary: &staticClient{metrics: map[types.NamespacedName]*clients.PVCMetrics{
+				data: {VolumeUsedBytes: 41, VolumeCapacityBytes: 100},
+				logs: {VolumeUsedBytes: 1, VolumeCapacityBytes: 10},
+			}},
+			expected: map[types.NamespacedName]*clients.PVCMetrics{
+				data: {VolumeUsedBytes: 40, VolumeCapacityBytes: 100},
+				logs: {VolumeUsedBytes: 1, VolumeCapacityBytes: 10},
+			},
+			secondaryQueried: true,
+			observations:     []observation{{"primary", 1, false}, {"secondary", 1, false}},
+		},
+		{
+			name: "a fresh primary sample wins over a newer one",
+			primary: &staticClient{metrics: map[types.NamespacedName]*clients.PVCMetrics{
+				data: {VolumeUsedBytes: 40, VolumeCapacityBytes: 100, Timestamp: fresh},
+			}},
+			secondary: &staticClient{metrics: map[types.NamespacedName]*clients.PVCMetrics{
+				data: {VolumeUsedBytes: 41, VolumeCapacityBytes: 100, Timestamp: newer},
+			}},
+			expected: map[types.NamespacedName]*clients.PVCMetrics{
+				data: {VolumeUsedBytes: 40, VolumeCapacityBytes: 100, Timestamp: fresh},
+			},
+			secondaryQueried: true,
+			observations:     []observation{{"primary", 1, false}, {"secondary", 0, false}},
+		},
+		{
+			name: "a stale primary sample is replaced by a fresh one",
+			primary: &staticClient{metrics: map[types.NamespacedName]*clients.PVCMetrics{
+				data: {VolumeUsedBytes: 40, VolumeCapacityBytes: 100, Timestamp: stale},
+				logs: {VolumeUsedBytes: 2, VolumeCapacityBytes: 10, Timestamp: stale},
+			}},
+			secondary: &staticClient{metrics: map[types.NamespacedName]*clients.PVCMetrics{
+				data: {VolumeUsedBytes: 41, VolumeCapacityBytes: 100, Timestamp: fresh},
+				logs: {VolumeUsedBytes: 1, VolumeCapacityBytes: 10, Timestamp: stale.Add(time.Minute)},
+			}},
+			expected: map[types.NamespacedName]*clients.PVCMetrics{
+				data: {VolumeUsedBytes: 41, VolumeCapacityBytes: 100, Timestamp: fresh},
+				logs: {VolumeUsedBytes: 2, VolumeCapacityBytes: 10, Timestamp: stale},
+			},
+			secondaryQueried: true,
+			observations:     []observation{{"primary", 2, false}, {"secondary", 1, false}},
+		},
+		{
+			name: "the secondary is not queried when the primary has every pvc",
+			primary: &staticClient{metrics: map[types.NamespacedName]*clients.PVCMetrics{
+				data: {VolumeUsedBytes: 40, VolumeCapacityBytes: 100, Timestamp: fresh},
+				logs: {VolumeUsedBytes: 2, VolumeCapacityBytes: 10},
+			}},
+			secondary: &staticClient{metrics: map[types.NamespacedName]*clients.PVCMetrics{
+				data: {VolumeUsedBytes: 41, VolumeCapacityBytes: 100, Timestamp: fresh},
+			}},
+			pvcs: []types.NamespacedName{data, logs},
+			expected: map[types.NamespacedName]*clients.PVCMetrics{
+				data: {VolumeUsedBytes: 40, VolumeCapacityBytes: 100, Timestamp: fresh},
+				logs: {VolumeUsedBytes: 2, VolumeCapacityBytes: 10},
+			},
+			observations: []observation{{"primary", 2, false}},
+		},
+		{
+			name: "the secondary is queried for a missing pvc",
+			primary: &staticClient{metrics: map[types.NamespacedName]*clients.PVCMetrics{
+				data: {VolumeUsedBytes: 40, VolumeCapacityBytes: 100, Timestamp: fresh},
+			}},
+			secondary: &staticClient{metrics: map[types.NamespacedName]*clients.PVCMetrics{
+				logs: {VolumeUsedBytes: 1, VolumeCapacityBytes: 10, Timestamp: fresh},
+			}},
+			pvcs: []types.NamespacedName{data, logs},
+			expected: map[types.NamespacedName]*clients.PVCMetrics{
+				data: {VolumeUsedBytes: 40, VolumeCapacityBytes: 100, Timestamp: fresh},
+				logs: {VolumeUsedBytes: 1, VolumeCapacityBytes: 10, Timestamp: fresh},
+			},
+			secondaryQueried: true,
+			observations:     []observation{{"primary", 1, false}, {"secondary", 1, false}},
+		},
+		{
+			name: "the secondary is queried for a stale pvc",
+			primary: &staticClient{metrics: map[types.NamespacedName]*clients.PVCMetrics{
+				data: {VolumeUsedBytes: 40, VolumeCapacityBytes: 100, Timestamp: stale},
+			}},
+			secondary: &staticClient{metrics: map[types.NamespacedName]*clients.PVCMetrics{
+				data: {VolumeUsedBytes: 41, VolumeCapacityBytes: 100, Timestamp: fresh},
+			}},
+			pvcs: []types.NamespacedName{data},
+			expected: map[types.NamespacedName]*clients.PVCMetrics{
+				data: {VolumeUsedBytes: 41, VolumeCapacityBytes: 100, Timestamp: fresh},
+			},
+			secondaryQueried: true,
+			observations:     []observation{{"primary", 1, false}, {"secondary", 1, false}},
+		},
+		{
+			name:    "the primary is down",
+			primary: &staticClient{err: errors.New("connection refused")},
+			secondary: &staticClient{metrics: map[types.NamespacedName]*clients.PVCMetrics{
+				data: {VolumeUsedBytes: 41, VolumeCapacityBytes: 100},
+			}},
+			pvcs: []types.NamespacedName{data},
+			expected: map[types.NamespacedName]*clients.PVCMetrics{
+				data: {VolumeUsedBytes: 41, VolumeCapacityBytes: 100},
+			},
+			secondaryQueried: true,
+			observations:     []observation{{"primary", 0, true}, {"secondary", 1, false}},
+		},
+		{
+			name:             "all the backends are down",
+			primary:          &staticClient{err: errors.New("connection refused")},
+			secondary:        &staticClient{err: errors.New("forbidden")},
+			expectedErr:      true,
+			secondaryQueried: true,
+			observations:     []observation{{"primary", 0, true}, {"secondary", 0, true}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var observations []observation
+			client, err := NewFallbackClient([]Backend{
+				{Name: "primary", Client: tc.primary},
+				{Name: "secondary", Client: tc.secondary},
+			}, 5*time.Minute, func(backend string, pvcs int, err error) {
+				observations = append(observations, observation{backend, pvcs, err != nil})
+			})
+			assert.NoError(t, err)
+
+			metrics, err := client.FetchMetricsOf(context.TODO(), now, tc.pvcs)
+
+			if tc.expectedErr {
+				assert.ErrorContains(t, err, "primary: connection refused")
+				assert.ErrorContains(t, err, "secondary: forbidden")
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.expected, metrics)
+			assert.Equal(t, tc.secondaryQueried, tc.secondary.calls > 0)
+			assert.Equal(t, tc.observations, observations)
+		})
+	}
+}
+
+func TestNewFallbackClient(t *testing.T) {
+	_, err := NewFallbackClient(nil, 0, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,129 @@
+package kubelet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	clients "github.com/lorenzophys/pvc-autoscaler/internal/metrics_clients/clients"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// The subset of the kubelet stats summary holding the volume usage
+type summary struct {
+	Pods []struct {
+		Volumes []struct {
+			Time          metav1.Time `json:"time"`
+			UsedBytes     *int64      `json:"usedBytes"`
+			CapacityBytes *int64      `json:"capacityBytes"`
+			PVCRef        *struct {
+				Name      string `json:"name"`
+				Namespace string `json:"namespace"`
+			} `json:"pvcRef"`
+		} `json:"volume"`
+	} `json:"pods"`
+}
+
+// KubeletClient reads the stats summary of every node through the API server
+// proxy, the source Prometheus scrapes the kubelet volume stats from. It does
+// not need any URL.
+type KubeletClient struct {
+	kubeClient   kubernetes.Interface
+	fetchSummary func(ctx context.Context, node string) ([]byte, error)
+}
+
+func NewKubeletClient(kubeClient kubernetes.Interface) (clients.MetricsClient, error) {
+	if kubeClient == nil {
+		return nil, errors.New("the kubelet metrics client needs a Kubernetes client")
+	}
+
+	c := &KubeletClient{kubeClient: kubeClient}
+	c.fetchSummary = c.proxySummary
+	return c, nil
+}
+
+// FetchPVCsMetrics returns the current usage, the kubelets have no history.
+// The metrics of the nodes that could not be reached are missing, an error
+// is returned only if no node answered.
+func (c *KubeletClient) FetchPVCsMetrics(ctx context.Context, _ time.Time) (map[types.NamespacedName]*clients.PVCMetrics, error) {
+	nodes, err := c.kubeClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("could not list the nodes: %w", err)
+	}
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		errs    []error
+		ok      bool
+		metrics = make(map[types.NamespacedName]*clients.PVCMetrics)
+	)
+	for _, node := range nodes.Items {
+		wg.Add(1)
+		go func(node string) {
+			defer wg.Done()
+
+			s, err := c.nodeSummary(ctx, node)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("node %s: %w", node, err))
+				return
+			}
+			ok = true
+			for _, pod := range s.Pods {
+				for _, v := range pod.Volumes {
+					if v.PVCRef == nil || v.UsedBytes == nil || v.CapacityBytes == nil {
+						continue
+					}
+					key := types.NamespacedName{Namespace: v.PVCRef.Namespace, Name: v.PVCRef.Name}
+					// A ReadWriteMany volume is reported by every pod mounting it
+					if m, found := metrics[key]; found {
+						m.VolumeUsedBytes = max(m.VolumeUsedBytes, *v.UsedBytes)
+						m.VolumeCapacityBytes = max(m.VolumeCapacityBytes, *v.CapacityBytes)
+						if v.Time.After(m.Timestamp) {
+							m.Timestamp = v.Time.Time
+						}
+						continue
+					}
+					metrics[key] = &clients.PVCMetrics{VolumeUsedBytes: *v.UsedBytes, VolumeCapacityBytes: *v.CapacityBytes, Timestamp: v.Time.Time}
+				}
+			}
+		}(node.Name)
+	}
+	wg.Wait()
+
+	if !ok {
+		return nil, errors.Join(append([]error{errors.New("no kubelet answered")}, errs...)...)
+	}
+
+	return metrics, nil
+}
+
+func (c *KubeletClient) nodeSummary(ctx context.Context, node string) (*summary, error) {
+	data, err := c.fetchSummary(ctx, node)
+	if err != nil {
+		return nil, err
+	}
+
+	var s summary
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("could not decode the stats summary: %w", err)
+	}
+
+	return &s, nil
+}
+
+func (c *KubeletClient) proxySummary(ctx context.Context, node string) ([]byte, error) {
+	return c.kubeClient.CoreV1().RESTClient().Get().
+		Resource("nodes").
+		Name(node).
+		SubResource("proxy", "stats", "summary").
+		DoRaw(ctx)
+}
@@ -0,0 +1,94 @@
+package kubelet
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	clients "github.com/lorenzophys/pvc-autoscaler/internal/metrics_clients/clients"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const summaryNode1 = `{
+  "node": {"nodeName": "node-1"},
+  "pods": [
+    {
+      "podRef": {"name": "db-0", "namespace": "default"},
+      "volume": [
+        {"name": "data", "usedBytes": 40, "capacityBytes": 100, "pvcRef": {"name": "data", "namespace": "default"}},
+        {"name": "tmp", "usedBytes": 1, "capacityBytes": 5}
+      ]
+    },
+    {
+      "podRef": {"name": "db-1", "namespace": "default"},
+      "volume": [
+        {"name": "data", "usedBytes": 45, "capacityBytes": 100, "pvcRef": {"name": "data", "namespace": "default"}}
+      ]
+    }
+  ]
+}`
+
+const summaryNode2 = `{
+  "pods": [
+    {
+      "volume": [
+        {"name": "logs", "usedBytes": 1, "capacityBytes": 10, "pvcRef": {"name": "logs", "namespace": "monitoring"}},
+        {"name": "pending", "pvcRef": {"name": "pending", "namespace": "monitoring"}}
+      ]
+    }
+  ]
+}`
+
+func newTestClient(summaries map[string]string) *KubeletClient {
+	kubeClient := fake.NewSimpleClientset(
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-2"}},
+	)
+	client, _ := NewKubeletClient(kubeClient)
+
+	c := client.(*KubeletClient)
+	c.fetchSummary = func(_ context.Context, node string) ([]byte, error) {
+		summary, ok := summaries[node]
+		if !ok {
+			return nil, errors.New("node unreachable")
+		}
+		return []byte(summary), nil
+	}
+	return c
+}
+
+func TestFetchPVCsMetrics(t *testing.T) {
+	t.Run("aggregates the nodes", func(t *testing.T) {
+		c := newTestClient(map[string]string{"node-1": summaryNode1, "node-2": summaryNode2})
+
+		metrics, err := c.FetchPVCsMetrics(context.TODO(), time.Now())
+
+		assert.NoError(t, err)
+		assert.Equal(t, map[types.NamespacedName]*clients.PVCMetrics{
+			{Namespace: "default", Name: "data"}:    {VolumeUsedBytes: 45, VolumeCapacityBytes: 100},
+			{Namespace: "monitoring", Name: "logs"}: {VolumeUsedBytes: 1, VolumeCapacityBytes: 10},
+		}, metrics)
+	})
+
+	t.Run("unreachable nodes are ignored", func(t *testing.T) {
+		c := newTestClient(map[string]string{"node-2": summaryNode2})
+
+		metrics, err := c.FetchPVCsMetrics(context.TODO(), time.Now())
+
+		assert.NoError(t, err)
+		assert.Len(t, metrics, 1)
+	})
+
+	t.Run("no node answered", func(t *testing.T) {
+		c := newTestClient(map[string]string{})
+
+		_, err := c.FetchPVCsMetrics(context.TODO(), time.Now())
+
+		assert.ErrorContains(t, err, "no kubelet answered")
+	})
+}
@@ -3,25 +3,54 @@ package prometheus
 import (
 	"context"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	clients "github.com/lorenzophys/pvc-autoscaler/internal/metrics_clients/clients"
 	prometheusApi "github.com/prometheus/client_golang/api"
 	prometheusv1 "github.com/prometheus/client_golang/api/prometheus/v1"
 	"github.com/prometheus/common/model"
+	log "github.com/sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/types"
 )
 
 const (
 	usedBytesQuery     = "kubelet_volume_stats_used_bytes"
 	capacityBytesQuery = "kubelet_volume_stats_capacity_bytes"
+	// The samples of an instant query are stamped with the evaluation time,
+	// timestamp() returns the time of the scrape they come from
+	sampleTimestampQuery = "timestamp(" + usedBytesQuery + ")"
 )
 
+// Aggregation combines the series of a PVC reported by several kubelets, e.g.
+// for a ReadWriteMany volume or while a pod moves to another node
+type Aggregation string
+
+const (
+	AggregationMax Aggregation = "max"
+	AggregationMin Aggregation = "min"
+	AggregationAvg Aggregation = "avg"
+)
+
+func ParseAggregation(s string) (Aggregation, error) {
+	switch a := Aggregation(s); a {
+	case AggregationMax, AggregationMin, AggregationAvg:
+		return a, nil
+	case "":
+		return AggregationMax, nil
+	default:
+		return "", fmt.Errorf("unknown aggregation %q, expected max, min or avg", s)
+	}
+}
+
 type PrometheusClient struct {
 	prometheusAPI prometheusv1.API
+	aggregation   Aggregation
+	logger        *log.Logger
 }
 
-func NewPrometheusClient(url string) (clients.MetricsClient, error) {
+func NewPrometheusClient(url string, aggregation Aggregation, logger *log.Logger) (clients.MetricsClient, error) {
 	client, err := prometheusApi.NewClient(prometheusApi.Config{
 		Address: url,
 	})
@@ -32,24 +61,36 @@ func NewPrometheusClient(url string) (clients.MetricsClient, error) {
 
 	return &PrometheusClient{
 		prometheusAPI: v1api,
+		aggregation:   aggregation,
+		logger:        logger,
 	}, nil
 }
 
 func (c *PrometheusClient) FetchPVCsMetrics(ctx context.Context, when time.Time) (map[types.NamespacedName]*clients.PVCMetrics, error) {
 	volumeStats := make(map[types.NamespacedName]*clients.PVCMetrics)
 
-	usedBytes, err := c.getMetricValues(ctx, usedBytesQuery, when)
+	usedBytes, err := c.getMetricValues(ctx, usedBytesQuery, when, c.aggregationName())
+	if err != nil {
+		return nil, err
+	}
+
+	capacityBytes, err := c.getMetricValues(ctx, capacityBytesQuery, when, c.aggregationName())
 	if err != nil {
 		return nil, err
 	}
 
-	capacityBytes, err := c.getMetricValues(ctx, capacityBytesQuery, when)
+	// The newest sample dates the metrics whatever the aggregation of the
+	// values, the staleness would be overestimated otherwise
+	sampleTimestamps, err := c.getMetricValues(ctx, sampleTimestampQuery, when, AggregationMax)
 	if err != nil {
 		return nil, err
 	}
 
 	for key, val := range usedBytes {
 		pvcMetrics := &clients.PVCMetrics{VolumeUsedBytes: val}
+		if ts, ok := sampleTimestamps[key]; ok {
+			pvcMetrics.Timestamp = time.Unix(ts, 0)
+		}
 		if cb, ok := capacityBytes[key]; ok {
 			pvcMetrics.VolumeCapacityBytes = cb
 		} else {
@@ -62,7 +103,7 @@ func (c *PrometheusClient) FetchPVCsMetrics(ctx context.Context, when time.Time)
 	return volumeStats, nil
 }
 
-func (c *PrometheusClient) getMetricValues(ctx context.Context, query string, time time.Time) (map[types.NamespacedName]int64, error) {
+func (c *PrometheusClient) getMetricValues(ctx context.Context, query string, time time.Time, aggregation Aggregation) (map[types.NamespacedName]int64, error) {
 	res, _, err := c.prometheusAPI.Query(ctx, query, time)
 	if err != nil {
 		return nil, err
@@ -71,14 +112,71 @@ func (c *PrometheusClient) getMetricValues(ctx context.Context, query string, ti
 	if res.Type() != model.ValVector {
 		return nil, fmt.Errorf("unknown response type: %s", res.Type().String())
 	}
-	resultMap := make(map[types.NamespacedName]int64)
+	series := make(map[types.NamespacedName][]*model.Sample)
 	vec := res.(model.Vector)
 	for _, val := range vec {
 		nn := types.NamespacedName{
 			Namespace: string(val.Metric["namespace"]),
 			Name:      string(val.Metric["persistentvolumeclaim"]),
 		}
-		resultMap[nn] = int64(val.Value)
+		series[nn] = append(series[nn], val)
+	}
+
+	resultMap := make(map[types.NamespacedName]int64, len(series))
+	for nn, samples := range series {
+		if len(samples) > 1 {
+			c.debugf("pvc %s has %d series for %s from %s, using the %s", nn, len(samples), query, instances(samples), aggregation)
+		}
+		resultMap[nn] = int64(aggregate(samples, aggregation))
 	}
 	return resultMap, nil
 }
+
+func (c *PrometheusClient) aggregationName() Aggregation {
+	if c.aggregation == "" {
+		return AggregationMax
+	}
+	return c.aggregation
+}
+
+func (c *PrometheusClient) debugf(format string, args ...any) {
+	if c.logger != nil {
+		c.logger.Debugf(format, args...)
+	}
+}
+
+// aggregate defaults to the max, the safe choice for an autoscaler
+func aggregate(samples []*model.Sample, aggregation Aggregation) model.SampleValue {
+	values := make([]model.SampleValue, 0, len(samples))
+	for _, s := range samples {
+		values = append(values, s.Value)
+	}
+
+	switch aggregation {
+	case AggregationMin:
+		return slices.Min(values)
+	case AggregationAvg:
+		var sum model.SampleValue
+		for _, v := range values {
+			sum += v
+		}
+		return sum / model.SampleValue(len(values))
+	default:
+		return slices.Max(values)
+	}
+}
+
+// instances lists the scrape targets of the series, sorted for stable logs
+func instances(samples []*model.Sample) string {
+	names := make([]string, 0, len(samples))
+	for _, s := range samples {
+		name := string(s.Metric["instance"])
+		if node := s.Metric["node"]; node != "" {
+			name = string(node)
+		}
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return strings.Join(names, ", ")
+}
@@ -3,14 +3,19 @@ package prometheus
 import (
 	"context"
 	"errors"
+	"fmt"
+	"math/rand"
 	"net/http"
 	"net/http/httptest"
+	"slices"
 	"testing"
 	"time"
 
 	clients "github.com/lorenzophys/pvc-autoscaler/internal/metrics_clients/clients"
 	prometheusv1 "github.com/prometheus/client_golang/api/prometheus/v1"
 	prometheusmodel "github.com/prometheus/common/model"
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/mock/gomock"
 	"k8s.io/apimachinery/pkg/types"
@@ -25,7 +30,7 @@ func TestGetMetricValues(t *testing.T) {
 		defer ts.Close()
 
 		// If 404 the client should be created
-		client, err := NewPrometheusClient(ts.URL)
+		client, err := NewPrometheusClient(ts.URL, AggregationMax, nil)
 		assert.NoError(t, err)
 
 		// but the metrics obviously cannot be fetched
@@ -61,7 +66,7 @@ func TestGetMetricValues(t *testing.T) {
 			Return(mockReturn, nil, nil).
 			AnyTimes()
 
-		result, err := client.getMetricValues(context.TODO(), "good_query", time.Time{})
+		result, err := client.getMetricValues(context.TODO(), "good_query", time.Time{}, AggregationMax)
 
 		assert.NoError(t, err)
 		assert.Equal(t, expectedResult, result)
@@ -83,13 +88,133 @@ func TestGetMetricValues(t *testing.T) {
 			Return(nil, nil, errors.New("generic error")).
 			AnyTimes()
 
-		_, err := client.getMetricValues(context.TODO(), "bad_query", time.Time{})
+		_, err := client.getMetricValues(context.TODO(), "bad_query", time.Time{}, AggregationMax)
 
 		assert.Error(t, err)
 
 	})
 }
 
+func TestGetMetricValuesDuplicateSeries(t *testing.T) {
+	// A ReadWriteMany volume mounted on two nodes and a pvc whose pod is
+	// moving to another node
+	mockReturn := prometheusmodel.Vector{
+		&prometheusmodel.Sample{
+			Metric: prometheusmodel.Metric{"namespace": "default", "persistentvolumeclaim": "shared", "instance": "10.0.0.1:10250", "node": "node-1"},
+			Value:  60,
+		},
+		&prometheusmodel.Sample{
+			Metric: prometheusmodel.Metric{"namespace": "default", "persistentvolumeclaim": "shared", "instance": "10.0.0.2:10250", "node": "node-2"},
+			Value:  80,
+		},
+		&prometheusmodel.Sample{
+			Metric: prometheusmodel.Metric{"namespace": "default", "persistentvolumeclaim": "moving", "instance": "10.0.0.1:10250"},
+			Value:  10,
+		},
+		&prometheusmodel.Sample{
+			Metric: prometheusmodel.Metric{"namespace": "default", "persistentvolumeclaim": "moving", "instance": "10.0.0.3:10250"},
+			Value:  15,
+		},
+		&prometheusmodel.Sample{
+			Metric: prometheusmodel.Metric{"namespace": "default", "persistentvolumeclaim": "single", "instance": "10.0.0.1:10250"},
+			Value:  5,
+		},
+	}
+
+	testCases := []struct {
+		aggregation Aggregation
+		expected    map[types.NamespacedName]int64
+	}{
+		{
+			aggregation: "",
+			expected: map[types.NamespacedName]int64{
+				{Namespace: "default", Name: "shared"}: 80,
+				{Namespace: "default", Name: "moving"}: 15,
+				{Namespace: "default", Name: "single"}: 5,
+			},
+		},
+		{
+			aggregation: AggregationMax,
+			expected: map[types.NamespacedName]int64{
+				{Namespace: "default", Name: "shared"}: 80,
+				{Namespace: "default", Name: "moving"}: 15,
+				{Namespace: "default", Name: "single"}: 5,
+			},
+		},
+		{
+			aggregation: AggregationMin,
+			expected: map[types.NamespacedName]int64{
+				{Namespace: "default", Name: "shared"}: 60,
+				{Namespace: "default", Name: "moving"}: 10,
+				{Namespace: "default", Name: "single"}: 5,
+			},
+		},
+		{
+			aggregation: AggregationAvg,
+			expected: map[types.NamespacedName]int64{
+				{Namespace: "default", Name: "shared"}: 70,
+				{Namespace: "default", Name: "moving"}: 12,
+				{Namespace: "default", Name: "single"}: 5,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(string(tc.aggregation), func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockAPI := NewMockAPI(ctrl)
+
+			logger, hook := test.NewNullLogger()
+			logger.SetLevel(log.DebugLevel)
+			client := &PrometheusClient{
+				prometheusAPI: mockAPI,
+				aggregation:   tc.aggregation,
+				logger:        logger,
+			}
+
+			// Shuffled so that the result does not depend on the order
+			mockAPI.
+				EXPECT().
+				Query(context.TODO(), "duplicate_query", time.Time{}).
+				DoAndReturn(func(context.Context, string, time.Time, ...prometheusv1.Option) (prometheusmodel.Value, prometheusv1.Warnings, error) {
+					shuffled := slices.Clone(mockReturn)
+					rand.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+					return shuffled, nil, nil
+				}).
+				Times(5)
+
+			for i := 0; i < 5; i++ {
+				result, err := client.getMetricValues(context.TODO(), "duplicate_query", time.Time{}, client.aggregationName())
+
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expected, result)
+			}
+
+			var messages []string
+			for _, entry := range hook.AllEntries() {
+				messages = append(messages, entry.Message)
+			}
+			assert.Contains(t, messages, fmt.Sprintf("pvc default/shared has 2 series for duplicate_query from node-1, node-2, using the %s", client.aggregationName()))
+			assert.Contains(t, messages, fmt.Sprintf("pvc default/moving has 2 series for duplicate_query from 10.0.0.1:10250, 10.0.0.3:10250, using the %s", client.aggregationName()))
+		})
+	}
+}
+
+func TestParseAggregation(t *testing.T) {
+	aggregation, err := ParseAggregation("")
+	assert.NoError(t, err)
+	assert.Equal(t, AggregationMax, aggregation)
+
+	aggregation, err = ParseAggregation("avg")
+	assert.NoError(t, err)
+	assert.Equal(t, AggregationAvg, aggregation)
+
+	_, err = ParseAggregation("sum")
+	assert.Error(t, err)
+}
+
 func TestFetchPVCsMetrics(t *testing.T) {
 	t.Run("everything fine", func(t *testing.T) {
 		ctrl := gomock.NewController(t)
@@ -117,9 +242,18 @@ func TestFetchPVCsMetrics(t *testing.T) {
 			},
 		}
 
+		mockSampleTimestampQuery := prometheusmodel.Vector{
+			&prometheusmodel.Sample{
+				Metric:    prometheusmodel.Metric{"namespace": "default", "persistentvolumeclaim": "mypvc"},
+				Value:     100,
+				Timestamp: prometheusmodel.TimeFromUnix(123),
+			},
+		}
+
 		expectedPVCMetric := &clients.PVCMetrics{
 			VolumeUsedBytes:     80,
 			VolumeCapacityBytes: 100,
+			Timestamp:           time.Unix(100, 0),
 		}
 
 		expectedResult := map[types.NamespacedName]*clients.PVCMetrics{
@@ -130,12 +264,15 @@ func TestFetchPVCsMetrics(t *testing.T) {
 			EXPECT().
 			Query(context.TODO(), gomock.Any(), time.Time{}).
 			DoAndReturn(func(ctx context.Context, query string, time time.Time, args ...any) (prometheusmodel.Value, prometheusv1.Warnings, error) {
-				if query == usedBytesQuery {
+				switch query {
+				case usedBytesQuery:
 					return mockUsedBytesQuery, nil, nil
-				} else {
+				case sampleTimestampQuery:
+					return mockSampleTimestampQuery, nil, nil
+				default:
 					return mockCapacityBytesQuery, nil, nil
 				}
-			}).Times(2)
+			}).Times(3)
 
 		result, err := client.FetchPVCsMetrics(context.TODO(), time.Time{})
 
@@ -143,4 +280,50 @@ func TestFetchPVCsMetrics(t *testing.T) {
 		assert.Equal(t, expectedResult, result)
 	})
 
+	t.Run("newest timestamp whatever the aggregation", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		mockAPI := NewMockAPI(ctrl)
+
+		client := &PrometheusClient{
+			prometheusAPI: mockAPI,
+			aggregation:   AggregationMin,
+		}
+
+		// The pod of the pvc moved to another node, the old series is stale
+		twoSeries := func(old, new prometheusmodel.SampleValue) prometheusmodel.Vector {
+			return prometheusmodel.Vector{
+				&prometheusmodel.Sample{
+					Metric: prometheusmodel.Metric{"namespace": "default", "persistentvolumeclaim": "mypvc", "instance": "10.0.0.1:10250"},
+					Value:  old,
+				},
+				&prometheusmodel.Sample{
+					Metric: prometheusmodel.Metric{"namespace": "default", "persistentvolumeclaim": "mypvc", "instance": "10.0.0.2:10250"},
+					Value:  new,
+				},
+			}
+		}
+
+		mockAPI.
+			EXPECT().
+			Query(context.TODO(), gomock.Any(), time.Time{}).
+			DoAndReturn(func(ctx context.Context, query string, time time.Time, args ...any) (prometheusmodel.Value, prometheusv1.Warnings, error) {
+				switch query {
+				case usedBytesQuery:
+					return twoSeries(70, 80), nil, nil
+				case sampleTimestampQuery:
+					return twoSeries(100, 400), nil, nil
+				default:
+					return twoSeries(100, 100), nil, nil
+				}
+			}).Times(3)
+
+		result, err := client.FetchPVCsMetrics(context.TODO(), time.Time{})
+
+		assert.NoError(t, err)
+		assert.Equal(t, map[types.NamespacedName]*clients.PVCMetrics{
+			{Namespace: "default", Name: "mypvc"}: {VolumeUsedBytes: 70, VolumeCapacityBytes: 100, Timestamp: time.Unix(400, 0)},
+		}, result)
+	})
 }
@@ -0,0 +1,226 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type EventType string
+
+const (
+	EventResized        EventType = "resized"
+	EventCeilingReached EventType = "ceiling_reached"
+	EventResizeStalled  EventType = "resize_stalled"
+	EventInvalidConfig  EventType = "invalid_config"
+)
+
+var AllEventTypes = []EventType{
+	EventResized,
+	EventCeilingReached,
+	EventResizeStalled,
+	EventInvalidConfig,
+}
+
+const DefaultHTTPTimeout = 10 * time.Second
+
+// Event describes something worth telling a human about. Sizes are in bytes
+// and are zero when they don't apply to the event type.
+type Event struct {
+	Type          EventType `json:"type"`
+	Namespace     string    `json:"namespace"`
+	PVC           string    `json:"pvc"`
+	OldSizeBytes  int64     `json:"oldSizeBytes,omitempty"`
+	NewSizeBytes  int64     `json:"newSizeBytes,omitempty"`
+	UsedBytes     int64     `json:"usedBytes,omitempty"`
+	CapacityBytes int64     `json:"capacityBytes,omitempty"`
+	Reason        string    `json:"reason,omitempty"`
+	// Tier is the tier of the tiers annotation that triggered a resize
+	Tier string    `json:"tier,omitempty"`
+	Time time.Time `json:"time"`
+}
+
+type Notifier interface {
+	Notify(ctx context.Context, event Event) error
+}
+
+type route struct {
+	notifier Notifier
+	events   []EventType
+}
+
+// Dispatcher forwards the events to the notifiers subscribed to their type.
+// The notifications are sent in the background, so that a slow receiver does
+// not hold the reconciliation. The same event for the same PVC is not sent
+// again to a notifier before repeatInterval once it received it.
+type Dispatcher struct {
+	routes         []route
+	repeatInterval time.Duration
+	logger         *log.Logger
+
+	queue   chan Event
+	ctx     context.Context
+	cancel  context.CancelFunc
+	closing chan struct{}
+	closed  chan struct{}
+	once    sync.Once
+
+	// sent is keyed by route and event, it is only used by the goroutine
+	// sending the notifications
+	sent map[string]time.Time
+}
+
+// queueSize is the number of notifications waiting to be sent, the next ones
+// are dropped
+const queueSize = 100
+
+func NewDispatcher(repeatInterval time.Duration, logger *log.Logger) *Dispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &Dispatcher{
+		repeatInterval: repeatInterval,
+		logger:         logger,
+		queue:          make(chan Event, queueSize),
+		ctx:            ctx,
+		cancel:         cancel,
+		closing:        make(chan struct{}),
+		closed:         make(chan struct{}),
+		sent:           make(map[string]time.Time),
+	}
+	go d.run()
+
+	return d
+}
+
+func (d *Dispatcher) Register(notifier Notifier, events []EventType) {
+	d.routes = append(d.routes, route{notifier: notifier, events: events})
+}
+
+// Dispatch queues the event, it never blocks
+func (d *Dispatcher) Dispatch(event Event) {
+	if len(d.routes) == 0 {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	select {
+	case d.queue <- event:
+	default:
+		d.logger.Errorf("notification queue full, dropping the %s notification for %s/%s", event.Type, event.Namespace, event.PVC)
+	}
+}
+
+// Close sends the queued notifications and stops the dispatcher. The
+// notifications still queued when ctx is done are dropped.
+func (d *Dispatcher) Close(ctx context.Context) {
+	d.once.Do(func() { close(d.closing) })
+
+	select {
+	case <-d.closed:
+	case <-ctx.Done():
+		d.cancel()
+		<-d.closed
+	}
+}
+
+func (d *Dispatcher) run() {
+	defer close(d.closed)
+	defer d.cancel()
+
+	for {
+		select {
+		case event := <-d.queue:
+			d.send(event)
+		case <-d.closing:
+			for {
+				select {
+				case event := <-d.queue:
+					if d.ctx.Err() != nil {
+						return
+					}
+					d.send(event)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (d *Dispatcher) send(event Event) {
+	d.forgetExpired(event.Time)
+
+	// A notification is sent again with the next event to the notifiers
+	// that failed to receive it, not to the others
+	key := fmt.Sprintf("%s/%s/%s/%d/%s", event.Type, event.Namespace, event.PVC, event.NewSizeBytes, event.Reason)
+	for i, r := range d.routes {
+		if !slices.Contains(r.events, event.Type) {
+			continue
+		}
+		routeKey := fmt.Sprintf("%d/%s", i, key)
+		if _, ok := d.sent[routeKey]; ok {
+			d.logger.Debugf("notification %s for %s/%s already sent recently", event.Type, event.Namespace, event.PVC)
+			continue
+		}
+		if err := r.notifier.Notify(d.ctx, event); err != nil {
+			d.logger.Errorf("failed to send %s notification for %s/%s: %v", event.Type, event.Namespace, event.PVC, err)
+			continue
+		}
+		d.sent[routeKey] = event.Time
+	}
+}
+
+func (d *Dispatcher) forgetExpired(now time.Time) {
+	for k, sentAt := range d.sent {
+		if now.Sub(sentAt) >= d.repeatInterval {
+			delete(d.sent, k)
+		}
+	}
+}
+
+// ParseEventTypes parses a comma separated list of event types, the empty
+// string meaning all of them
+func ParseEventTypes(value string) ([]EventType, error) {
+	if strings.TrimSpace(value) == "" {
+		return AllEventTypes, nil
+	}
+
+	var events []EventType
+	for _, item := range strings.Split(value, ",") {
+		event := EventType(strings.TrimSpace(item))
+		if !slices.Contains(AllEventTypes, event) {
+			return nil, fmt.Errorf("unknown event type: %s", event)
+		}
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+func postJSON(ctx context.Context, client *http.Client, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	return nil
+}
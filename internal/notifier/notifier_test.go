@@ -0,0 +1,294 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+type receiver struct {
+	mu       sync.Mutex
+	payloads []map[string]any
+}
+
+func newReceiver(t *testing.T, status int) (*receiver, *httptest.Server) {
+	t.Helper()
+
+	r := &receiver{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, http.MethodPost, req.Method)
+		assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+
+		payload := map[string]any{}
+		assert.NoError(t, json.NewDecoder(req.Body).Decode(&payload))
+
+		r.mu.Lock()
+		r.payloads = append(r.payloads, payload)
+		r.mu.Unlock()
+
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(ts.Close)
+
+	return r, ts
+}
+
+func (r *receiver) received() []map[string]any {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.payloads
+}
+
+func newTestLogger() *log.Logger {
+	logger := log.New()
+	logger.Out = io.Discard
+	return logger
+}
+
+var resizedEvent = Event{
+	Type:          EventResized,
+	Namespace:     "default",
+	PVC:           "mypvc",
+	OldSizeBytes:  10 << 30,
+	NewSizeBytes:  12 << 30,
+	UsedBytes:     9 << 30,
+	CapacityBytes: 10 << 30,
+	Time:          time.Unix(1700000000, 0).UTC(),
+}
+
+func TestNotifiers(t *testing.T) {
+	formatter, err := NewFormatter(nil)
+	assert.NoError(t, err)
+
+	expectedMessage := "PVC default/mypvc resized from 10Gi to 12Gi (usage 9Gi, 90.0%)"
+
+	t.Run("generic webhook", func(t *testing.T) {
+		r, ts := newReceiver(t, http.StatusOK)
+
+		err := NewWebhookNotifier(ts.URL, formatter).Notify(context.TODO(), resizedEvent)
+
+		assert.NoError(t, err)
+		assert.Len(t, r.received(), 1)
+		payload := r.received()[0]
+		assert.Equal(t, "resized", payload["type"])
+		assert.Equal(t, "default", payload["namespace"])
+		assert.Equal(t, "mypvc", payload["pvc"])
+		assert.Equal(t, float64(12<<30), payload["newSizeBytes"])
+		assert.Equal(t, expectedMessage, payload["message"])
+	})
+
+	t.Run("slack", func(t *testing.T) {
+		r, ts := newReceiver(t, http.StatusOK)
+
+		err := NewSlackNotifier(ts.URL, formatter).Notify(context.TODO(), resizedEvent)
+
+		assert.NoError(t, err)
+		assert.Equal(t, []map[string]any{{"text": expectedMessage}}, r.received())
+	})
+
+	t.Run("teams", func(t *testing.T) {
+		r, ts := newReceiver(t, http.StatusOK)
+
+		err := NewTeamsNotifier(ts.URL, formatter).Notify(context.TODO(), resizedEvent)
+
+		assert.NoError(t, err)
+		assert.Len(t, r.received(), 1)
+		payload := r.received()[0]
+		assert.Equal(t, "MessageCard", payload["@type"])
+		assert.Equal(t, "pvc-autoscaler: resized", payload["title"])
+		assert.Equal(t, expectedMessage, payload["text"])
+	})
+
+	t.Run("receiver error", func(t *testing.T) {
+		_, ts := newReceiver(t, http.StatusInternalServerError)
+
+		err := NewSlackNotifier(ts.URL, formatter).Notify(context.TODO(), resizedEvent)
+
+		assert.Error(t, err)
+	})
+}
+
+type blockingNotifier struct {
+	release chan struct{}
+}
+
+func (n *blockingNotifier) Notify(ctx context.Context, event Event) error {
+	select {
+	case <-n.release:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// flakyNotifier fails the first failures notifications, it is only called
+// by the goroutine of the dispatcher
+type flakyNotifier struct {
+	failures int
+	calls    int
+}
+
+func (n *flakyNotifier) Notify(context.Context, Event) error {
+	n.calls++
+	if n.calls <= n.failures {
+		return errors.New("unavailable")
+	}
+
+	return nil
+}
+
+func TestDispatcher(t *testing.T) {
+	formatter, err := NewFormatter(nil)
+	assert.NoError(t, err)
+
+	t.Run("routes by event type", func(t *testing.T) {
+		resizes, resizesServer := newReceiver(t, http.StatusOK)
+		ceilings, ceilingsServer := newReceiver(t, http.StatusOK)
+
+		d := NewDispatcher(time.Hour, newTestLogger())
+		d.Register(NewSlackNotifier(resizesServer.URL, formatter), []EventType{EventResized})
+		d.Register(NewSlackNotifier(ceilingsServer.URL, formatter), []EventType{EventCeilingReached})
+
+		d.Dispatch(resizedEvent)
+		d.Close(context.TODO())
+
+		assert.Len(t, resizes.received(), 1)
+		assert.Len(t, ceilings.received(), 0)
+	})
+
+	t.Run("repeated events are suppressed", func(t *testing.T) {
+		r, ts := newReceiver(t, http.StatusOK)
+
+		d := NewDispatcher(time.Hour, newTestLogger())
+		d.Register(NewSlackNotifier(ts.URL, formatter), AllEventTypes)
+
+		ceilingEvent := Event{Type: EventCeilingReached, Namespace: "default", PVC: "mypvc", NewSizeBytes: 20 << 30}
+
+		ceilingEvent.Time = time.Unix(1000, 0)
+		d.Dispatch(ceilingEvent)
+		ceilingEvent.Time = time.Unix(1000, 0).Add(30 * time.Minute)
+		d.Dispatch(ceilingEvent)
+		ceilingEvent.Time = time.Unix(1000, 0).Add(2 * time.Hour)
+		d.Dispatch(ceilingEvent)
+		d.Close(context.TODO())
+
+		assert.Len(t, r.received(), 2)
+	})
+
+	t.Run("failed notifications are not suppressed", func(t *testing.T) {
+		r, ts := newReceiver(t, http.StatusInternalServerError)
+
+		d := NewDispatcher(time.Hour, newTestLogger())
+		d.Register(NewSlackNotifier(ts.URL, formatter), AllEventTypes)
+
+		d.Dispatch(resizedEvent)
+		d.Dispatch(resizedEvent)
+		d.Close(context.TODO())
+
+		assert.Len(t, r.received(), 2)
+	})
+
+	t.Run("only the failed notifiers are retried", func(t *testing.T) {
+		failing, failingServer := newReceiver(t, http.StatusInternalServerError)
+		working, workingServer := newReceiver(t, http.StatusOK)
+
+		d := NewDispatcher(time.Hour, newTestLogger())
+		d.Register(NewSlackNotifier(failingServer.URL, formatter), AllEventTypes)
+		d.Register(NewWebhookNotifier(workingServer.URL, formatter), AllEventTypes)
+
+		d.Dispatch(resizedEvent)
+		d.Dispatch(resizedEvent)
+		d.Close(context.TODO())
+
+		assert.Len(t, failing.received(), 2)
+		assert.Len(t, working.received(), 1)
+	})
+
+	t.Run("a recovered notifier is not sent the event again", func(t *testing.T) {
+		flaky := &flakyNotifier{failures: 1}
+		working, workingServer := newReceiver(t, http.StatusOK)
+
+		d := NewDispatcher(time.Hour, newTestLogger())
+		d.Register(flaky, AllEventTypes)
+		d.Register(NewWebhookNotifier(workingServer.URL, formatter), AllEventTypes)
+
+		for i := 0; i < 3; i++ {
+			d.Dispatch(resizedEvent)
+		}
+		d.Close(context.TODO())
+
+		assert.Equal(t, 2, flaky.calls)
+		assert.Len(t, working.received(), 1)
+	})
+
+	t.Run("dispatch does not wait for the notifiers", func(t *testing.T) {
+		notifier := &blockingNotifier{release: make(chan struct{})}
+
+		d := NewDispatcher(time.Hour, newTestLogger())
+		d.Register(notifier, AllEventTypes)
+
+		done := make(chan struct{})
+		go func() {
+			// One in flight and a full queue, the next ones are dropped
+			for i := 0; i < queueSize+10; i++ {
+				event := resizedEvent
+				event.NewSizeBytes += int64(i)
+				d.Dispatch(event)
+			}
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Dispatch blocked on the notifier")
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		d.Close(ctx)
+	})
+}
+
+func TestFormatter(t *testing.T) {
+	t.Run("override", func(t *testing.T) {
+		formatter, err := NewFormatter(map[EventType]string{
+			EventInvalidConfig: "{{ .Namespace }}/{{ .PVC }}: {{ .Reason }}",
+		})
+		assert.NoError(t, err)
+
+		message, err := formatter.Format(Event{Type: EventInvalidConfig, Namespace: "ns", PVC: "data", Reason: "bad threshold"})
+
+		assert.NoError(t, err)
+		assert.Equal(t, "ns/data: bad threshold", message)
+	})
+
+	t.Run("invalid template", func(t *testing.T) {
+		_, err := NewFormatter(map[EventType]string{EventResized: "{{ .Namespace "})
+
+		assert.Error(t, err)
+	})
+}
+
+func TestParseEventTypes(t *testing.T) {
+	events, err := ParseEventTypes("")
+	assert.NoError(t, err)
+	assert.Equal(t, AllEventTypes, events)
+
+	events, err = ParseEventTypes("resized, ceiling_reached")
+	assert.NoError(t, err)
+	assert.Equal(t, []EventType{EventResized, EventCeilingReached}, events)
+
+	_, err = ParseEventTypes("resized,exploded")
+	assert.Error(t, err)
+}
@@ -0,0 +1,40 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// SlackNotifier posts to a Slack-compatible incoming webhook
+type SlackNotifier struct {
+	url        string
+	formatter  *Formatter
+	httpClient *http.Client
+}
+
+type slackPayload struct {
+	Text string `json:"text"`
+}
+
+func NewSlackNotifier(url string, formatter *Formatter) *SlackNotifier {
+	return &SlackNotifier{
+		url:        url,
+		formatter:  formatter,
+		httpClient: &http.Client{Timeout: DefaultHTTPTimeout},
+	}
+}
+
+func (n *SlackNotifier) Notify(ctx context.Context, event Event) error {
+	message, err := n.formatter.Format(event)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(slackPayload{Text: message})
+	if err != nil {
+		return err
+	}
+
+	return postJSON(ctx, n.httpClient, n.url, body)
+}
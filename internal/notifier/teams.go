@@ -0,0 +1,60 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+var teamsThemeColors = map[EventType]string{
+	EventResized:        "2EB886",
+	EventCeilingReached: "D00000",
+	EventResizeStalled:  "DAA038",
+	EventInvalidConfig:  "DAA038",
+}
+
+// TeamsNotifier posts a MessageCard to a Microsoft Teams incoming webhook
+type TeamsNotifier struct {
+	url        string
+	formatter  *Formatter
+	httpClient *http.Client
+}
+
+type teamsPayload struct {
+	Type       string `json:"@type"`
+	Context    string `json:"@context"`
+	ThemeColor string `json:"themeColor"`
+	Summary    string `json:"summary"`
+	Title      string `json:"title"`
+	Text       string `json:"text"`
+}
+
+func NewTeamsNotifier(url string, formatter *Formatter) *TeamsNotifier {
+	return &TeamsNotifier{
+		url:        url,
+		formatter:  formatter,
+		httpClient: &http.Client{Timeout: DefaultHTTPTimeout},
+	}
+}
+
+func (n *TeamsNotifier) Notify(ctx context.Context, event Event) error {
+	message, err := n.formatter.Format(event)
+	if err != nil {
+		return err
+	}
+
+	title := "pvc-autoscaler: " + string(event.Type)
+	body, err := json.Marshal(teamsPayload{
+		Type:       "MessageCard",
+		Context:    "https://schema.org/extensions",
+		ThemeColor: teamsThemeColors[event.Type],
+		Summary:    title,
+		Title:      title,
+		Text:       message,
+	})
+	if err != nil {
+		return err
+	}
+
+	return postJSON(ctx, n.httpClient, n.url, body)
+}
@@ -0,0 +1,68 @@
+package notifier
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+var DefaultTemplates = map[EventType]string{
+	EventResized:        `PVC {{ .Namespace }}/{{ .PVC }} resized from {{ bytes .OldSizeBytes }} to {{ bytes .NewSizeBytes }} (usage {{ bytes .UsedBytes }}, {{ usage . }}){{ with .Tier }} at tier {{ . }}{{ end }}`,
+	EventCeilingReached: `PVC {{ .Namespace }}/{{ .PVC }} reached its ceiling of {{ bytes .NewSizeBytes }} and cannot grow anymore (usage {{ bytes .UsedBytes }}, {{ usage . }})`,
+	EventResizeStalled:  `PVC {{ .Namespace }}/{{ .PVC }} is stuck resizing from {{ bytes .OldSizeBytes }} to {{ bytes .NewSizeBytes }}{{ with .Reason }}: {{ . }}{{ end }}`,
+	EventInvalidConfig:  `PVC {{ .Namespace }}/{{ .PVC }} has an invalid autoscaling configuration: {{ .Reason }}`,
+}
+
+var templateFuncs = template.FuncMap{
+	"bytes": func(b int64) string {
+		return resource.NewQuantity(b, resource.BinarySI).String()
+	},
+	"usage": func(e Event) string {
+		if e.CapacityBytes == 0 {
+			return "n/a"
+		}
+		return fmt.Sprintf("%.1f%%", float64(e.UsedBytes)*100/float64(e.CapacityBytes))
+	},
+}
+
+// Formatter renders the human readable message of an event
+type Formatter struct {
+	templates map[EventType]*template.Template
+}
+
+// NewFormatter returns a Formatter using the default templates, except for
+// the event types in overrides
+func NewFormatter(overrides map[EventType]string) (*Formatter, error) {
+	f := &Formatter{templates: make(map[EventType]*template.Template)}
+
+	for _, eventType := range AllEventTypes {
+		text := DefaultTemplates[eventType]
+		if override, ok := overrides[eventType]; ok && override != "" {
+			text = override
+		}
+
+		tmpl, err := template.New(string(eventType)).Funcs(templateFuncs).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template for %s: %w", eventType, err)
+		}
+		f.templates[eventType] = tmpl
+	}
+
+	return f, nil
+}
+
+func (f *Formatter) Format(event Event) (string, error) {
+	tmpl, ok := f.templates[event.Type]
+	if !ok {
+		return "", fmt.Errorf("no template for event type %s", event.Type)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, event); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
@@ -0,0 +1,41 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// WebhookNotifier posts the event as a generic JSON document
+type WebhookNotifier struct {
+	url        string
+	formatter  *Formatter
+	httpClient *http.Client
+}
+
+type webhookPayload struct {
+	Event
+	Message string `json:"message"`
+}
+
+func NewWebhookNotifier(url string, formatter *Formatter) *WebhookNotifier {
+	return &WebhookNotifier{
+		url:        url,
+		formatter:  formatter,
+		httpClient: &http.Client{Timeout: DefaultHTTPTimeout},
+	}
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, event Event) error {
+	message, err := n.formatter.Format(event)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(webhookPayload{Event: event, Message: message})
+	if err != nil {
+		return err
+	}
+
+	return postJSON(ctx, n.httpClient, n.url, body)
+}
@@ -0,0 +1,313 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	AnnotationPrefix    = "pvc-autoscaler.lorenzophys.io/"
+	EnabledAnnotation   = AnnotationPrefix + "enabled"
+	ThresholdAnnotation = AnnotationPrefix + "threshold"
+	CeilingAnnotation   = AnnotationPrefix + "ceiling"
+	IncreaseAnnotation  = AnnotationPrefix + "increase"
+	// TiersAnnotation replaces the threshold and the increase with a list of
+	// threshold:increase rules, e.g. "75%:10%,90%:30%"
+	TiersAnnotation = AnnotationPrefix + "tiers"
+	// AttributesClassesAnnotation maps size bands to VolumeAttributesClasses,
+	// e.g. "0:gp3-standard,1Ti:gp3-fast"
+	AttributesClassesAnnotation = AnnotationPrefix + "attributes-classes"
+	// ResizeWindowAnnotation restricts the resizes to maintenance
+	// windows, unless the usage reaches EmergencyThresholdAnnotation
+	ResizeWindowAnnotation       = AnnotationPrefix + "resize-window"
+	EmergencyThresholdAnnotation = AnnotationPrefix + "emergency-threshold"
+	// OfflineExpansionAnnotation opts in to the restart of the pods of a PVC
+	// whose driver can only expand its filesystem while it is not mounted,
+	// within the windows of OfflineExpansionWindowAnnotation if set.
+	OfflineExpansionAnnotation       = AnnotationPrefix + "offline-expansion"
+	OfflineExpansionWindowAnnotation = AnnotationPrefix + "offline-expansion-window"
+	// OfflineExpansionRestartAnnotation records when the pods were evicted
+	OfflineExpansionRestartAnnotation = AnnotationPrefix + "offline-expansion-restart"
+	PreviousCapacityAnnotation        = AnnotationPrefix + "previous_capacity"
+	SnapshotAnnotation                = AnnotationPrefix + "snapshot-before-resize"
+	LastResizeAnnotation              = AnnotationPrefix + "last_resize"
+	// OriginalSizeAnnotation records the requested size of the PVC when the
+	// autoscaler first saw it, relative ceilings like "4x" are based on it
+	OriginalSizeAnnotation = AnnotationPrefix + "original-size"
+	// ResizeRequestAnnotation asks for a one-off resize, to an absolute size
+	// like "200Gi" or by a relative amount like "+50Gi". It is removed once
+	// handled and the outcome is written in ResizeRequestResultAnnotation.
+	ResizeRequestAnnotation       = AnnotationPrefix + "resize-request"
+	ResizeRequestResultAnnotation = AnnotationPrefix + "resize-request-result"
+)
+
+// The annotations a StorageClass can set as defaults for its PVCs
+var StorageClassAnnotations = []string{
+	EnabledAnnotation,
+	ThresholdAnnotation,
+	CeilingAnnotation,
+	IncreaseAnnotation,
+	TiersAnnotation,
+	AttributesClassesAnnotation,
+	OfflineExpansionAnnotation,
+	OfflineExpansionWindowAnnotation,
+	ResizeWindowAnnotation,
+	EmergencyThresholdAnnotation,
+}
+
+var ErrInvalidCeiling = errors.New("invalid storage ceiling in the annotation")
+
+// ErrOriginalSizeNotRecorded is returned for a relative ceiling until the
+// controller recorded the original size of the PVC
+var ErrOriginalSizeNotRecorded = errors.New("original size not recorded yet")
+
+// Enabled reports whether the PVC is managed by the autoscaler. An explicit
+// annotation on the PVC always wins over its StorageClass, sc may be nil.
+func Enabled(pvc *corev1.PersistentVolumeClaim, sc *storagev1.StorageClass) bool {
+	if value, ok := pvc.Annotations[EnabledAnnotation]; ok {
+		return value == "true"
+	}
+
+	return sc != nil && sc.Annotations[EnabledAnnotation] == "true"
+}
+
+// EffectiveAnnotations merges the policy annotations of the StorageClass
+// with the ones of the PVC, the latter taking precedence
+func EffectiveAnnotations(sc *storagev1.StorageClass, pvc *corev1.PersistentVolumeClaim) map[string]string {
+	annotations := make(map[string]string)
+
+	for _, key := range StorageClassAnnotations {
+		if sc != nil {
+			if value, ok := sc.Annotations[key]; ok {
+				annotations[key] = value
+			}
+		}
+		if value, ok := pvc.Annotations[key]; ok {
+			annotations[key] = value
+		}
+	}
+
+	return annotations
+}
+
+// StorageCeiling returns the ceiling of the PVC: the ceiling annotation,
+// either a quantity or a multiple of the original size like "4x", else the
+// storage limit of the PVC, else defaultFactor times the original size. It
+// is zero when none is set and defaultFactor is zero.
+func StorageCeiling(pvc *corev1.PersistentVolumeClaim, annotations map[string]string, defaultFactor float64) (resource.Quantity, error) {
+	if annotation, ok := annotations[CeilingAnnotation]; ok && annotation != "" {
+		if factor, ok := strings.CutSuffix(annotation, "x"); ok {
+			parsed, err := ParseCeilingFactor(factor)
+			if err != nil {
+				return resource.Quantity{}, err
+			}
+			return originalSizeTimes(pvc, parsed)
+		}
+		return resource.ParseQuantity(annotation)
+	}
+
+	if limit := pvc.Spec.Resources.Limits.Storage(); !limit.IsZero() || defaultFactor == 0 {
+		return *limit, nil
+	}
+
+	return originalSizeTimes(pvc, defaultFactor)
+}
+
+// ParseCeilingFactor parses the factor of a relative ceiling, e.g. "4" or
+// "2.5", it must be at least 1
+func ParseCeilingFactor(value string) (float64, error) {
+	factor, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ceiling factor %q: %w", value, err)
+	}
+	if math.IsNaN(factor) || math.IsInf(factor, 0) || factor < 1 {
+		return 0, fmt.Errorf("the ceiling factor %s should be at least 1", value)
+	}
+
+	return factor, nil
+}
+
+// OriginalSize returns the size recorded in the original size annotation.
+// The current requested size is never used instead: a relative ceiling
+// would grow with every resize.
+func OriginalSize(pvc *corev1.PersistentVolumeClaim) (resource.Quantity, error) {
+	if annotation, ok := pvc.Annotations[OriginalSizeAnnotation]; ok {
+		size, err := resource.ParseQuantity(annotation)
+		if err != nil {
+			return resource.Quantity{}, fmt.Errorf("invalid original size %q: %w", annotation, err)
+		}
+		return size, nil
+	}
+
+	return resource.Quantity{}, ErrOriginalSizeNotRecorded
+}
+
+func originalSizeTimes(pvc *corev1.PersistentVolumeClaim, factor float64) (resource.Quantity, error) {
+	original, err := OriginalSize(pvc)
+	if err != nil {
+		return resource.Quantity{}, err
+	}
+
+	return *resource.NewQuantity(int64(math.Ceil(float64(original.Value())*factor)), resource.BinarySI), nil
+}
+
+// ParsePercentage parses values like "80%" or "12.5%"
+func ParsePercentage(value string) (float64, error) {
+	if !strings.HasSuffix(value, "%") {
+		return 0, errors.New("annotation value should be a percentage")
+	}
+
+	perc, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(perc) || perc < 0 || perc > 100 {
+		return 0, fmt.Errorf("annotation value %s should between 0%% and 100%%", value)
+	}
+
+	return perc, nil
+}
+
+func PercentageToBytes(value string, capacity int64, defaultValue string) (int64, error) {
+	if len(value) == 0 {
+		value = defaultValue
+	}
+
+	perc, err := ParsePercentage(value)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(float64(capacity) * perc / 100.0), nil
+}
+
+func isResizable(pvc *corev1.PersistentVolumeClaim, annotations map[string]string, defaultCeilingFactor float64) error {
+	// Ceiling
+	quantity, err := StorageCeiling(pvc, annotations, defaultCeilingFactor)
+	if errors.Is(err, ErrOriginalSizeNotRecorded) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidCeiling, err)
+	}
+	if quantity.IsZero() {
+		return errors.New("the storage ceiling is zero, set the ceiling annotation, a storage limit or a default ceiling factor")
+	}
+
+	// Specs
+	if pvc.Spec.VolumeMode != nil && *pvc.Spec.VolumeMode != corev1.PersistentVolumeFilesystem {
+		return errors.New("the associated volume must be formatted with a filesystem")
+	}
+	if pvc.Status.Phase != corev1.ClaimBound {
+		return errors.New("not bound to any pod")
+	}
+
+	return nil
+}
+
+// ParseResizeRequest returns the size asked by a resize request, a relative
+// request is added to capacity
+func ParseResizeRequest(value string, capacity resource.Quantity) (resource.Quantity, error) {
+	relative := strings.HasPrefix(value, "+")
+	size, err := resource.ParseQuantity(strings.TrimPrefix(value, "+"))
+	if err != nil {
+		return resource.Quantity{}, err
+	}
+	if size.Sign() <= 0 {
+		return resource.Quantity{}, errors.New("the size should be positive")
+	}
+
+	if relative {
+		size.Add(capacity)
+	}
+	return size, nil
+}
+
+// Tier is a rule of the tiers annotation: the volume grows by Increase once
+// the usage reaches Threshold. A NoCooldown tier is not deferred by the
+// waits the autoscaler imposes itself, the resize windows and the freeze,
+// the cooldown of the provider still applies.
+type Tier struct {
+	Threshold  string
+	Increase   string
+	NoCooldown bool
+}
+
+// noCooldownFlag marks a tier bypassing the waits of the autoscaler
+const noCooldownFlag = "nocooldown"
+
+func (t Tier) String() string {
+	if t.NoCooldown {
+		return t.Threshold + ":" + t.Increase + ":" + noCooldownFlag
+	}
+	return t.Threshold + ":" + t.Increase
+}
+
+// ParseTiers parses a comma separated list of threshold:increase rules, the
+// thresholds must be increasing. A rule may end with ":nocooldown", e.g.
+// 97%:50%:nocooldown.
+func ParseTiers(value string) ([]Tier, error) {
+	var (
+		tiers    []Tier
+		previous = -1.0
+	)
+	for _, item := range strings.Split(value, ",") {
+		fields := strings.Split(strings.TrimSpace(item), ":")
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("tier %q should be threshold:increase, e.g. 90%%:30%%, optionally followed by :%s", item, noCooldownFlag)
+		}
+		tier := Tier{Threshold: strings.TrimSpace(fields[0]), Increase: strings.TrimSpace(fields[1])}
+		if len(fields) == 3 {
+			if flag := strings.TrimSpace(fields[2]); flag != noCooldownFlag {
+				return nil, fmt.Errorf("unknown flag %q in tier %q, only %s is supported", flag, item, noCooldownFlag)
+			}
+			tier.NoCooldown = true
+		}
+
+		perc, err := ParsePercentage(tier.Threshold)
+		if err != nil {
+			return nil, fmt.Errorf("invalid threshold in tier %q: %w", item, err)
+		}
+		if perc <= previous {
+			return nil, fmt.Errorf("the thresholds of the tiers should be increasing, %s is not above the previous one", tier.Threshold)
+		}
+		previous = perc
+		if _, err := ParsePercentage(tier.Increase); err != nil {
+			return nil, fmt.Errorf("invalid increase in tier %q: %w", item, err)
+		}
+
+		tiers = append(tiers, tier)
+	}
+
+	return tiers, nil
+}
+
+// effectiveTiers returns the tiers of the most specific policy source, the
+// PVC, its StorageClass or the defaults. A source setting a threshold or an
+// increase but no tiers overrides the tiers of the less specific ones.
+func effectiveTiers(sc *storagev1.StorageClass, pvc *corev1.PersistentVolumeClaim, defaultTiers string) string {
+	sources := []map[string]string{pvc.Annotations}
+	if sc != nil {
+		sources = append(sources, sc.Annotations)
+	}
+
+	for _, annotations := range sources {
+		if tiers, ok := annotations[TiersAnnotation]; ok {
+			return tiers
+		}
+		_, hasThreshold := annotations[ThresholdAnnotation]
+		_, hasIncrease := annotations[IncreaseAnnotation]
+		if hasThreshold || hasIncrease {
+			return ""
+		}
+	}
+
+	return defaultTiers
+}
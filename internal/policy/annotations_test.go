@@ -0,0 +1,224 @@
+package policy
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEffectiveAnnotations(t *testing.T) {
+	sc := &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+		EnabledAnnotation:   "true",
+		ThresholdAnnotation: "70%",
+		CeilingAnnotation:   "100Gi",
+		"other.io/key":      "ignored",
+	}}}
+
+	tests := []struct {
+		name        string
+		sc          *storagev1.StorageClass
+		annotations map[string]string
+		expected    map[string]string
+	}{
+		{
+			name:     "storageclass only, pvc without annotations",
+			sc:       sc,
+			expected: map[string]string{EnabledAnnotation: "true", ThresholdAnnotation: "70%", CeilingAnnotation: "100Gi"},
+		},
+		{
+			name:        "the pvc wins",
+			sc:          sc,
+			annotations: map[string]string{ThresholdAnnotation: "90%", IncreaseAnnotation: "50%", "other.io/key": "ignored"},
+			expected:    map[string]string{EnabledAnnotation: "true", ThresholdAnnotation: "90%", IncreaseAnnotation: "50%", CeilingAnnotation: "100Gi"},
+		},
+		{
+			name:        "no storageclass",
+			annotations: map[string]string{CeilingAnnotation: "20Gi"},
+			expected:    map[string]string{CeilingAnnotation: "20Gi"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Annotations: tt.annotations}}
+
+			assert.Equal(t, tt.expected, EffectiveAnnotations(tt.sc, pvc))
+		})
+	}
+}
+
+func TestParsePercentage(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected float64
+		withErr  bool
+	}{
+		{value: "80%", expected: 80},
+		{value: "12.5%", expected: 12.5},
+		{value: "0%", expected: 0},
+		{value: "100%", expected: 100},
+		{value: "80", withErr: true},
+		{value: "%", withErr: true},
+		{value: "-1%", withErr: true},
+		{value: "100.1%", withErr: true},
+		{value: "NaN%", withErr: true},
+		{value: "Inf%", withErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			perc, err := ParsePercentage(tt.value)
+
+			if tt.withErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, perc)
+		})
+	}
+}
+
+func TestPercentageToBytes(t *testing.T) {
+	bytes, err := PercentageToBytes("", 1000, "80%")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(800), bytes)
+
+	bytes, err = PercentageToBytes("25%", 1000, "80%")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(250), bytes)
+
+	_, err = PercentageToBytes("", 1000, "80")
+	assert.Error(t, err)
+}
+
+func TestStorageCeiling(t *testing.T) {
+	tests := []struct {
+		name          string
+		annotations   map[string]string
+		ceiling       string
+		limit         string
+		defaultFactor float64
+		expected      string
+		withErr       bool
+	}{
+		{name: "absolute", ceiling: "20Gi", expected: "20Gi"},
+		{name: "relative", annotations: map[string]string{OriginalSizeAnnotation: "10Gi"}, ceiling: "4x", expected: "40Gi"},
+		{name: "relative to the recorded original size", annotations: map[string]string{OriginalSizeAnnotation: "5Gi"}, ceiling: "2.5x", expected: "12.5Gi"},
+		{name: "annotation wins over the limit", annotations: map[string]string{OriginalSizeAnnotation: "10Gi"}, ceiling: "4x", limit: "15Gi", expected: "40Gi"},
+		{name: "limit", limit: "15Gi", defaultFactor: 4, expected: "15Gi"},
+		{name: "default factor", annotations: map[string]string{OriginalSizeAnnotation: "10Gi"}, defaultFactor: 3, expected: "30Gi"},
+		{name: "original size not recorded", ceiling: "4x", withErr: true},
+		{name: "original size not recorded with the default factor", defaultFactor: 3, withErr: true},
+		{name: "not set", expected: "0"},
+		{name: "factor below 1", ceiling: "0.5x", withErr: true},
+		{name: "invalid factor", ceiling: "fourx", withErr: true},
+		{name: "invalid original size", annotations: map[string]string{OriginalSizeAnnotation: "big"}, ceiling: "4x", withErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pvc := &corev1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Annotations: tt.annotations},
+				Spec: corev1.PersistentVolumeClaimSpec{
+					Resources: corev1.VolumeResourceRequirements{
+						Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")},
+					},
+				},
+			}
+			if tt.limit != "" {
+				pvc.Spec.Resources.Limits = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse(tt.limit)}
+			}
+			annotations := map[string]string{}
+			if tt.ceiling != "" {
+				annotations[CeilingAnnotation] = tt.ceiling
+			}
+
+			ceiling, err := StorageCeiling(pvc, annotations, tt.defaultFactor)
+
+			if tt.withErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			expected := resource.MustParse(tt.expected)
+			assert.Equal(t, expected.Value(), ceiling.Value())
+		})
+	}
+}
+
+func FuzzParsePercentage(f *testing.F) {
+	for _, seed := range []string{"80%", "12.5%", "0%", "100%", "1e2%", "0x10%", "NaN%", "-0%", "80", ""} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, value string) {
+		perc, err := ParsePercentage(value)
+		if err != nil {
+			return
+		}
+		if math.IsNaN(perc) || perc < 0 || perc > 100 {
+			t.Errorf("ParsePercentage(%q) = %v, want a value between 0 and 100", value, perc)
+		}
+	})
+}
+
+// FuzzEvaluateAnnotations checks that no annotation value can make the
+// evaluation panic or resize a PVC outside of [capacity, ceiling]
+func FuzzEvaluateAnnotations(f *testing.F) {
+	f.Add("80%", "20%", "20Gi", "")
+	f.Add("0%", "100%", "1Ei", "10737418240")
+	f.Add("50.5%", "0%", "10.5Gi", "0")
+	f.Add("80", "-20%", "-1", "abc")
+	f.Add("", "", "", "")
+
+	f.Fuzz(func(t *testing.T, threshold, increase, ceiling, previousCapacity string) {
+		pvc := newTestPVC()
+		pvc.Annotations = map[string]string{
+			ThresholdAnnotation: threshold,
+			IncreaseAnnotation:  increase,
+			CeilingAnnotation:   ceiling,
+		}
+		if previousCapacity != "" {
+			pvc.Annotations[PreviousCapacityAnnotation] = previousCapacity
+		}
+
+		result := Evaluate(pvc, newTestStorageClass(), newTestMetrics(9.5), testConfig)
+
+		if result.Decision == nil {
+			t.Fatal("no decision")
+		}
+		resize, ok := result.Decision.(Resize)
+		if !ok {
+			return
+		}
+		if resize.NewSize.Value() < result.CurrentSizeBytes {
+			t.Errorf("new size %s is smaller than the capacity", resize.NewSize.String())
+		}
+		if resize.NewSize.Value() > result.CeilingBytes {
+			t.Errorf("new size %s is bigger than the ceiling %d", resize.NewSize.String(), result.CeilingBytes)
+		}
+	})
+}
+
+func TestParseTiers(t *testing.T) {
+	tiers, err := ParseTiers("75%:10%, 90%:30%,97%:50%: nocooldown")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []Tier{{"75%", "10%", false}, {"90%", "30%", false}, {"97%", "50%", true}}, tiers)
+	assert.Equal(t, "90%:30%", tiers[1].String())
+	assert.Equal(t, "97%:50%:nocooldown", tiers[2].String())
+
+	for _, value := range []string{"", "75%", "75%:10%,", "75:10%", "75%:10", "90%:30%,75%:10%", "75%:10%,75%:20%", "97%:50%:now", "97%:50%:nocooldown:x"} {
+		t.Run(value, func(t *testing.T) {
+			_, err := ParseTiers(value)
+
+			assert.Error(t, err)
+		})
+	}
+}
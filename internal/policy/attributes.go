@@ -0,0 +1,81 @@
+package policy
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// AttributesClassBand switches the PVC to the VolumeAttributesClass Class
+// once its size reaches MinSize
+type AttributesClassBand struct {
+	MinSize resource.Quantity
+	Class   string
+}
+
+func (b AttributesClassBand) String() string {
+	return b.MinSize.String() + ":" + b.Class
+}
+
+// ParseAttributesClassBands parses a comma separated list of size:class
+// bands, e.g. "0:gp3-standard,1Ti:gp3-fast". The sizes must be increasing.
+func ParseAttributesClassBands(value string) ([]AttributesClassBand, error) {
+	var bands []AttributesClassBand
+	for _, item := range strings.Split(value, ",") {
+		size, class, ok := strings.Cut(strings.TrimSpace(item), ":")
+		if !ok {
+			return nil, fmt.Errorf("band %q should be size:class, e.g. 1Ti:gp3-fast", item)
+		}
+
+		minSize, err := resource.ParseQuantity(strings.TrimSpace(size))
+		if err != nil {
+			return nil, fmt.Errorf("invalid size in band %q: %w", item, err)
+		}
+		if minSize.Sign() < 0 {
+			return nil, fmt.Errorf("the size of band %q should not be negative", item)
+		}
+		if len(bands) > 0 && minSize.Cmp(bands[len(bands)-1].MinSize) <= 0 {
+			return nil, fmt.Errorf("the sizes of the bands should be increasing, %s is not above the previous one", minSize.String())
+		}
+
+		class = strings.TrimSpace(class)
+		if errs := validation.IsDNS1123Subdomain(class); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid class in band %q: %s", item, strings.Join(errs, ", "))
+		}
+
+		bands = append(bands, AttributesClassBand{MinSize: minSize, Class: class})
+	}
+
+	return bands, nil
+}
+
+// bandOf returns the index of the band of size, -1 if size is below all of
+// them
+func bandOf(bands []AttributesClassBand, size resource.Quantity) int {
+	band := -1
+	for i, b := range bands {
+		if size.Cmp(b.MinSize) >= 0 {
+			band = i
+		}
+	}
+
+	return band
+}
+
+// attributesClassSwitch returns the VolumeAttributesClass to switch the PVC
+// to when a resize from capacity to newSize crosses a band, or "" if the
+// class does not change
+func attributesClassSwitch(pvc *corev1.PersistentVolumeClaim, bands []AttributesClassBand, capacity, newSize resource.Quantity) string {
+	band := bandOf(bands, newSize)
+	if band < 0 || band == bandOf(bands, capacity) {
+		return ""
+	}
+	if current := pvc.Spec.VolumeAttributesClassName; current != nil && *current == bands[band].Class {
+		return ""
+	}
+
+	return bands[band].Class
+}
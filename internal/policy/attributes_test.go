@@ -0,0 +1,75 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestParseAttributesClassBands(t *testing.T) {
+	bands, err := ParseAttributesClassBands("0:gp3-standard, 500Gi:gp3-fast,2Ti:gp3-max")
+
+	assert.NoError(t, err)
+	assert.Len(t, bands, 3)
+	assert.Equal(t, "gp3-fast", bands[1].Class)
+	assert.Equal(t, "500Gi:gp3-fast", bands[1].String())
+
+	for _, value := range []string{"", "gp3-fast", "big:gp3-fast", "-1Gi:gp3-fast", "1Ti:gp3-fast,500Gi:gp3-standard", "1Ti:Fast_Class"} {
+		t.Run(value, func(t *testing.T) {
+			_, err := ParseAttributesClassBands(value)
+
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestEvaluateAttributesClasses(t *testing.T) {
+	tests := []struct {
+		name     string
+		bands    string
+		current  string
+		expected string
+	}{
+		{name: "band crossed", bands: "0:standard,12Gi:fast", expected: "fast"},
+		{name: "same band", bands: "0:standard,20Gi:fast"},
+		{name: "below the first band", bands: "16Gi:fast"},
+		{name: "first band reached", bands: "11Gi:fast", expected: "fast"},
+		{name: "already in the class", bands: "0:standard,12Gi:fast", current: "fast"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pvc := newTestPVC()
+			pvc.Annotations[AttributesClassesAnnotation] = tt.bands
+			if tt.current != "" {
+				pvc.Spec.VolumeAttributesClassName = &tt.current
+			}
+
+			result := Evaluate(pvc, newTestStorageClass(), newTestMetrics(9), testConfig)
+
+			assert.Equal(t, ReasonThresholdExceeded, result.Reason())
+			resize := result.Decision.(Resize)
+			assert.Equal(t, tt.expected, resize.AttributesClass)
+		})
+	}
+
+	t.Run("invalid bands", func(t *testing.T) {
+		pvc := newTestPVC()
+		pvc.Annotations[AttributesClassesAnnotation] = "12Gi"
+
+		result := Evaluate(pvc, newTestStorageClass(), newTestMetrics(9), testConfig)
+
+		assert.Equal(t, ReasonInvalidAttributesClasses, result.Reason())
+		assert.Error(t, result.Err())
+	})
+}
+
+func TestAttributesClassSwitch(t *testing.T) {
+	bands, err := ParseAttributesClassBands("0:standard,1Ti:fast")
+	assert.NoError(t, err)
+
+	assert.Equal(t, "fast", attributesClassSwitch(&corev1.PersistentVolumeClaim{}, bands, resource.MustParse("900Gi"), resource.MustParse("1Ti")))
+	assert.Empty(t, attributesClassSwitch(&corev1.PersistentVolumeClaim{}, bands, resource.MustParse("1Ti"), resource.MustParse("2Ti")))
+}
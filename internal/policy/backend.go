@@ -0,0 +1,92 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// ErrInsufficientBackendCapacity is returned when the storage backend of the
+// node of a volume has no room for the smallest possible resize
+var ErrInsufficientBackendCapacity = errors.New("insufficient backend capacity")
+
+// FitBackendCapacity returns the size the volume can grow to given the
+// CSIStorageCapacities published for the topology of pv, e.g. the free space
+// of the LVM volume group of its node. newSize is returned unchanged when the
+// volume is not topology constrained or no capacity is published for it.
+// When clamp is false a size that does not fit is an error instead of being
+// lowered, sizes are lowered to a multiple of 1Gi.
+func FitBackendCapacity(pv *corev1.PersistentVolume, storageClassName string, capacities []storagev1.CSIStorageCapacity, capacity, newSize resource.Quantity, clamp bool) (resource.Quantity, error) {
+	topology := nodeTopology(pv)
+	if len(topology) == 0 {
+		return newSize, nil
+	}
+
+	var (
+		available  *resource.Quantity
+		maxVolSize *resource.Quantity
+	)
+	for _, c := range capacities {
+		if c.StorageClassName != storageClassName || c.Capacity == nil {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(c.NodeTopology)
+		if err != nil || c.NodeTopology == nil || !selector.Matches(topology) {
+			continue
+		}
+		if available == nil || c.Capacity.Cmp(*available) > 0 {
+			available = c.Capacity
+			maxVolSize = c.MaximumVolumeSize
+		}
+	}
+	if available == nil {
+		return newSize, nil
+	}
+
+	limit := capacity.DeepCopy()
+	limit.Add(*available)
+	if maxVolSize != nil && maxVolSize.Cmp(limit) < 0 {
+		limit = maxVolSize.DeepCopy()
+	}
+	if newSize.Cmp(limit) <= 0 {
+		return newSize, nil
+	}
+
+	tooLarge := fmt.Errorf("%w: growing from %s to %s needs more than the %s available", ErrInsufficientBackendCapacity, capacity.String(), newSize.String(), available.String())
+	if !clamp {
+		return resource.Quantity{}, tooLarge
+	}
+
+	// 1<<30 is a bit shift operation that represents 2^30, i.e. 1Gi
+	clampedBytes := int64(math.Floor(float64(limit.Value())/(1<<30))) << 30
+	if clampedBytes <= capacity.Value() {
+		return resource.Quantity{}, tooLarge
+	}
+
+	return *resource.NewQuantity(clampedBytes, resource.BinarySI), nil
+}
+
+// nodeTopology returns the labels a node must have to host pv, from the
+// single valued In expressions of its node affinity
+func nodeTopology(pv *corev1.PersistentVolume) labels.Set {
+	if pv == nil || pv.Spec.NodeAffinity == nil || pv.Spec.NodeAffinity.Required == nil {
+		return nil
+	}
+
+	topology := make(labels.Set)
+	for _, term := range pv.Spec.NodeAffinity.Required.NodeSelectorTerms {
+		for _, expr := range term.MatchExpressions {
+			if expr.Operator == corev1.NodeSelectorOpIn && len(expr.Values) == 1 {
+				topology[expr.Key] = expr.Values[0]
+			}
+		}
+	}
+
+	return topology
+}
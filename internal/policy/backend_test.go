@@ -0,0 +1,175 @@
+package policy
+
+import (
+	"testing"
+
+	clients "github.com/lorenzophys/pvc-autoscaler/internal/metrics_clients/clients"
+	"github.com/lorenzophys/pvc-autoscaler/internal/provider"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const topologyKey = "topology.topolvm.io/node"
+
+func newLocalPV(node string) *corev1.PersistentVolume {
+	return &corev1.PersistentVolume{
+		Spec: corev1.PersistentVolumeSpec{
+			NodeAffinity: &corev1.VolumeNodeAffinity{
+				Required: &corev1.NodeSelector{
+					NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+						MatchExpressions: []corev1.NodeSelectorRequirement{{
+							Key:      topologyKey,
+							Operator: corev1.NodeSelectorOpIn,
+							Values:   []string{node},
+						}},
+					}},
+				},
+			},
+		},
+	}
+}
+
+func newStorageCapacity(node, storageClass, available string) storagev1.CSIStorageCapacity {
+	capacity := resource.MustParse(available)
+	return storagev1.CSIStorageCapacity{
+		StorageClassName: storageClass,
+		NodeTopology:     &metav1.LabelSelector{MatchLabels: map[string]string{topologyKey: node}},
+		Capacity:         &capacity,
+	}
+}
+
+func TestFitBackendCapacity(t *testing.T) {
+	capacities := []storagev1.CSIStorageCapacity{
+		newStorageCapacity("node-a", testStorageClass, "5Gi"),
+		newStorageCapacity("node-b", testStorageClass, "500Mi"),
+		newStorageCapacity("node-b", "other", "100Gi"),
+	}
+
+	tests := []struct {
+		name     string
+		pv       *corev1.PersistentVolume
+		newSize  string
+		clamp    bool
+		expected string
+		withErr  bool
+	}{
+		{name: "fits", pv: newLocalPV("node-a"), newSize: "15Gi", clamp: true, expected: "15Gi"},
+		{name: "clamped", pv: newLocalPV("node-a"), newSize: "20Gi", clamp: true, expected: "15Gi"},
+		{name: "not clamped", pv: newLocalPV("node-a"), newSize: "20Gi", withErr: true},
+		{name: "no room", pv: newLocalPV("node-b"), newSize: "12Gi", clamp: true, withErr: true},
+		{name: "no capacity published", pv: newLocalPV("node-c"), newSize: "100Gi", clamp: true, expected: "100Gi"},
+		{name: "not topology constrained", pv: &corev1.PersistentVolume{}, newSize: "100Gi", clamp: true, expected: "100Gi"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			size, err := FitBackendCapacity(tt.pv, testStorageClass, capacities, resource.MustParse("10Gi"), resource.MustParse(tt.newSize), tt.clamp)
+
+			if tt.withErr {
+				assert.ErrorIs(t, err, ErrInsufficientBackendCapacity)
+				return
+			}
+			assert.NoError(t, err)
+			expected := resource.MustParse(tt.expected)
+			assert.Equal(t, expected.Value(), size.Value())
+		})
+	}
+
+	t.Run("maximum volume size", func(t *testing.T) {
+		capacity := newStorageCapacity("node-a", testStorageClass, "100Gi")
+		maxSize := resource.MustParse("12Gi")
+		capacity.MaximumVolumeSize = &maxSize
+
+		size, err := FitBackendCapacity(newLocalPV("node-a"), testStorageClass, []storagev1.CSIStorageCapacity{capacity}, resource.MustParse("10Gi"), resource.MustParse("20Gi"), true)
+
+		assert.NoError(t, err)
+		assert.Equal(t, int64(12<<30), size.Value())
+	})
+}
+
+func TestEvaluateBackendCapacity(t *testing.T) {
+	backend := func(available string) func() (*corev1.PersistentVolume, []storagev1.CSIStorageCapacity) {
+		return func() (*corev1.PersistentVolume, []storagev1.CSIStorageCapacity) {
+			return newLocalPV("node-a"), []storagev1.CSIStorageCapacity{newStorageCapacity("node-a", testStorageClass, available)}
+		}
+	}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		cfg         func(cfg *Config)
+		available   string
+		expected    Reason
+		newSize     string
+		limited     bool
+		class       string
+	}{
+		{name: "fits", available: "10Gi", expected: ReasonThresholdExceeded, newSize: "15Gi"},
+		// The filesystem is smaller than the volume, the volume size counts
+		{name: "lowered from the size of the volume", available: "4.2Gi", expected: ReasonThresholdExceeded, newSize: "14Gi", limited: true},
+		{name: "no room", available: "500Mi", expected: ReasonInsufficientBackendCapacity},
+		{
+			name: "lowered below the minimum step",
+			cfg: func(cfg *Config) {
+				cfg.ProviderLimits = provider.Table{"csi.example.com": {MinStep: resource.MustParse("4Gi")}}
+			},
+			available: "2Gi",
+			expected:  ReasonInsufficientBackendCapacity,
+		},
+		{
+			name:        "attributes class of the lowered size",
+			annotations: map[string]string{AttributesClassesAnnotation: "0:standard,11.5Gi:fast,14Gi:max"},
+			available:   "2Gi",
+			expected:    ReasonThresholdExceeded,
+			newSize:     "12Gi",
+			limited:     true,
+			class:       "fast",
+		},
+		{name: "resize request not lowered", annotations: map[string]string{ResizeRequestAnnotation: "15Gi"}, available: "2Gi", expected: ReasonInsufficientBackendCapacity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig
+			cfg.DefaultIncrease = "50%"
+			cfg.Backend = backend(tt.available)
+			if tt.cfg != nil {
+				tt.cfg(&cfg)
+			}
+			pvc := newTestPVC()
+			for key, value := range tt.annotations {
+				pvc.Annotations[key] = value
+			}
+			sc := newTestStorageClass()
+			sc.Provisioner = "csi.example.com"
+			metrics := &clients.PVCMetrics{VolumeUsedBytes: 9 << 30, VolumeCapacityBytes: 19 << 29}
+
+			result := Evaluate(pvc, sc, metrics, cfg)
+
+			assert.Equal(t, tt.expected, result.Reason())
+			if tt.expected == ReasonInsufficientBackendCapacity {
+				assert.ErrorIs(t, result.Err(), ErrInsufficientBackendCapacity)
+				return
+			}
+			resize := result.Decision.(Resize)
+			expected := resource.MustParse(tt.newSize)
+			assert.Equal(t, expected.Value(), resize.NewSize.Value())
+			assert.Equal(t, tt.limited, resize.BackendLimited)
+			assert.Equal(t, tt.class, resize.AttributesClass)
+		})
+	}
+
+	t.Run("volume not found", func(t *testing.T) {
+		cfg := testConfig
+		cfg.Backend = func() (*corev1.PersistentVolume, []storagev1.CSIStorageCapacity) { return nil, nil }
+
+		result := Evaluate(newTestPVC(), newTestStorageClass(), newTestMetrics(9), cfg)
+
+		assert.Equal(t, ReasonThresholdExceeded, result.Reason())
+		resize := result.Decision.(Resize)
+		assert.Equal(t, int64(12<<30), resize.NewSize.Value())
+	})
+}
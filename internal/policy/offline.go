@@ -0,0 +1,64 @@
+package policy
+
+import (
+	"fmt"
+
+	"github.com/lorenzophys/pvc-autoscaler/internal/maintenance"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+)
+
+// OfflineExpansion reports whether the pods of the PVC may be restarted to
+// complete the expansion of its filesystem, and the windows allowing it. The
+// window annotation of the PVC or its StorageClass overrides defaultWindow,
+// no window allows restarts at any time.
+func OfflineExpansion(pvc *corev1.PersistentVolumeClaim, sc *storagev1.StorageClass, defaultWindow string) (bool, maintenance.Windows, error) {
+	annotations := EffectiveAnnotations(sc, pvc)
+	if annotations[OfflineExpansionAnnotation] != "true" {
+		return false, nil, nil
+	}
+
+	window, ok := annotations[OfflineExpansionWindowAnnotation]
+	if !ok {
+		window = defaultWindow
+	}
+	windows, err := maintenance.ParseWindows(window)
+	if err != nil {
+		return true, nil, fmt.Errorf("invalid offline expansion window: %w", err)
+	}
+
+	return true, windows, nil
+}
+
+// FileSystemResizePending reports whether the volume of the PVC was expanded
+// and waits for its filesystem to be expanded on the next mount
+func FileSystemResizePending(pvc *corev1.PersistentVolumeClaim) bool {
+	for _, condition := range pvc.Status.Conditions {
+		if condition.Type == corev1.PersistentVolumeClaimFileSystemResizePending {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+
+	return false
+}
+
+// ConsumingPods returns the running pods mounting the PVC
+func ConsumingPods(pvc *corev1.PersistentVolumeClaim, pods []corev1.Pod) []corev1.Pod {
+	var consuming []corev1.Pod
+	for _, pod := range pods {
+		if pod.Namespace != pvc.Namespace || pod.DeletionTimestamp != nil {
+			continue
+		}
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		for _, volume := range pod.Spec.Volumes {
+			if volume.PersistentVolumeClaim != nil && volume.PersistentVolumeClaim.ClaimName == pvc.Name {
+				consuming = append(consuming, pod)
+				break
+			}
+		}
+	}
+
+	return consuming
+}
@@ -0,0 +1,104 @@
+package policy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestOfflineExpansion(t *testing.T) {
+	tests := []struct {
+		name          string
+		sc            map[string]string
+		pvc           map[string]string
+		defaultWindow string
+		enabled       bool
+		windows       string
+		err           bool
+	}{
+		{name: "disabled"},
+		{name: "enabled by the storageclass", sc: map[string]string{OfflineExpansionAnnotation: "true"}, enabled: true},
+		{name: "disabled by the pvc", sc: map[string]string{OfflineExpansionAnnotation: "true"}, pvc: map[string]string{OfflineExpansionAnnotation: "false"}},
+		{name: "default window", pvc: map[string]string{OfflineExpansionAnnotation: "true"}, defaultWindow: "0 2 * * * 4h", enabled: true, windows: "0 2 * * * 4h"},
+		{
+			name:          "window of the pvc",
+			sc:            map[string]string{OfflineExpansionAnnotation: "true", OfflineExpansionWindowAnnotation: "0 3 * * * 1h"},
+			pvc:           map[string]string{OfflineExpansionWindowAnnotation: "0 1 * * sat 2h; 0 1 * * sun 2h"},
+			defaultWindow: "0 2 * * * 4h",
+			enabled:       true,
+			windows:       "0 1 * * sat 2h; 0 1 * * sun 2h",
+		},
+		{name: "empty window overrides the default", pvc: map[string]string{OfflineExpansionAnnotation: "true", OfflineExpansionWindowAnnotation: ""}, defaultWindow: "0 2 * * * 4h", enabled: true},
+		{name: "invalid window", pvc: map[string]string{OfflineExpansionAnnotation: "true", OfflineExpansionWindowAnnotation: "at night"}, enabled: true, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc := newTestStorageClass()
+			sc.Annotations = tt.sc
+			pvc := newTestPVC()
+			for key, value := range tt.pvc {
+				pvc.Annotations[key] = value
+			}
+
+			enabled, windows, err := OfflineExpansion(pvc, sc, tt.defaultWindow)
+
+			assert.Equal(t, tt.enabled, enabled)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.windows, windows.String())
+		})
+	}
+}
+
+func TestFileSystemResizePending(t *testing.T) {
+	pvc := newTestPVC()
+	assert.False(t, FileSystemResizePending(pvc))
+
+	pvc.Status.Conditions = []corev1.PersistentVolumeClaimCondition{
+		{Type: corev1.PersistentVolumeClaimResizing, Status: corev1.ConditionTrue},
+		{Type: corev1.PersistentVolumeClaimFileSystemResizePending, Status: corev1.ConditionTrue},
+	}
+	assert.True(t, FileSystemResizePending(pvc))
+
+	pvc.Status.Conditions[1].Status = corev1.ConditionFalse
+	assert.False(t, FileSystemResizePending(pvc))
+}
+
+func TestConsumingPods(t *testing.T) {
+	pod := func(name, namespace, claim string, phase corev1.PodPhase) corev1.Pod {
+		return corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec: corev1.PodSpec{Volumes: []corev1.Volume{
+				{Name: "config", VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{}}},
+				{Name: "data", VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claim}}},
+			}},
+			Status: corev1.PodStatus{Phase: phase},
+		}
+	}
+	terminating := pod("terminating", "default", "data", corev1.PodRunning)
+	terminating.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+
+	pods := []corev1.Pod{
+		pod("db-0", "default", "data", corev1.PodRunning),
+		pod("db-1", "default", "other", corev1.PodRunning),
+		pod("db-0", "other", "data", corev1.PodRunning),
+		pod("backup", "default", "data", corev1.PodSucceeded),
+		pod("starting", "default", "data", corev1.PodPending),
+		terminating,
+	}
+
+	consuming := ConsumingPods(newTestPVC(), pods)
+
+	var names []string
+	for _, pod := range consuming {
+		names = append(names, pod.Name)
+	}
+	assert.Equal(t, []string{"db-0", "starting"}, names)
+}
@@ -0,0 +1,421 @@
+// Package policy holds the decision logic of the autoscaler, shared by the
+// controller and the kubectl plugin so that both give the same answers
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/lorenzophys/pvc-autoscaler/internal/maintenance"
+	clients "github.com/lorenzophys/pvc-autoscaler/internal/metrics_clients/clients"
+	"github.com/lorenzophys/pvc-autoscaler/internal/provider"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// Reason codes of the decisions taken for a PVC
+type Reason string
+
+const (
+	ReasonNoStorageClass              Reason = "NoStorageClass"
+	ReasonStorageClassNotFound        Reason = "StorageClassNotFound"
+	ReasonStorageClassNotExpandable   Reason = "StorageClassNotExpandable"
+	ReasonNotResizable                Reason = "NotResizable"
+	ReasonInvalidCeiling              Reason = "InvalidCeiling"
+	ReasonOriginalSizeNotRecorded     Reason = "OriginalSizeNotRecorded"
+	ReasonMetricsMissing              Reason = "MetricsMissing"
+	ReasonMetricsStale                Reason = "MetricsStale"
+	ReasonInvalidThreshold            Reason = "InvalidThreshold"
+	ReasonCapacityNotSet              Reason = "CapacityNotSet"
+	ReasonCapacityZero                Reason = "CapacityZero"
+	ReasonInvalidIncrease             Reason = "InvalidIncrease"
+	ReasonInvalidTiers                Reason = "InvalidTiers"
+	ReasonInvalidPreviousCapacity     Reason = "InvalidPreviousCapacity"
+	ReasonResizePending               Reason = "ResizePending"
+	ReasonCeilingReached              Reason = "CeilingReached"
+	ReasonBelowThreshold              Reason = "BelowThreshold"
+	ReasonThresholdExceeded           Reason = "ThresholdExceeded"
+	ReasonResizeRequested             Reason = "ResizeRequested"
+	ReasonResizeRequestRejected       Reason = "ResizeRequestRejected"
+	ReasonProviderCooldown            Reason = "ProviderCooldown"
+	ReasonInvalidAttributesClasses    Reason = "InvalidAttributesClasses"
+	ReasonInvalidResizeWindow         Reason = "InvalidResizeWindow"
+	ReasonOutsideResizeWindow         Reason = "OutsideResizeWindow"
+	ReasonResizeFrozen                Reason = "ResizeFrozen"
+	ReasonInsufficientBackendCapacity Reason = "InsufficientBackendCapacity"
+
+	// Set by the controller after acting on a Resize decision
+	ReasonSnapshotPending Reason = "SnapshotPending"
+	ReasonSnapshotFailed  Reason = "SnapshotFailed"
+	ReasonResizeFailed    Reason = "ResizeFailed"
+	ReasonResized         Reason = "Resized"
+)
+
+// Config is the part of the autoscaler configuration the decision depends on
+type Config struct {
+	// DefaultThreshold and DefaultIncrease are used when neither the PVC nor
+	// its StorageClass set a value
+	DefaultThreshold string
+	DefaultIncrease  string
+	// DefaultTiers is used when neither the PVC nor its StorageClass set
+	// tiers, a threshold or an increase
+	DefaultTiers string
+	// DefaultCeilingFactor bounds the PVCs without ceiling annotation nor
+	// storage limit to this multiple of their original size, zero disables it
+	DefaultCeilingFactor float64
+	// ProviderLimits are looked up by the provisioner of the StorageClass
+	ProviderLimits provider.Table
+	// DefaultResizeWindow and DefaultEmergencyThreshold are used when
+	// neither the PVC nor its StorageClass set a value
+	DefaultResizeWindow       string
+	DefaultEmergencyThreshold string
+	// Freeze defers all the resizes below the emergency threshold
+	Freeze bool
+	// Backend returns the PersistentVolume of the PVC and the published
+	// CSIStorageCapacities, it is only called for a resize. Resizes are not
+	// fitted in the backend capacity when it is nil or returns a nil volume.
+	Backend func() (*corev1.PersistentVolume, []storagev1.CSIStorageCapacity)
+
+	// MaxMetricsAge is the age at Now above which the metrics are stale, zero
+	// disables the check. Metrics without timestamp are never stale.
+	MaxMetricsAge time.Duration
+	Now           time.Time
+}
+
+// Policy is the effective policy of a PVC, after merging the annotations of
+// the PVC, of its StorageClass and the defaults
+type Policy struct {
+	Threshold string
+	Increase  string
+	Ceiling   string
+	// Tiers replace Threshold and Increase when set
+	Tiers string
+	// AttributesClasses maps size bands to VolumeAttributesClasses
+	AttributesClasses string
+	// ResizeWindow restricts the resizes to maintenance windows,
+	// EmergencyThreshold is the usage above which they are not restricted
+	ResizeWindow       string
+	EmergencyThreshold string
+}
+
+// Decision is one of Resize, Skip or Wait
+type Decision interface {
+	reason() Reason
+}
+
+// Resize requests the PVC to grow to NewSize, Requested is set when it
+// comes from the resize request annotation and Tier when it comes from a
+// tier of the tiers annotation. AttributesClass is set when the PVC also
+// switches to another VolumeAttributesClass. Emergency is set when the
+// usage reached the emergency threshold or a nocooldown tier outside of the
+// resize windows or during a freeze, NoCooldown tells the tier apart.
+// BackendLimited is set when NewSize was lowered to fit in the backend
+// capacity.
+type Resize struct {
+	NewSize         resource.Quantity
+	Requested       bool
+	Tier            string
+	AttributesClass string
+	Emergency       bool
+	NoCooldown      bool
+	BackendLimited  bool
+}
+
+// Skip leaves the PVC alone, Err is set when the PVC or its configuration
+// are invalid
+type Skip struct {
+	Reason Reason
+	Err    error
+}
+
+// Wait leaves the PVC alone until a condition outside of the autoscaler
+// control changes, e.g. a pending resize is accepted
+type Wait struct {
+	Reason Reason
+	Err    error
+}
+
+func (r Resize) reason() Reason {
+	if r.Requested {
+		return ReasonResizeRequested
+	}
+	return ReasonThresholdExceeded
+}
+func (s Skip) reason() Reason { return s.Reason }
+func (w Wait) reason() Reason { return w.Reason }
+
+// Evaluation is the decision taken for a PVC along with the values it is
+// based on. The values are set as far as the evaluation went.
+type Evaluation struct {
+	Decision Decision
+
+	Policy         Policy
+	ThresholdBytes int64
+	CeilingBytes   int64
+	// CurrentSizeBytes is the capacity in the PVC status
+	CurrentSizeBytes int64
+	// Tier is the highest tier reached by the usage, if the policy has tiers
+	Tier string
+	// Limits are the limits of the provisioner of the StorageClass, if known
+	Limits *provider.Limits
+	// NextResizeAt is the end of the provider cooldown or the start of the
+	// next resize window of a deferred resize, zero during a freeze
+	NextResizeAt time.Time
+}
+
+func (e Evaluation) Reason() Reason {
+	return e.Decision.reason()
+}
+
+// Err returns the error of a Skip or a Wait decision
+func (e Evaluation) Err() error {
+	switch d := e.Decision.(type) {
+	case Skip:
+		return d.Err
+	case Wait:
+		return d.Err
+	default:
+		return nil
+	}
+}
+
+// Evaluate decides what to do with a managed PVC given its StorageClass and
+// its metrics, both may be nil when they could not be found. It does not
+// have side effects.
+func Evaluate(pvc *corev1.PersistentVolumeClaim, sc *storagev1.StorageClass, metrics *clients.PVCMetrics, cfg Config) Evaluation {
+	pvcId := fmt.Sprintf("%s/%s", pvc.Namespace, pvc.Name)
+	var res Evaluation
+
+	decide := func(decision Decision) Evaluation {
+		res.Decision = decision
+		return res
+	}
+
+	// Determine if the StorageClass allows volume expansion
+	if pvc.Spec.StorageClassName == nil {
+		return decide(Skip{ReasonNoStorageClass, fmt.Errorf("the PersistentVolumeClaim %s has no StorageClass", pvcId)})
+	}
+	storageClassName := *pvc.Spec.StorageClassName
+	if sc == nil {
+		return decide(Skip{ReasonStorageClassNotFound, fmt.Errorf("could not find StorageClass %s for %s", storageClassName, pvcId)})
+	}
+	if sc.AllowVolumeExpansion == nil || !*sc.AllowVolumeExpansion {
+		return decide(Skip{ReasonStorageClassNotExpandable, fmt.Errorf("the StorageClass %s of %s does not allow volume expansion", storageClassName, pvcId)})
+	}
+
+	annotations := EffectiveAnnotations(sc, pvc)
+	res.Policy = Policy{
+		Threshold:          annotations[ThresholdAnnotation],
+		Increase:           annotations[IncreaseAnnotation],
+		Ceiling:            annotations[CeilingAnnotation],
+		Tiers:              effectiveTiers(sc, pvc, cfg.DefaultTiers),
+		AttributesClasses:  annotations[AttributesClassesAnnotation],
+		ResizeWindow:       cfg.DefaultResizeWindow,
+		EmergencyThreshold: cfg.DefaultEmergencyThreshold,
+	}
+	if window, ok := annotations[ResizeWindowAnnotation]; ok {
+		res.Policy.ResizeWindow = window
+	}
+	if emergency, ok := annotations[EmergencyThresholdAnnotation]; ok {
+		res.Policy.EmergencyThreshold = emergency
+	}
+	if res.Policy.Threshold == "" {
+		res.Policy.Threshold = cfg.DefaultThreshold
+	}
+	if res.Policy.Increase == "" {
+		res.Policy.Increase = cfg.DefaultIncrease
+	}
+
+	// Determine if pvc the meets the condition for resize
+	if err := isResizable(pvc, annotations, cfg.DefaultCeilingFactor); err != nil {
+		if errors.Is(err, ErrOriginalSizeNotRecorded) {
+			return decide(Wait{ReasonOriginalSizeNotRecorded, fmt.Errorf("the relative ceiling of %s is unknown: %w", pvcId, err)})
+		}
+		reason := ReasonNotResizable
+		if errors.Is(err, ErrInvalidCeiling) {
+			reason = ReasonInvalidCeiling
+		}
+		return decide(Skip{reason, fmt.Errorf("the PersistentVolumeClaim %s is not resizable: %w", pvcId, err)})
+	}
+
+	if metrics == nil {
+		return decide(Wait{ReasonMetricsMissing, fmt.Errorf("could not fetch the metrics for %s", pvcId)})
+	}
+	if cfg.MaxMetricsAge > 0 && !metrics.Timestamp.IsZero() {
+		if age := cfg.Now.Sub(metrics.Timestamp); age > cfg.MaxMetricsAge {
+			return decide(Wait{ReasonMetricsStale, fmt.Errorf("the metrics of %s are %s old, more than %s", pvcId, age.Round(time.Second), cfg.MaxMetricsAge)})
+		}
+	}
+
+	thresholdValue, increaseValue := res.Policy.Threshold, res.Policy.Increase
+	// Set when the reached tier bypasses the resize windows and the freeze
+	noCooldown := false
+	if res.Policy.Tiers != "" {
+		tiers, err := ParseTiers(res.Policy.Tiers)
+		if err != nil {
+			return decide(Skip{ReasonInvalidTiers, fmt.Errorf("failed to parse tiers annotation for %s: %w", pvcId, err)})
+		}
+
+		// The highest tier reached, or the first one to report its threshold
+		tier := tiers[0]
+		for _, t := range tiers {
+			tierThreshold, _ := PercentageToBytes(t.Threshold, metrics.VolumeCapacityBytes, "")
+			if metrics.VolumeUsedBytes >= tierThreshold {
+				tier = t
+				res.Tier = t.String()
+				noCooldown = t.NoCooldown
+			}
+		}
+		thresholdValue, increaseValue = tier.Threshold, tier.Increase
+	}
+
+	threshold, err := PercentageToBytes(thresholdValue, metrics.VolumeCapacityBytes, cfg.DefaultThreshold)
+	if err != nil {
+		return decide(Skip{ReasonInvalidThreshold, fmt.Errorf("failed to convert threshold annotation for %s: %w", pvcId, err)})
+	}
+	res.ThresholdBytes = threshold
+
+	capacity, exists := pvc.Status.Capacity[corev1.ResourceStorage]
+	if !exists {
+		return decide(Wait{Reason: ReasonCapacityNotSet})
+	}
+	if capacity.Value() == 0 {
+		return decide(Wait{Reason: ReasonCapacityZero})
+	}
+	res.CurrentSizeBytes = capacity.Value()
+
+	increase, err := PercentageToBytes(increaseValue, capacity.Value(), cfg.DefaultIncrease)
+	if err != nil {
+		return decide(Skip{ReasonInvalidIncrease, fmt.Errorf("failed to convert increase annotation for %s: %w", pvcId, err)})
+	}
+
+	if previousCapacity, exist := pvc.Annotations[PreviousCapacityAnnotation]; exist {
+		parsedPreviousCapacity, err := strconv.ParseInt(previousCapacity, 10, 64)
+		if err != nil {
+			return decide(Skip{ReasonInvalidPreviousCapacity, fmt.Errorf("failed to parse 'previous_capacity' annotation of %s: %w", pvcId, err)})
+		}
+		if parsedPreviousCapacity == metrics.VolumeCapacityBytes {
+			return decide(Wait{Reason: ReasonResizePending})
+		}
+	}
+
+	ceiling, err := StorageCeiling(pvc, annotations, cfg.DefaultCeilingFactor)
+	if err != nil {
+		return decide(Skip{ReasonInvalidCeiling, fmt.Errorf("failed to fetch storage ceiling for %s: %w", pvcId, err)})
+	}
+	if limits, ok := cfg.ProviderLimits[sc.Provisioner]; ok {
+		res.Limits = &limits
+		if !limits.MaxSize.IsZero() && limits.MaxSize.Cmp(ceiling) < 0 {
+			ceiling = limits.MaxSize
+		}
+	}
+	res.CeilingBytes = ceiling.Value()
+
+	var bands []AttributesClassBand
+	if res.Policy.AttributesClasses != "" {
+		bands, err = ParseAttributesClassBands(res.Policy.AttributesClasses)
+		if err != nil {
+			return decide(Skip{ReasonInvalidAttributesClasses, fmt.Errorf("failed to parse attributes classes annotation for %s: %w", pvcId, err)})
+		}
+	}
+
+	windows, err := maintenance.ParseWindows(res.Policy.ResizeWindow)
+	if err != nil {
+		return decide(Skip{ReasonInvalidResizeWindow, fmt.Errorf("failed to parse resize window annotation for %s: %w", pvcId, err)})
+	}
+	emergency := false
+	if res.Policy.EmergencyThreshold != "" {
+		emergencyThreshold, err := PercentageToBytes(res.Policy.EmergencyThreshold, metrics.VolumeCapacityBytes, "")
+		if err != nil {
+			return decide(Skip{ReasonInvalidThreshold, fmt.Errorf("failed to convert emergency threshold annotation for %s: %w", pvcId, err)})
+		}
+		emergency = metrics.VolumeUsedBytes >= emergencyThreshold
+	}
+
+	// A resize during the cooldown of the provider fails, it is deferred. A
+	// resize, requested or not, is also deferred outside of the resize
+	// windows and during a freeze, unless the usage is above the emergency
+	// threshold or a nocooldown tier. Last it is fitted in the capacity of
+	// the storage backend.
+	resize := func(r Resize) Evaluation {
+		if res.Limits != nil && res.Limits.Cooldown.Duration > 0 {
+			lastResize, err := time.Parse(time.RFC3339, pvc.Annotations[LastResizeAnnotation])
+			if next := lastResize.Add(res.Limits.Cooldown.Duration); err == nil && cfg.Now.Before(next) {
+				res.NextResizeAt = next
+				return decide(Wait{Reason: ReasonProviderCooldown})
+			}
+		}
+
+		outsideWindows := len(windows) > 0 && !windows.Contains(cfg.Now)
+		switch {
+		case (cfg.Freeze || outsideWindows) && (emergency || noCooldown):
+			r.Emergency = true
+			r.NoCooldown = !emergency
+		case cfg.Freeze:
+			return decide(Wait{Reason: ReasonResizeFrozen})
+		case outsideWindows:
+			res.NextResizeAt = windows.Next(cfg.Now)
+			return decide(Wait{Reason: ReasonOutsideResizeWindow})
+		}
+
+		// Requested sizes are not lowered, the request waits for room instead
+		if cfg.Backend != nil {
+			if pv, capacities := cfg.Backend(); pv != nil {
+				fitted, err := FitBackendCapacity(pv, storageClassName, capacities, capacity, r.NewSize, !r.Requested)
+				if err == nil && fitted.Cmp(r.NewSize) < 0 && res.Limits != nil && fitted.Value()-capacity.Value() < res.Limits.MinStep.Value() {
+					err = fmt.Errorf("%w: growing to %s is below the minimum step %s of the provider", ErrInsufficientBackendCapacity, fitted.String(), res.Limits.MinStep.String())
+				}
+				if err != nil {
+					return decide(Wait{ReasonInsufficientBackendCapacity, fmt.Errorf("cannot resize %s: %w", pvcId, err)})
+				}
+				if fitted.Cmp(r.NewSize) < 0 {
+					r.NewSize = fitted
+					r.BackendLimited = true
+				}
+			}
+		}
+
+		r.AttributesClass = attributesClassSwitch(pvc, bands, capacity, r.NewSize)
+		return decide(r)
+	}
+
+	// A resize request skips the threshold but not the ceiling
+	if request, ok := pvc.Annotations[ResizeRequestAnnotation]; ok {
+		newSize, err := ParseResizeRequest(request, capacity)
+		if err != nil {
+			return decide(Skip{ReasonResizeRequestRejected, fmt.Errorf("invalid resize request %q for %s: %w", request, pvcId, err)})
+		}
+		if newSize.Cmp(capacity) <= 0 {
+			return decide(Skip{ReasonResizeRequestRejected, fmt.Errorf("the resize request %q for %s is not larger than its capacity %s", request, pvcId, capacity.String())})
+		}
+		if newSize.Cmp(ceiling) > 0 {
+			return decide(Skip{ReasonResizeRequestRejected, fmt.Errorf("the resize request %q for %s is above its ceiling %s", request, pvcId, ceiling.String())})
+		}
+		return resize(Resize{NewSize: newSize, Requested: true})
+	}
+
+	if capacity.Cmp(ceiling) >= 0 {
+		return decide(Skip{Reason: ReasonCeilingReached})
+	}
+
+	if metrics.VolumeUsedBytes < threshold {
+		return decide(Skip{Reason: ReasonBelowThreshold})
+	}
+
+	// 1<<30 is a bit shift operation that represents 2^30, i.e. 1Gi
+	newStorageBytes := int64(math.Ceil(float64(capacity.Value()+increase)/(1<<30))) << 30
+	newStorage := *resource.NewQuantity(newStorageBytes, resource.BinarySI)
+	if res.Limits != nil && newStorageBytes-capacity.Value() < res.Limits.MinStep.Value() {
+		newStorage = capacity.DeepCopy()
+		newStorage.Add(res.Limits.MinStep)
+	}
+	if newStorage.Cmp(ceiling) > 0 {
+		newStorage = ceiling
+	}
+
+	return resize(Resize{NewSize: newStorage, Tier: res.Tier})
+}
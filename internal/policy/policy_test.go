@@ -0,0 +1,678 @@
+package policy
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	clients "github.com/lorenzophys/pvc-autoscaler/internal/metrics_clients/clients"
+	"github.com/lorenzophys/pvc-autoscaler/internal/provider"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const testStorageClass = "expandable"
+
+var testConfig = Config{DefaultThreshold: "80%", DefaultIncrease: "20%"}
+
+func newTestStorageClass() *storagev1.StorageClass {
+	allowExpansion := true
+	return &storagev1.StorageClass{
+		ObjectMeta:           metav1.ObjectMeta{Name: testStorageClass},
+		AllowVolumeExpansion: &allowExpansion,
+	}
+}
+
+// newTestPVC returns a bound 10Gi PVC with a 20Gi ceiling
+func newTestPVC() *corev1.PersistentVolumeClaim {
+	scName := testStorageClass
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "data",
+			Annotations: map[string]string{
+				EnabledAnnotation: "true",
+				CeilingAnnotation: "20Gi",
+			},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{StorageClassName: &scName},
+		Status: corev1.PersistentVolumeClaimStatus{
+			Phase:    corev1.ClaimBound,
+			Capacity: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")},
+		},
+	}
+}
+
+func newTestMetrics(usedGi float64) *clients.PVCMetrics {
+	return &clients.PVCMetrics{VolumeUsedBytes: int64(usedGi * (1 << 30)), VolumeCapacityBytes: 10 << 30}
+}
+
+func TestEnabled(t *testing.T) {
+	enabledSC := &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{EnabledAnnotation: "true"}}}
+
+	assert.True(t, Enabled(&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{EnabledAnnotation: "true"}}}, nil))
+	assert.True(t, Enabled(&corev1.PersistentVolumeClaim{}, enabledSC))
+	assert.False(t, Enabled(&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{EnabledAnnotation: "false"}}}, enabledSC))
+	assert.False(t, Enabled(&corev1.PersistentVolumeClaim{}, nil))
+}
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		name    string
+		pvc     func(pvc *corev1.PersistentVolumeClaim)
+		sc      func(sc *storagev1.StorageClass) *storagev1.StorageClass
+		metrics *clients.PVCMetrics
+		// expected
+		decision Decision
+		newSize  string
+		withErr  bool
+	}{
+		{
+			name:     "no storage class",
+			pvc:      func(pvc *corev1.PersistentVolumeClaim) { pvc.Spec.StorageClassName = nil },
+			metrics:  newTestMetrics(9),
+			decision: Skip{Reason: ReasonNoStorageClass},
+			withErr:  true,
+		},
+		{
+			name:     "storage class not found",
+			sc:       func(*storagev1.StorageClass) *storagev1.StorageClass { return nil },
+			metrics:  newTestMetrics(9),
+			decision: Skip{Reason: ReasonStorageClassNotFound},
+			withErr:  true,
+		},
+		{
+			name: "storage class expansion not set",
+			sc: func(sc *storagev1.StorageClass) *storagev1.StorageClass {
+				sc.AllowVolumeExpansion = nil
+				return sc
+			},
+			metrics:  newTestMetrics(9),
+			decision: Skip{Reason: ReasonStorageClassNotExpandable},
+			withErr:  true,
+		},
+		{
+			name: "storage class expansion disabled",
+			sc: func(sc *storagev1.StorageClass) *storagev1.StorageClass {
+				allowExpansion := false
+				sc.AllowVolumeExpansion = &allowExpansion
+				return sc
+			},
+			metrics:  newTestMetrics(9),
+			decision: Skip{Reason: ReasonStorageClassNotExpandable},
+			withErr:  true,
+		},
+		{
+			name:     "invalid ceiling",
+			pvc:      func(pvc *corev1.PersistentVolumeClaim) { pvc.Annotations[CeilingAnnotation] = "twenty" },
+			metrics:  newTestMetrics(9),
+			decision: Skip{Reason: ReasonInvalidCeiling},
+			withErr:  true,
+		},
+		{
+			name:     "no ceiling",
+			pvc:      func(pvc *corev1.PersistentVolumeClaim) { delete(pvc.Annotations, CeilingAnnotation) },
+			metrics:  newTestMetrics(9),
+			decision: Skip{Reason: ReasonNotResizable},
+			withErr:  true,
+		},
+		{
+			name: "block volume",
+			pvc: func(pvc *corev1.PersistentVolumeClaim) {
+				mode := corev1.PersistentVolumeBlock
+				pvc.Spec.VolumeMode = &mode
+			},
+			metrics:  newTestMetrics(9),
+			decision: Skip{Reason: ReasonNotResizable},
+			withErr:  true,
+		},
+		{
+			name:     "not bound",
+			pvc:      func(pvc *corev1.PersistentVolumeClaim) { pvc.Status.Phase = corev1.ClaimPending },
+			metrics:  newTestMetrics(9),
+			decision: Skip{Reason: ReasonNotResizable},
+			withErr:  true,
+		},
+		{
+			name:     "metrics missing",
+			decision: Wait{Reason: ReasonMetricsMissing},
+			withErr:  true,
+		},
+		{
+			name:     "threshold not a percentage",
+			pvc:      func(pvc *corev1.PersistentVolumeClaim) { pvc.Annotations[ThresholdAnnotation] = "80" },
+			metrics:  newTestMetrics(9),
+			decision: Skip{Reason: ReasonInvalidThreshold},
+			withErr:  true,
+		},
+		{
+			name:     "threshold out of range",
+			pvc:      func(pvc *corev1.PersistentVolumeClaim) { pvc.Annotations[ThresholdAnnotation] = "120%" },
+			metrics:  newTestMetrics(9),
+			decision: Skip{Reason: ReasonInvalidThreshold},
+			withErr:  true,
+		},
+		{
+			name:     "capacity not set",
+			pvc:      func(pvc *corev1.PersistentVolumeClaim) { pvc.Status.Capacity = nil },
+			metrics:  newTestMetrics(9),
+			decision: Wait{Reason: ReasonCapacityNotSet},
+		},
+		{
+			name: "capacity zero",
+			pvc: func(pvc *corev1.PersistentVolumeClaim) {
+				pvc.Status.Capacity[corev1.ResourceStorage] = resource.MustParse("0")
+			},
+			metrics:  newTestMetrics(9),
+			decision: Wait{Reason: ReasonCapacityZero},
+		},
+		{
+			name:     "invalid increase",
+			pvc:      func(pvc *corev1.PersistentVolumeClaim) { pvc.Annotations[IncreaseAnnotation] = "2Gi" },
+			metrics:  newTestMetrics(9),
+			decision: Skip{Reason: ReasonInvalidIncrease},
+			withErr:  true,
+		},
+		{
+			name:     "invalid previous capacity",
+			pvc:      func(pvc *corev1.PersistentVolumeClaim) { pvc.Annotations[PreviousCapacityAnnotation] = "10Gi" },
+			metrics:  newTestMetrics(9),
+			decision: Skip{Reason: ReasonInvalidPreviousCapacity},
+			withErr:  true,
+		},
+		{
+			name:     "resize pending",
+			pvc:      func(pvc *corev1.PersistentVolumeClaim) { pvc.Annotations[PreviousCapacityAnnotation] = "10737418240" },
+			metrics:  newTestMetrics(9),
+			decision: Wait{Reason: ReasonResizePending},
+		},
+		{
+			name:     "previous resize accepted",
+			pvc:      func(pvc *corev1.PersistentVolumeClaim) { pvc.Annotations[PreviousCapacityAnnotation] = "8589934592" },
+			metrics:  newTestMetrics(9),
+			decision: Resize{},
+			newSize:  "12Gi",
+		},
+		{
+			name:     "ceiling reached",
+			pvc:      func(pvc *corev1.PersistentVolumeClaim) { pvc.Annotations[CeilingAnnotation] = "10Gi" },
+			metrics:  newTestMetrics(9),
+			decision: Skip{Reason: ReasonCeilingReached},
+		},
+		{
+			name:     "below threshold",
+			metrics:  newTestMetrics(7),
+			decision: Skip{Reason: ReasonBelowThreshold},
+		},
+		{
+			name:     "threshold exceeded",
+			metrics:  newTestMetrics(9),
+			decision: Resize{},
+			newSize:  "12Gi",
+		},
+		{
+			name:     "usage equal to threshold",
+			metrics:  newTestMetrics(8),
+			decision: Resize{},
+			newSize:  "12Gi",
+		},
+		{
+			name:     "new size rounded up to the next Gi",
+			pvc:      func(pvc *corev1.PersistentVolumeClaim) { pvc.Annotations[IncreaseAnnotation] = "5%" },
+			metrics:  newTestMetrics(9),
+			decision: Resize{},
+			newSize:  "11Gi",
+		},
+		{
+			name:     "new size clamped to the ceiling",
+			pvc:      func(pvc *corev1.PersistentVolumeClaim) { pvc.Annotations[IncreaseAnnotation] = "100%" },
+			metrics:  newTestMetrics(9),
+			decision: Resize{},
+			newSize:  "20Gi",
+		},
+		{
+			name: "ceiling from the storage limit",
+			pvc: func(pvc *corev1.PersistentVolumeClaim) {
+				delete(pvc.Annotations, CeilingAnnotation)
+				pvc.Spec.Resources.Limits = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("11Gi")}
+			},
+			metrics:  newTestMetrics(9),
+			decision: Resize{},
+			newSize:  "11Gi",
+		},
+		{
+			name: "storage class annotations",
+			pvc:  func(pvc *corev1.PersistentVolumeClaim) { delete(pvc.Annotations, CeilingAnnotation) },
+			sc: func(sc *storagev1.StorageClass) *storagev1.StorageClass {
+				sc.Annotations = map[string]string{
+					ThresholdAnnotation: "95%",
+					CeilingAnnotation:   "50Gi",
+				}
+				return sc
+			},
+			metrics:  newTestMetrics(9),
+			decision: Skip{Reason: ReasonBelowThreshold},
+		},
+		{
+			name: "pvc annotations override the storage class",
+			pvc:  func(pvc *corev1.PersistentVolumeClaim) { pvc.Annotations[ThresholdAnnotation] = "90%" },
+			sc: func(sc *storagev1.StorageClass) *storagev1.StorageClass {
+				sc.Annotations = map[string]string{ThresholdAnnotation: "95%", IncreaseAnnotation: "50%"}
+				return sc
+			},
+			metrics:  newTestMetrics(9),
+			decision: Resize{},
+			newSize:  "15Gi",
+		},
+		{
+			name:     "resize request below the threshold",
+			pvc:      func(pvc *corev1.PersistentVolumeClaim) { pvc.Annotations[ResizeRequestAnnotation] = "16Gi" },
+			metrics:  newTestMetrics(1),
+			decision: Resize{Requested: true},
+			newSize:  "16Gi",
+		},
+		{
+			name:     "relative resize request",
+			pvc:      func(pvc *corev1.PersistentVolumeClaim) { pvc.Annotations[ResizeRequestAnnotation] = "+500Mi" },
+			metrics:  newTestMetrics(1),
+			decision: Resize{Requested: true},
+			newSize:  "10740Mi",
+		},
+		{
+			name:     "resize request above the ceiling",
+			pvc:      func(pvc *corev1.PersistentVolumeClaim) { pvc.Annotations[ResizeRequestAnnotation] = "+50Gi" },
+			metrics:  newTestMetrics(1),
+			decision: Skip{Reason: ReasonResizeRequestRejected},
+			withErr:  true,
+		},
+		{
+			name:     "resize request smaller than the capacity",
+			pvc:      func(pvc *corev1.PersistentVolumeClaim) { pvc.Annotations[ResizeRequestAnnotation] = "5Gi" },
+			metrics:  newTestMetrics(1),
+			decision: Skip{Reason: ReasonResizeRequestRejected},
+			withErr:  true,
+		},
+		{
+			name:     "invalid resize request",
+			pvc:      func(pvc *corev1.PersistentVolumeClaim) { pvc.Annotations[ResizeRequestAnnotation] = "+-5Gi" },
+			metrics:  newTestMetrics(1),
+			decision: Skip{Reason: ReasonResizeRequestRejected},
+			withErr:  true,
+		},
+		{
+			name: "resize request waits for the pending resize",
+			pvc: func(pvc *corev1.PersistentVolumeClaim) {
+				pvc.Annotations[ResizeRequestAnnotation] = "16Gi"
+				pvc.Annotations[PreviousCapacityAnnotation] = strconv.Itoa(10 << 30)
+			},
+			metrics:  newTestMetrics(1),
+			decision: Wait{Reason: ReasonResizePending},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pvc := newTestPVC()
+			if tt.pvc != nil {
+				tt.pvc(pvc)
+			}
+			sc := newTestStorageClass()
+			if tt.sc != nil {
+				sc = tt.sc(sc)
+			}
+
+			result := Evaluate(pvc, sc, tt.metrics, testConfig)
+
+			assert.IsType(t, tt.decision, result.Decision)
+			assert.Equal(t, tt.decision.reason(), result.Reason())
+			if tt.withErr {
+				assert.Error(t, result.Err())
+			} else {
+				assert.NoError(t, result.Err())
+			}
+			if tt.newSize != "" {
+				expected := resource.MustParse(tt.newSize)
+				resize := result.Decision.(Resize)
+				assert.Equal(t, expected.Value(), resize.NewSize.Value())
+			}
+		})
+	}
+}
+
+func TestEvaluateValues(t *testing.T) {
+	pvc := newTestPVC()
+	pvc.Annotations[ThresholdAnnotation] = "50%"
+
+	result := Evaluate(pvc, newTestStorageClass(), newTestMetrics(9), testConfig)
+
+	assert.Equal(t, Policy{Threshold: "50%", Increase: "20%", Ceiling: "20Gi"}, result.Policy)
+	assert.Equal(t, int64(5<<30), result.ThresholdBytes)
+	assert.Equal(t, int64(20<<30), result.CeilingBytes)
+	assert.Equal(t, int64(10<<30), result.CurrentSizeBytes)
+}
+
+func TestEvaluateDefaultCeilingFactor(t *testing.T) {
+	pvc := newTestPVC()
+	delete(pvc.Annotations, CeilingAnnotation)
+	pvc.Annotations[OriginalSizeAnnotation] = "8Gi"
+
+	result := Evaluate(pvc, newTestStorageClass(), newTestMetrics(9), testConfig)
+	assert.Equal(t, ReasonNotResizable, result.Reason())
+
+	cfg := testConfig
+	cfg.DefaultCeilingFactor = 1.5
+	result = Evaluate(pvc, newTestStorageClass(), newTestMetrics(9), cfg)
+	assert.Equal(t, ReasonThresholdExceeded, result.Reason())
+	assert.Equal(t, int64(12<<30), result.CeilingBytes)
+	resize := result.Decision.(Resize)
+	assert.Equal(t, int64(12<<30), resize.NewSize.Value())
+
+	delete(pvc.Annotations, OriginalSizeAnnotation)
+	result = Evaluate(pvc, newTestStorageClass(), newTestMetrics(9), cfg)
+	assert.Equal(t, ReasonOriginalSizeNotRecorded, result.Reason())
+	assert.ErrorIs(t, result.Err(), ErrOriginalSizeNotRecorded)
+	assert.IsType(t, Wait{}, result.Decision)
+}
+
+func TestEvaluateStaleMetrics(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	cfg := testConfig
+	cfg.MaxMetricsAge = 2 * time.Minute
+	cfg.Now = now
+
+	tests := []struct {
+		name      string
+		timestamp time.Time
+		cfg       Config
+		expected  Reason
+	}{
+		{"fresh", now.Add(-time.Minute), cfg, ReasonThresholdExceeded},
+		{"stale", now.Add(-3 * time.Minute), cfg, ReasonMetricsStale},
+		{"no timestamp", time.Time{}, cfg, ReasonThresholdExceeded},
+		{"check disabled", now.Add(-time.Hour), testConfig, ReasonThresholdExceeded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metrics := newTestMetrics(9)
+			metrics.Timestamp = tt.timestamp
+
+			result := Evaluate(newTestPVC(), newTestStorageClass(), metrics, tt.cfg)
+
+			assert.Equal(t, tt.expected, result.Reason())
+			if tt.expected == ReasonMetricsStale {
+				assert.IsType(t, Wait{}, result.Decision)
+				assert.ErrorContains(t, result.Err(), "3m0s old")
+			}
+		})
+	}
+}
+
+func TestEvaluateTiers(t *testing.T) {
+	const tiers = "75%:10%,90%:30%,97%:50%"
+
+	tests := []struct {
+		name       string
+		pvc        map[string]string
+		sc         map[string]string
+		cfg        Config
+		usedGi     float64
+		expected   Reason
+		tier       string
+		newSize    string
+		noCooldown bool
+	}{
+		{name: "below the first tier", pvc: map[string]string{TiersAnnotation: tiers}, cfg: testConfig, usedGi: 7, expected: ReasonBelowThreshold},
+		{name: "first tier", pvc: map[string]string{TiersAnnotation: tiers}, cfg: testConfig, usedGi: 8, expected: ReasonThresholdExceeded, tier: "75%:10%", newSize: "11Gi"},
+		{name: "highest tier reached", pvc: map[string]string{TiersAnnotation: tiers}, cfg: testConfig, usedGi: 9.5, expected: ReasonThresholdExceeded, tier: "90%:30%", newSize: "13Gi"},
+		{name: "last tier", pvc: map[string]string{TiersAnnotation: tiers}, cfg: testConfig, usedGi: 9.8, expected: ReasonThresholdExceeded, tier: "97%:50%", newSize: "15Gi"},
+		{name: "storage class tiers", sc: map[string]string{TiersAnnotation: tiers}, cfg: testConfig, usedGi: 9.5, expected: ReasonThresholdExceeded, tier: "90%:30%", newSize: "13Gi"},
+		{name: "pvc threshold overrides storage class tiers", pvc: map[string]string{ThresholdAnnotation: "96%"}, sc: map[string]string{TiersAnnotation: tiers}, cfg: testConfig, usedGi: 9.5, expected: ReasonBelowThreshold},
+		{name: "default tiers", cfg: Config{DefaultThreshold: "80%", DefaultIncrease: "20%", DefaultTiers: tiers}, usedGi: 9.8, expected: ReasonThresholdExceeded, tier: "97%:50%", newSize: "15Gi"},
+		{name: "invalid tiers", pvc: map[string]string{TiersAnnotation: "90%:30%,75%:10%"}, cfg: testConfig, usedGi: 9.5, expected: ReasonInvalidTiers},
+		{
+			name:       "nocooldown tier during a freeze",
+			pvc:        map[string]string{TiersAnnotation: "75%:10%,90%:30%,97%:50%:nocooldown"},
+			cfg:        Config{DefaultThreshold: "80%", DefaultIncrease: "20%", Freeze: true},
+			usedGi:     9.8,
+			expected:   ReasonThresholdExceeded,
+			tier:       "97%:50%:nocooldown",
+			newSize:    "15Gi",
+			noCooldown: true,
+		},
+		{
+			name:     "lower tier during a freeze",
+			pvc:      map[string]string{TiersAnnotation: "75%:10%,90%:30%,97%:50%:nocooldown"},
+			cfg:      Config{DefaultThreshold: "80%", DefaultIncrease: "20%", Freeze: true},
+			usedGi:   9.5,
+			expected: ReasonResizeFrozen,
+			tier:     "90%:30%",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pvc := newTestPVC()
+			for key, value := range tt.pvc {
+				pvc.Annotations[key] = value
+			}
+			sc := newTestStorageClass()
+			sc.Annotations = tt.sc
+
+			result := Evaluate(pvc, sc, newTestMetrics(tt.usedGi), tt.cfg)
+
+			assert.Equal(t, tt.expected, result.Reason())
+			assert.Equal(t, tt.tier, result.Tier)
+			if tt.newSize != "" {
+				expected := resource.MustParse(tt.newSize)
+				resize := result.Decision.(Resize)
+				assert.Equal(t, expected.Value(), resize.NewSize.Value())
+				assert.Equal(t, tt.tier, resize.Tier)
+				assert.Equal(t, tt.noCooldown, resize.NoCooldown)
+			}
+		})
+	}
+}
+
+func TestEvaluateProviderLimits(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	cfg := testConfig
+	cfg.Now = now
+	cfg.ProviderLimits = provider.Table{
+		"csi.example.com": {
+			MaxSize:  resource.MustParse("15Gi"),
+			MinStep:  resource.MustParse("4Gi"),
+			Cooldown: metav1.Duration{Duration: 6 * time.Hour},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		pvc        func(pvc *corev1.PersistentVolumeClaim)
+		capacityGi int64
+		expected   Reason
+		newSize    string
+	}{
+		{name: "min step", capacityGi: 10, expected: ReasonThresholdExceeded, newSize: "14Gi"},
+		{name: "clamped to the max size", capacityGi: 12, expected: ReasonThresholdExceeded, newSize: "15Gi"},
+		{name: "max size reached", capacityGi: 15, expected: ReasonCeilingReached},
+		{
+			name: "cooldown",
+			pvc: func(pvc *corev1.PersistentVolumeClaim) {
+				pvc.Annotations[LastResizeAnnotation] = now.Add(-time.Hour).Format(time.RFC3339)
+			},
+			capacityGi: 10,
+			expected:   ReasonProviderCooldown,
+		},
+		{
+			name: "cooldown over",
+			pvc: func(pvc *corev1.PersistentVolumeClaim) {
+				pvc.Annotations[LastResizeAnnotation] = now.Add(-7 * time.Hour).Format(time.RFC3339)
+			},
+			capacityGi: 10,
+			expected:   ReasonThresholdExceeded,
+			newSize:    "14Gi",
+		},
+		{
+			name: "nocooldown tier during the cooldown",
+			pvc: func(pvc *corev1.PersistentVolumeClaim) {
+				pvc.Annotations[LastResizeAnnotation] = now.Add(-time.Hour).Format(time.RFC3339)
+				pvc.Annotations[TiersAnnotation] = "90%:30%:nocooldown"
+			},
+			capacityGi: 10,
+			expected:   ReasonProviderCooldown,
+		},
+		{
+			name: "resize request during the cooldown",
+			pvc: func(pvc *corev1.PersistentVolumeClaim) {
+				pvc.Annotations[LastResizeAnnotation] = now.Add(-time.Hour).Format(time.RFC3339)
+				pvc.Annotations[ResizeRequestAnnotation] = "12Gi"
+			},
+			capacityGi: 10,
+			expected:   ReasonProviderCooldown,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pvc := newTestPVC()
+			pvc.Status.Capacity[corev1.ResourceStorage] = *resource.NewQuantity(tt.capacityGi<<30, resource.BinarySI)
+			if tt.pvc != nil {
+				tt.pvc(pvc)
+			}
+			sc := newTestStorageClass()
+			sc.Provisioner = "csi.example.com"
+			metrics := &clients.PVCMetrics{VolumeUsedBytes: tt.capacityGi << 30, VolumeCapacityBytes: tt.capacityGi << 30}
+
+			result := Evaluate(pvc, sc, metrics, cfg)
+
+			assert.Equal(t, tt.expected, result.Reason())
+			assert.Equal(t, int64(15<<30), result.CeilingBytes)
+			if tt.expected == ReasonProviderCooldown {
+				assert.Equal(t, now.Add(5*time.Hour), result.NextResizeAt)
+			}
+			if tt.newSize != "" {
+				expected := resource.MustParse(tt.newSize)
+				resize := result.Decision.(Resize)
+				assert.Equal(t, expected.Value(), resize.NewSize.Value())
+			}
+		})
+	}
+}
+
+func TestEvaluateResizeWindows(t *testing.T) {
+	// A wednesday
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	nightly := time.Date(2024, 5, 2, 2, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		cfg         func(cfg *Config)
+		usedGi      float64
+		expected    Reason
+		next        time.Time
+		emergency   bool
+	}{
+		{name: "no window", usedGi: 9, expected: ReasonThresholdExceeded},
+		{name: "in the window", annotations: map[string]string{ResizeWindowAnnotation: "0 10 * * * 4h"}, usedGi: 9, expected: ReasonThresholdExceeded},
+		{name: "outside of the window", annotations: map[string]string{ResizeWindowAnnotation: "0 2 * * * 4h"}, usedGi: 9, expected: ReasonOutsideResizeWindow, next: nightly},
+		{
+			name:     "default window",
+			cfg:      func(cfg *Config) { cfg.DefaultResizeWindow = "0 2 * * * 4h; 0 6 * * sat 1h" },
+			usedGi:   9,
+			expected: ReasonOutsideResizeWindow,
+			next:     nightly,
+		},
+		{
+			name:        "empty window overrides the default",
+			annotations: map[string]string{ResizeWindowAnnotation: ""},
+			cfg:         func(cfg *Config) { cfg.DefaultResizeWindow = "0 2 * * * 4h" },
+			usedGi:      9,
+			expected:    ReasonThresholdExceeded,
+		},
+		{
+			name:        "window in another time zone",
+			annotations: map[string]string{ResizeWindowAnnotation: "CRON_TZ=Asia/Tokyo 0 20 * * * 2h"},
+			usedGi:      9,
+			expected:    ReasonThresholdExceeded,
+		},
+		{
+			name:        "emergency outside of the window",
+			annotations: map[string]string{ResizeWindowAnnotation: "0 2 * * * 4h", EmergencyThresholdAnnotation: "95%"},
+			usedGi:      9.6,
+			expected:    ReasonThresholdExceeded,
+			emergency:   true,
+		},
+		{
+			name:        "below the emergency threshold",
+			annotations: map[string]string{ResizeWindowAnnotation: "0 2 * * * 4h", EmergencyThresholdAnnotation: "95%"},
+			usedGi:      9,
+			expected:    ReasonOutsideResizeWindow,
+			next:        nightly,
+		},
+		{name: "freeze", cfg: func(cfg *Config) { cfg.Freeze = true }, usedGi: 9, expected: ReasonResizeFrozen},
+		{
+			name:      "emergency during a freeze",
+			cfg:       func(cfg *Config) { cfg.Freeze = true; cfg.DefaultEmergencyThreshold = "95%" },
+			usedGi:    9.6,
+			expected:  ReasonThresholdExceeded,
+			emergency: true,
+		},
+		{
+			name:        "resize request outside of the window",
+			annotations: map[string]string{ResizeWindowAnnotation: "0 2 * * * 4h", ResizeRequestAnnotation: "12Gi"},
+			usedGi:      1,
+			expected:    ReasonOutsideResizeWindow,
+			next:        nightly,
+		},
+		{
+			name:        "resize request during a freeze",
+			annotations: map[string]string{ResizeRequestAnnotation: "12Gi"},
+			cfg:         func(cfg *Config) { cfg.Freeze = true },
+			usedGi:      1,
+			expected:    ReasonResizeFrozen,
+		},
+		{
+			name:        "resize request during a freeze above the emergency threshold",
+			annotations: map[string]string{ResizeRequestAnnotation: "12Gi", EmergencyThresholdAnnotation: "95%"},
+			cfg:         func(cfg *Config) { cfg.Freeze = true },
+			usedGi:      9.6,
+			expected:    ReasonResizeRequested,
+			emergency:   true,
+		},
+		{
+			name:        "resize request in the window",
+			annotations: map[string]string{ResizeWindowAnnotation: "0 10 * * * 4h", ResizeRequestAnnotation: "12Gi"},
+			usedGi:      1,
+			expected:    ReasonResizeRequested,
+		},
+		{name: "invalid window", annotations: map[string]string{ResizeWindowAnnotation: "at night"}, usedGi: 9, expected: ReasonInvalidResizeWindow},
+		{name: "invalid emergency threshold", annotations: map[string]string{EmergencyThresholdAnnotation: "97"}, usedGi: 9, expected: ReasonInvalidThreshold},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig
+			cfg.Now = now
+			if tt.cfg != nil {
+				tt.cfg(&cfg)
+			}
+			pvc := newTestPVC()
+			for key, value := range tt.annotations {
+				pvc.Annotations[key] = value
+			}
+
+			result := Evaluate(pvc, newTestStorageClass(), newTestMetrics(tt.usedGi), cfg)
+
+			assert.Equal(t, tt.expected, result.Reason())
+			assert.Equal(t, tt.next, result.NextResizeAt)
+			if resize, ok := result.Decision.(Resize); ok {
+				assert.Equal(t, tt.emergency, resize.Emergency)
+			}
+		})
+	}
+}
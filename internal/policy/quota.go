@@ -0,0 +1,41 @@
+package policy
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// CheckStorageQuota returns an error when growing the PVC to newSize would
+// exceed the storage requests allowed by one of the ResourceQuotas of its
+// namespace, in total or for its StorageClass
+func CheckStorageQuota(pvc *corev1.PersistentVolumeClaim, newSize resource.Quantity, quotas []corev1.ResourceQuota) error {
+	delta := newSize.DeepCopy()
+	delta.Sub(pvc.Spec.Resources.Requests[corev1.ResourceStorage])
+	if delta.Sign() <= 0 {
+		return nil
+	}
+
+	names := []corev1.ResourceName{corev1.ResourceRequestsStorage}
+	if pvc.Spec.StorageClassName != nil {
+		names = append(names, corev1.ResourceName(*pvc.Spec.StorageClassName+".storageclass.storage.k8s.io/requests.storage"))
+	}
+
+	for _, quota := range quotas {
+		for _, name := range names {
+			hard, ok := quota.Spec.Hard[name]
+			if !ok {
+				continue
+			}
+			used := quota.Status.Used[name]
+			after := used.DeepCopy()
+			after.Add(delta)
+			if after.Cmp(hard) > 0 {
+				return fmt.Errorf("growing by %s exceeds the %s quota %s: %s used out of %s", delta.String(), name, quota.Name, used.String(), hard.String())
+			}
+		}
+	}
+
+	return nil
+}
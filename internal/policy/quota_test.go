@@ -0,0 +1,73 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newQuota(name string, resourceName corev1.ResourceName, hard, used string) corev1.ResourceQuota {
+	return corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       corev1.ResourceQuotaSpec{Hard: corev1.ResourceList{resourceName: resource.MustParse(hard)}},
+		Status:     corev1.ResourceQuotaStatus{Used: corev1.ResourceList{resourceName: resource.MustParse(used)}},
+	}
+}
+
+func TestCheckStorageQuota(t *testing.T) {
+	pvc := newTestPVC()
+	pvc.Spec.Resources.Requests = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("10Gi")}
+	storageClassQuota := corev1.ResourceName(testStorageClass + ".storageclass.storage.k8s.io/requests.storage")
+
+	tests := []struct {
+		name    string
+		newSize string
+		quotas  []corev1.ResourceQuota
+		err     string
+	}{
+		{
+			name:    "no quota",
+			newSize: "100Gi",
+		},
+		{
+			name:    "fits in the quota",
+			newSize: "20Gi",
+			quotas:  []corev1.ResourceQuota{newQuota("storage", corev1.ResourceRequestsStorage, "100Gi", "90Gi")},
+		},
+		{
+			name:    "exceeds the quota",
+			newSize: "21Gi",
+			quotas:  []corev1.ResourceQuota{newQuota("storage", corev1.ResourceRequestsStorage, "100Gi", "90Gi")},
+			err:     "growing by 11Gi exceeds the requests.storage quota storage: 90Gi used out of 100Gi",
+		},
+		{
+			name:    "exceeds the storage class quota",
+			newSize: "21Gi",
+			quotas: []corev1.ResourceQuota{
+				newQuota("storage", corev1.ResourceRequestsStorage, "1Ti", "90Gi"),
+				newQuota("expandable", storageClassQuota, "50Gi", "40Gi"),
+			},
+			err: "exceeds the " + string(storageClassQuota) + " quota expandable",
+		},
+		{
+			name:    "other storage class",
+			newSize: "21Gi",
+			quotas:  []corev1.ResourceQuota{newQuota("other", "other.storageclass.storage.k8s.io/requests.storage", "50Gi", "50Gi")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckStorageQuota(pvc, resource.MustParse(tt.newSize), tt.quotas)
+
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+// Package provider holds the volume limits of the storage providers, keyed
+// by the provisioner of the StorageClass. A resize above the maximum size or
+// during the cooldown of the provider fails in the CSI driver and is retried
+// forever, so the autoscaler stays within the limits instead.
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Limits of the volumes of a provisioner, the zero values are no limit
+type Limits struct {
+	// MaxSize is the largest size of a volume
+	MaxSize resource.Quantity `json:"maxSize,omitempty"`
+	// MinStep is the smallest increase of a resize
+	MinStep resource.Quantity `json:"minStep,omitempty"`
+	// Cooldown is the minimum time between two modifications of a volume
+	Cooldown metav1.Duration `json:"cooldown,omitempty"`
+}
+
+func (l *Limits) Validate() error {
+	var errs []error
+
+	if l.MaxSize.Sign() < 0 {
+		errs = append(errs, errors.New("maxSize must not be negative"))
+	}
+	if l.MinStep.Sign() < 0 {
+		errs = append(errs, errors.New("minStep must not be negative"))
+	}
+	if l.Cooldown.Duration < 0 {
+		errs = append(errs, errors.New("cooldown must not be negative"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Table maps a provisioner, e.g. ebs.csi.aws.com, to its limits
+type Table map[string]Limits
+
+func (t Table) Validate() error {
+	var errs []error
+	for provisioner, limits := range t {
+		if err := limits.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", provisioner, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (t Table) DeepCopy() Table {
+	if t == nil {
+		return nil
+	}
+
+	out := make(Table, len(t))
+	for provisioner, limits := range t {
+		out[provisioner] = Limits{
+			MaxSize:  limits.MaxSize.DeepCopy(),
+			MinStep:  limits.MinStep.DeepCopy(),
+			Cooldown: limits.Cooldown,
+		}
+	}
+
+	return out
+}
+
+// Merge returns the entries of t replaced or completed by the ones of
+// overrides, an override replaces the whole entry of its provisioner
+func (t Table) Merge(overrides Table) Table {
+	merged := t.DeepCopy()
+	if merged == nil {
+		merged = make(Table, len(overrides))
+	}
+	maps.Copy(merged, overrides.DeepCopy())
+
+	return merged
+}
+
+func newLimits(maxSize, minStep string, cooldown time.Duration) Limits {
+	return Limits{
+		MaxSize:  resource.MustParse(maxSize),
+		MinStep:  resource.MustParse(minStep),
+		Cooldown: metav1.Duration{Duration: cooldown},
+	}
+}
+
+var (
+	// EBS allows one modification every 6 hours, gp3 and st1 volumes are
+	// limited to 16Ti
+	awsEBS = newLimits("16Ti", "1Gi", 6*time.Hour)
+	// Persistent disks are limited to 64Ti
+	gcePD = newLimits("64Ti", "1Gi", 0)
+	// Managed disks are limited to 32Ti, ultra and premium v2 disks excepted
+	azureDisk = newLimits("32Ti", "1Gi", 0)
+)
+
+// Builtin returns the limits of the common cloud providers, for both the
+// CSI and the in-tree provisioners
+func Builtin() Table {
+	return Table{
+		"ebs.csi.aws.com":          awsEBS,
+		"kubernetes.io/aws-ebs":    awsEBS,
+		"pd.csi.storage.gke.io":    gcePD,
+		"kubernetes.io/gce-pd":     gcePD,
+		"disk.csi.azure.com":       azureDisk,
+		"kubernetes.io/azure-disk": azureDisk,
+	}
+}
@@ -0,0 +1,43 @@
+package provider
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMerge(t *testing.T) {
+	builtin := Builtin()
+	overrides := Table{
+		"ebs.csi.aws.com": {MaxSize: resource.MustParse("64Ti")},
+		"csi.example.com": newLimits("1Ti", "10Gi", time.Hour),
+	}
+
+	merged := builtin.Merge(overrides)
+
+	// An override replaces the whole entry
+	ebs := merged["ebs.csi.aws.com"]
+	assert.Equal(t, "64Ti", ebs.MaxSize.String())
+	assert.Zero(t, ebs.Cooldown.Duration)
+	assert.Equal(t, time.Hour, merged["csi.example.com"].Cooldown.Duration)
+	assert.Equal(t, builtin["pd.csi.storage.gke.io"], merged["pd.csi.storage.gke.io"])
+	// The built-in table is left untouched
+	assert.Equal(t, 6*time.Hour, builtin["ebs.csi.aws.com"].Cooldown.Duration)
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Builtin().Validate())
+
+	err := Table{
+		"csi.example.com": {
+			MaxSize:  resource.MustParse("-1Gi"),
+			Cooldown: metav1.Duration{Duration: -time.Hour},
+		},
+	}.Validate()
+
+	assert.ErrorContains(t, err, "csi.example.com: maxSize must not be negative")
+	assert.ErrorContains(t, err, "cooldown must not be negative")
+}
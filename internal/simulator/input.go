@@ -0,0 +1,183 @@
+package simulator
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	usedBytesMetric     = "kubelet_volume_stats_used_bytes"
+	capacityBytesMetric = "kubelet_volume_stats_capacity_bytes"
+)
+
+var csvHeader = []string{"timestamp", "namespace", "pvc", "used_bytes"}
+
+// ReadCSV reads series from a CSV file with the columns timestamp,
+// namespace, pvc, used_bytes and optionally capacity_bytes. The timestamp is
+// either RFC3339 or in unix seconds. The first capacity of a PVC is its
+// initial capacity.
+func ReadCSV(r io.Reader) ([]Series, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("could not read the csv header: %w", err)
+	}
+	for i := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(header[i]))
+	}
+	if len(header) < len(csvHeader) || !slices.Equal(header[:len(csvHeader)], csvHeader) {
+		return nil, fmt.Errorf("the csv header should start with %s", strings.Join(csvHeader, ","))
+	}
+	withCapacity := len(header) > len(csvHeader) && header[len(csvHeader)] == "capacity_bytes"
+
+	b := newSeriesBuilder()
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) < len(csvHeader) {
+			return nil, fmt.Errorf("line %d: expected at least %d columns", line, len(csvHeader))
+		}
+
+		timestamp, err := parseTimestamp(record[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid timestamp: %w", line, err)
+		}
+		used, err := strconv.ParseInt(record[3], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid used bytes: %w", line, err)
+		}
+		key := types.NamespacedName{Namespace: record[1], Name: record[2]}
+		b.addUsed(key, timestamp, used)
+
+		if withCapacity && len(record) > len(csvHeader) && record[len(csvHeader)] != "" {
+			capacity, err := strconv.ParseInt(record[len(csvHeader)], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid capacity bytes: %w", line, err)
+			}
+			b.addCapacity(key, timestamp, capacity)
+		}
+	}
+
+	return b.build(), nil
+}
+
+// ReadPrometheus reads series from the JSON response of a Prometheus range
+// query (/api/v1/query_range) of kubelet_volume_stats_used_bytes. The
+// response may also contain kubelet_volume_stats_capacity_bytes, e.g. with
+// the query {__name__=~"kubelet_volume_stats_(used|capacity)_bytes"}.
+func ReadPrometheus(r io.Reader) ([]Series, error) {
+	var response struct {
+		Status string `json:"status"`
+		Data   struct {
+			ResultType string       `json:"resultType"`
+			Result     model.Matrix `json:"result"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(r).Decode(&response); err != nil {
+		return nil, fmt.Errorf("could not parse the prometheus response: %w", err)
+	}
+	if response.Status != "success" {
+		return nil, fmt.Errorf("the prometheus response status is %q", response.Status)
+	}
+	if response.Data.ResultType != "matrix" {
+		return nil, fmt.Errorf("expected a range query result, got %q", response.Data.ResultType)
+	}
+
+	b := newSeriesBuilder()
+	for _, stream := range response.Data.Result {
+		key := types.NamespacedName{
+			Namespace: string(stream.Metric["namespace"]),
+			Name:      string(stream.Metric["persistentvolumeclaim"]),
+		}
+		isCapacity := stream.Metric[model.MetricNameLabel] == capacityBytesMetric
+
+		for _, value := range stream.Values {
+			if isCapacity {
+				b.addCapacity(key, value.Timestamp.Time(), int64(value.Value))
+			} else {
+				b.addUsed(key, value.Timestamp.Time(), int64(value.Value))
+			}
+		}
+	}
+
+	return b.build(), nil
+}
+
+func parseTimestamp(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.UnixMilli(int64(seconds * 1000)).UTC(), nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
+
+type seriesBuilder struct {
+	series map[types.NamespacedName]*Series
+	// firstCapacity is the time of the capacity sample used as initial one
+	firstCapacity map[types.NamespacedName]time.Time
+}
+
+func newSeriesBuilder() *seriesBuilder {
+	return &seriesBuilder{
+		series:        make(map[types.NamespacedName]*Series),
+		firstCapacity: make(map[types.NamespacedName]time.Time),
+	}
+}
+
+func (b *seriesBuilder) get(key types.NamespacedName) *Series {
+	s, ok := b.series[key]
+	if !ok {
+		s = &Series{PVC: key}
+		b.series[key] = s
+	}
+
+	return s
+}
+
+func (b *seriesBuilder) addUsed(key types.NamespacedName, t time.Time, used int64) {
+	s := b.get(key)
+	s.Samples = append(s.Samples, Sample{Time: t, UsedBytes: used})
+}
+
+func (b *seriesBuilder) addCapacity(key types.NamespacedName, t time.Time, capacity int64) {
+	s := b.get(key)
+	if first, ok := b.firstCapacity[key]; !ok || t.Before(first) {
+		b.firstCapacity[key] = t
+		s.InitialCapacityBytes = capacity
+	}
+}
+
+// build returns the series with samples sorted by PVC
+func (b *seriesBuilder) build() []Series {
+	series := make([]Series, 0, len(b.series))
+	for _, s := range b.series {
+		if len(s.Samples) == 0 {
+			continue
+		}
+		s.Samples = sortSamples(s.Samples)
+		series = append(series, *s)
+	}
+	slices.SortFunc(series, func(x, y Series) int {
+		return strings.Compare(x.PVC.String(), y.PVC.String())
+	})
+
+	return series
+}
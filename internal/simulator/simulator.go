@@ -0,0 +1,236 @@
+// Package simulator replays historical usage of PVCs through the decision
+// logic of the autoscaler to preview the effect of a policy
+package simulator
+
+import (
+	"slices"
+	"strconv"
+	"time"
+
+	clients "github.com/lorenzophys/pvc-autoscaler/internal/metrics_clients/clients"
+	"github.com/lorenzophys/pvc-autoscaler/internal/policy"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+type EventType string
+
+const (
+	EventResizeRequested EventType = "resize_requested"
+	EventResizeCompleted EventType = "resize_completed"
+	// EventFull is recorded when the usage reaches the capacity
+	EventFull EventType = "full"
+	// EventRecovered is recorded when the usage goes back below the capacity
+	EventRecovered EventType = "recovered"
+)
+
+type Sample struct {
+	Time      time.Time
+	UsedBytes int64
+}
+
+// Series is the usage of a PVC over time
+type Series struct {
+	PVC types.NamespacedName
+	// InitialCapacityBytes is the capacity at the first sample, 0 when unknown
+	InitialCapacityBytes int64
+	Samples              []Sample
+}
+
+type Options struct {
+	// Config.Now is replaced by the time of each sample
+	Config policy.Config
+	// Annotations are set on every simulated PVC, e.g. the threshold
+	Annotations map[string]string
+	// PollingInterval is the time between two evaluations, every sample is
+	// evaluated when 0
+	PollingInterval time.Duration
+	// ResizeLatency is the time the CSI driver takes to expand a volume
+	ResizeLatency time.Duration
+	// Cooldown is the minimum time between two volume modifications the
+	// provider allows, e.g. 6h on EBS
+	Cooldown time.Duration
+}
+
+type Event struct {
+	Time         time.Time `json:"time"`
+	Type         EventType `json:"type"`
+	OldSizeBytes int64     `json:"oldSizeBytes,omitempty"`
+	NewSizeBytes int64     `json:"newSizeBytes,omitempty"`
+	UsedBytes    int64     `json:"usedBytes"`
+}
+
+type Result struct {
+	PVC              types.NamespacedName
+	InitialSizeBytes int64
+	FinalSizeBytes   int64
+	Resizes          int
+	// FullDuration is the total time the volume was full
+	FullDuration time.Duration
+	Events       []Event
+	// Decisions counts the evaluations by reason
+	Decisions map[policy.Reason]int
+	// LastReason is the reason of the last evaluation
+	LastReason policy.Reason
+}
+
+type pendingResize struct {
+	newSize resource.Quantity
+	readyAt time.Time
+}
+
+// Run replays the samples of the series, they must be sorted by time
+func Run(series Series, opts Options) Result {
+	res := Result{
+		PVC:              series.PVC,
+		InitialSizeBytes: series.InitialCapacityBytes,
+		FinalSizeBytes:   series.InitialCapacityBytes,
+		Decisions:        make(map[policy.Reason]int),
+	}
+	if len(series.Samples) == 0 || series.InitialCapacityBytes == 0 {
+		return res
+	}
+
+	pvc, sc := newPVC(series, opts.Annotations)
+	capacity := *resource.NewQuantity(series.InitialCapacityBytes, resource.BinarySI)
+	setCapacity := func(q resource.Quantity) {
+		capacity = q
+		pvc.Status.Capacity[corev1.ResourceStorage] = q
+	}
+
+	var (
+		pending          *pendingResize
+		lastModification time.Time
+		fullSince        time.Time
+		nextEvaluation   = series.Samples[0].Time
+	)
+
+	for _, sample := range series.Samples {
+		if pending != nil && !sample.Time.Before(pending.readyAt) {
+			res.Events = append(res.Events, Event{
+				Time:         pending.readyAt,
+				Type:         EventResizeCompleted,
+				OldSizeBytes: capacity.Value(),
+				NewSizeBytes: pending.newSize.Value(),
+				UsedBytes:    sample.UsedBytes,
+			})
+			setCapacity(pending.newSize)
+			pending = nil
+		}
+
+		// The usage cannot exceed the capacity, the writes fail instead
+		used := min(sample.UsedBytes, capacity.Value())
+		full := sample.UsedBytes >= capacity.Value()
+		switch {
+		case full && fullSince.IsZero():
+			fullSince = sample.Time
+			res.Events = append(res.Events, Event{Time: sample.Time, Type: EventFull, UsedBytes: sample.UsedBytes, OldSizeBytes: capacity.Value()})
+		case !full && !fullSince.IsZero():
+			res.FullDuration += sample.Time.Sub(fullSince)
+			fullSince = time.Time{}
+			res.Events = append(res.Events, Event{Time: sample.Time, Type: EventRecovered, UsedBytes: sample.UsedBytes, OldSizeBytes: capacity.Value()})
+		}
+
+		if opts.PollingInterval > 0 && sample.Time.Before(nextEvaluation) {
+			continue
+		}
+		if opts.PollingInterval > 0 {
+			for !nextEvaluation.After(sample.Time) {
+				nextEvaluation = nextEvaluation.Add(opts.PollingInterval)
+			}
+		}
+
+		metrics := &clients.PVCMetrics{VolumeUsedBytes: used, VolumeCapacityBytes: capacity.Value()}
+		cfg := opts.Config
+		cfg.Now = sample.Time
+		evaluation := policy.Evaluate(pvc, sc, metrics, cfg)
+		res.Decisions[evaluation.Reason()]++
+		res.LastReason = evaluation.Reason()
+
+		resize, ok := evaluation.Decision.(policy.Resize)
+		if !ok {
+			continue
+		}
+
+		// Same bookkeeping as the autoscaler
+		pvc.Annotations[policy.PreviousCapacityAnnotation] = strconv.FormatInt(capacity.Value(), 10)
+		pvc.Spec.Resources.Requests[corev1.ResourceStorage] = resize.NewSize
+
+		start := sample.Time
+		if !lastModification.IsZero() && start.Before(lastModification.Add(opts.Cooldown)) {
+			start = lastModification.Add(opts.Cooldown)
+		}
+		lastModification = start
+		pending = &pendingResize{newSize: resize.NewSize, readyAt: start.Add(opts.ResizeLatency)}
+
+		res.Resizes++
+		res.Events = append(res.Events, Event{
+			Time:         sample.Time,
+			Type:         EventResizeRequested,
+			OldSizeBytes: capacity.Value(),
+			NewSizeBytes: resize.NewSize.Value(),
+			UsedBytes:    sample.UsedBytes,
+		})
+	}
+
+	if last := series.Samples[len(series.Samples)-1]; !fullSince.IsZero() {
+		res.FullDuration += last.Time.Sub(fullSince)
+	}
+
+	res.FinalSizeBytes = capacity.Value()
+	if pending != nil {
+		// Completed after the last sample
+		res.FinalSizeBytes = pending.newSize.Value()
+	}
+
+	return res
+}
+
+func newPVC(series Series, annotations map[string]string) (*corev1.PersistentVolumeClaim, *storagev1.StorageClass) {
+	allowExpansion := true
+	sc := &storagev1.StorageClass{
+		ObjectMeta:           metav1.ObjectMeta{Name: "simulated"},
+		AllowVolumeExpansion: &allowExpansion,
+	}
+
+	capacity := *resource.NewQuantity(series.InitialCapacityBytes, resource.BinarySI)
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: series.PVC.Namespace,
+			Name:      series.PVC.Name,
+			Annotations: map[string]string{
+				policy.EnabledAnnotation:      "true",
+				policy.OriginalSizeAnnotation: capacity.String(),
+			},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			StorageClassName: &sc.Name,
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: capacity},
+			},
+		},
+		Status: corev1.PersistentVolumeClaimStatus{
+			Phase:    corev1.ClaimBound,
+			Capacity: corev1.ResourceList{corev1.ResourceStorage: capacity},
+		},
+	}
+	for key, value := range annotations {
+		pvc.Annotations[key] = value
+	}
+
+	return pvc, sc
+}
+
+// sortSamples sorts the samples by time and drops the duplicated timestamps
+func sortSamples(samples []Sample) []Sample {
+	slices.SortStableFunc(samples, func(x, y Sample) int {
+		return x.Time.Compare(y.Time)
+	})
+
+	return slices.CompactFunc(samples, func(x, y Sample) bool {
+		return x.Time.Equal(y.Time)
+	})
+}
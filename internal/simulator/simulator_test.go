@@ -0,0 +1,194 @@
+package simulator
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lorenzophys/pvc-autoscaler/internal/policy"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var (
+	testPVC   = types.NamespacedName{Namespace: "default", Name: "data"}
+	testStart = time.Unix(1700000000, 0).UTC()
+)
+
+// newLinearSeries returns a 10Gi PVC with one sample per minute, the usage
+// starts at startGi and grows by stepGi every minute
+func newLinearSeries(samples int, startGi, stepGi float64) Series {
+	series := Series{PVC: testPVC, InitialCapacityBytes: 10 << 30}
+	for i := 0; i < samples; i++ {
+		series.Samples = append(series.Samples, Sample{
+			Time:      testStart.Add(time.Duration(i) * time.Minute),
+			UsedBytes: int64((startGi + float64(i)*stepGi) * (1 << 30)),
+		})
+	}
+
+	return series
+}
+
+func newTestOptions() Options {
+	return Options{
+		Config:      policy.Config{DefaultThreshold: "80%", DefaultIncrease: "20%"},
+		Annotations: map[string]string{policy.CeilingAnnotation: "20Gi"},
+	}
+}
+
+func eventTypes(events []Event) []EventType {
+	var types []EventType
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+func TestRun(t *testing.T) {
+	t.Run("resizes with latency", func(t *testing.T) {
+		opts := newTestOptions()
+		opts.ResizeLatency = 3 * time.Minute
+
+		res := Run(newLinearSeries(10, 7, 0.5), opts)
+
+		assert.Equal(t, 2, res.Resizes)
+		assert.Equal(t, int64(10<<30), res.InitialSizeBytes)
+		assert.Equal(t, int64(15<<30), res.FinalSizeBytes)
+		assert.Equal(t, []EventType{EventResizeRequested, EventResizeCompleted, EventResizeRequested, EventResizeCompleted}, eventTypes(res.Events))
+		assert.Equal(t, testStart.Add(2*time.Minute), res.Events[0].Time)
+		assert.Equal(t, int64(12<<30), res.Events[0].NewSizeBytes)
+		assert.Equal(t, testStart.Add(5*time.Minute), res.Events[1].Time)
+		assert.Equal(t, 4, res.Decisions[policy.ReasonResizePending])
+		assert.Zero(t, res.FullDuration)
+	})
+
+	t.Run("volume full while waiting for the resize", func(t *testing.T) {
+		opts := newTestOptions()
+		opts.ResizeLatency = 10 * time.Minute
+
+		res := Run(newLinearSeries(6, 7, 1), opts)
+
+		assert.Equal(t, 1, res.Resizes)
+		assert.Equal(t, []EventType{EventResizeRequested, EventFull}, eventTypes(res.Events))
+		assert.Equal(t, testStart.Add(3*time.Minute), res.Events[1].Time)
+		assert.Equal(t, 2*time.Minute, res.FullDuration)
+		// Completed after the last sample
+		assert.Equal(t, int64(12<<30), res.FinalSizeBytes)
+	})
+
+	t.Run("cooldown delays the next modification", func(t *testing.T) {
+		opts := newTestOptions()
+		opts.Cooldown = time.Hour
+
+		res := Run(newLinearSeries(5, 8, 1), opts)
+
+		// The second resize can only start an hour after the first one
+		assert.Equal(t, 2, res.Resizes)
+		assert.Equal(t, []EventType{EventResizeRequested, EventResizeCompleted, EventResizeRequested, EventFull}, eventTypes(res.Events))
+		assert.Equal(t, testStart.Add(4*time.Minute), res.Events[3].Time)
+		assert.Equal(t, int64(15<<30), res.FinalSizeBytes)
+	})
+
+	t.Run("polling interval", func(t *testing.T) {
+		opts := newTestOptions()
+		opts.PollingInterval = 5 * time.Minute
+
+		res := Run(newLinearSeries(10, 7, 0.5), opts)
+
+		total := 0
+		for _, count := range res.Decisions {
+			total += count
+		}
+		assert.Equal(t, 2, total)
+		assert.Equal(t, 1, res.Resizes)
+		assert.Equal(t, 1, res.Decisions[policy.ReasonBelowThreshold])
+	})
+
+	t.Run("resize window at the time of the samples", func(t *testing.T) {
+		opts := newTestOptions()
+		// testStart is 22:13 UTC
+		opts.Annotations[policy.ResizeWindowAnnotation] = "15 22 * * * 1h"
+		opts.ResizeLatency = time.Hour
+
+		res := Run(newLinearSeries(4, 7, 1), opts)
+
+		assert.Equal(t, 1, res.Resizes)
+		assert.Equal(t, 1, res.Decisions[policy.ReasonOutsideResizeWindow])
+		assert.Equal(t, testStart.Add(2*time.Minute), res.Events[0].Time)
+	})
+
+	t.Run("invalid policy", func(t *testing.T) {
+		opts := newTestOptions()
+		opts.Annotations[policy.ThresholdAnnotation] = "80"
+
+		res := Run(newLinearSeries(3, 9, 0), opts)
+
+		assert.Equal(t, 0, res.Resizes)
+		assert.Equal(t, 3, res.Decisions[policy.ReasonInvalidThreshold])
+	})
+}
+
+func TestReadCSV(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		input := `timestamp,namespace,pvc,used_bytes,capacity_bytes
+2024-01-01T00:01:00Z,default,data,200,1000
+2024-01-01T00:00:00Z,default,data,100,1000
+1704067200,other,logs,50,
+`
+		series, err := ReadCSV(strings.NewReader(input))
+
+		assert.NoError(t, err)
+		assert.Len(t, series, 2)
+		assert.Equal(t, testPVC, series[0].PVC)
+		assert.Equal(t, int64(1000), series[0].InitialCapacityBytes)
+		assert.Equal(t, []Sample{
+			{Time: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), UsedBytes: 100},
+			{Time: time.Date(2024, 1, 1, 0, 1, 0, 0, time.UTC), UsedBytes: 200},
+		}, series[0].Samples)
+		assert.Equal(t, int64(0), series[1].InitialCapacityBytes)
+		assert.True(t, series[1].Samples[0].Time.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)))
+	})
+
+	t.Run("invalid header", func(t *testing.T) {
+		_, err := ReadCSV(strings.NewReader("time,pvc,used\n"))
+
+		assert.Error(t, err)
+	})
+
+	t.Run("invalid value", func(t *testing.T) {
+		_, err := ReadCSV(strings.NewReader("timestamp,namespace,pvc,used_bytes\nyesterday,default,data,100\n"))
+
+		assert.ErrorContains(t, err, "line 2")
+	})
+}
+
+func TestReadPrometheus(t *testing.T) {
+	input := `{
+  "status": "success",
+  "data": {
+    "resultType": "matrix",
+    "result": [
+      {
+        "metric": {"__name__": "kubelet_volume_stats_used_bytes", "namespace": "default", "persistentvolumeclaim": "data"},
+        "values": [[1704067200, "100"], [1704067260, "200"]]
+      },
+      {
+        "metric": {"__name__": "kubelet_volume_stats_capacity_bytes", "namespace": "default", "persistentvolumeclaim": "data"},
+        "values": [[1704067200, "1000"], [1704067260, "2000"]]
+      }
+    ]
+  }
+}`
+
+	series, err := ReadPrometheus(strings.NewReader(input))
+
+	assert.NoError(t, err)
+	assert.Len(t, series, 1)
+	assert.Equal(t, testPVC, series[0].PVC)
+	assert.Equal(t, int64(1000), series[0].InitialCapacityBytes)
+	assert.Len(t, series[0].Samples, 2)
+	assert.Equal(t, int64(200), series[0].Samples[1].UsedBytes)
+
+	_, err = ReadPrometheus(strings.NewReader(`{"status": "success", "data": {"resultType": "vector", "result": []}}`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,301 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/lorenzophys/pvc-autoscaler/internal/policy"
+	log "github.com/sirupsen/logrus"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// Rule describes which PVCs get autoscaling enabled on creation and with
+// which values. When both StorageClassNames and NamespaceSelector are set
+// the PVC must match both.
+type Rule struct {
+	StorageClassNames []string              `json:"storageClassNames,omitempty"`
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	Threshold         string                `json:"threshold,omitempty"`
+	Increase          string                `json:"increase,omitempty"`
+	Ceiling           string                `json:"ceiling,omitempty"`
+	Tiers             string                `json:"tiers,omitempty"`
+	AttributesClasses string                `json:"attributesClasses,omitempty"`
+}
+
+type Config struct {
+	Rules []Rule `json:"rules"`
+}
+
+type patchOperation struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value any    `json:"value,omitempty"`
+}
+
+type Mutator struct {
+	// kubeClient returns the current client, it is rebuilt on config reloads
+	kubeClient       func() kubernetes.Interface
+	rules            []Rule
+	annotationPrefix string
+	logger           *log.Logger
+}
+
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &Config{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("could not parse webhook config: %w", err)
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+func (c *Config) Validate() error {
+	for i, rule := range c.Rules {
+		if len(rule.StorageClassNames) == 0 && rule.NamespaceSelector == nil {
+			return fmt.Errorf("rule %d: at least one of storageClassNames and namespaceSelector must be set", i)
+		}
+		if rule.NamespaceSelector != nil {
+			if _, err := metav1.LabelSelectorAsSelector(rule.NamespaceSelector); err != nil {
+				return fmt.Errorf("rule %d: invalid namespaceSelector: %w", i, err)
+			}
+		}
+		if rule.Threshold != "" {
+			if _, err := policy.ParsePercentage(rule.Threshold); err != nil {
+				return fmt.Errorf("rule %d: invalid threshold: %w", i, err)
+			}
+		}
+		if rule.Increase != "" {
+			if _, err := policy.ParsePercentage(rule.Increase); err != nil {
+				return fmt.Errorf("rule %d: invalid increase: %w", i, err)
+			}
+		}
+		if factor, ok := strings.CutSuffix(rule.Ceiling, "x"); ok {
+			if _, err := policy.ParseCeilingFactor(factor); err != nil {
+				return fmt.Errorf("rule %d: invalid ceiling: %w", i, err)
+			}
+		} else if rule.Ceiling != "" {
+			if _, err := resource.ParseQuantity(rule.Ceiling); err != nil {
+				return fmt.Errorf("rule %d: invalid ceiling: %w", i, err)
+			}
+		}
+		if rule.Tiers != "" {
+			if _, err := policy.ParseTiers(rule.Tiers); err != nil {
+				return fmt.Errorf("rule %d: invalid tiers: %w", i, err)
+			}
+		}
+		if rule.AttributesClasses != "" {
+			if _, err := policy.ParseAttributesClassBands(rule.AttributesClasses); err != nil {
+				return fmt.Errorf("rule %d: invalid attributes classes: %w", i, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func NewMutator(kubeClient func() kubernetes.Interface, config *Config, annotationPrefix string, logger *log.Logger) *Mutator {
+	return &Mutator{
+		kubeClient:       kubeClient,
+		rules:            config.Rules,
+		annotationPrefix: annotationPrefix,
+		logger:           logger,
+	}
+}
+
+func (m *Mutator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("could not read request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	review := &admissionv1.AdmissionReview{}
+	if err := json.Unmarshal(body, review); err != nil {
+		http.Error(w, fmt.Sprintf("could not decode admission review: %v", err), http.StatusBadRequest)
+		return
+	}
+	if review.Request == nil {
+		http.Error(w, "admission review without request", http.StatusBadRequest)
+		return
+	}
+
+	response := m.mutate(r.Context(), review.Request)
+	response.UID = review.Request.UID
+
+	review.Response = response
+	review.Request = nil
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(review); err != nil {
+		m.logger.Errorf("could not encode admission response: %v", err)
+	}
+}
+
+func (m *Mutator) mutate(ctx context.Context, req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	allowed := &admissionv1.AdmissionResponse{Allowed: true}
+
+	if req.Kind.Kind != "PersistentVolumeClaim" || req.Operation != admissionv1.Create {
+		return allowed
+	}
+
+	pvc := &corev1.PersistentVolumeClaim{}
+	if err := json.Unmarshal(req.Object.Raw, pvc); err != nil {
+		// Never block the creation of a PVC because of the autoscaler
+		m.logger.Errorf("could not decode PersistentVolumeClaim from admission request: %v", err)
+		return allowed
+	}
+	if pvc.Namespace == "" {
+		pvc.Namespace = req.Namespace
+	}
+
+	enabledAnnotation := m.annotationPrefix + "enabled"
+	if value, ok := pvc.Annotations[enabledAnnotation]; ok && value != "true" {
+		m.logger.Debugf("pvc %s/%s explicitly opted out of autoscaling", pvc.Namespace, pvc.Name)
+		return allowed
+	}
+
+	rule, err := m.matchRule(ctx, pvc)
+	if err != nil {
+		m.logger.Errorf("could not match webhook rules for pvc %s/%s: %v", pvc.Namespace, pvc.Name, err)
+		return allowed
+	}
+	if rule == nil {
+		return allowed
+	}
+
+	toInject := map[string]string{
+		enabledAnnotation:                         "true",
+		m.annotationPrefix + "threshold":          rule.Threshold,
+		m.annotationPrefix + "increase":           rule.Increase,
+		m.annotationPrefix + "ceiling":            rule.Ceiling,
+		m.annotationPrefix + "tiers":              rule.Tiers,
+		m.annotationPrefix + "attributes-classes": rule.AttributesClasses,
+	}
+
+	patch := buildAnnotationsPatch(pvc.Annotations, toInject)
+	if len(patch) == 0 {
+		return allowed
+	}
+
+	patchBytes, err := json.Marshal(patch)
+	if err != nil {
+		m.logger.Errorf("could not encode patch for pvc %s/%s: %v", pvc.Namespace, pvc.Name, err)
+		return allowed
+	}
+	m.logger.Infof("injecting autoscaling annotations into pvc %s/%s", pvc.Namespace, pvc.Name)
+
+	patchType := admissionv1.PatchTypeJSONPatch
+	allowed.Patch = patchBytes
+	allowed.PatchType = &patchType
+
+	return allowed
+}
+
+// matchRule returns the first rule matching the PVC, or nil if none does
+func (m *Mutator) matchRule(ctx context.Context, pvc *corev1.PersistentVolumeClaim) (*Rule, error) {
+	var namespaceLabels labels.Set
+	namespaceFetched := false
+
+	for i := range m.rules {
+		rule := &m.rules[i]
+
+		if len(rule.StorageClassNames) > 0 {
+			if pvc.Spec.StorageClassName == nil || !slices.Contains(rule.StorageClassNames, *pvc.Spec.StorageClassName) {
+				continue
+			}
+		}
+
+		if rule.NamespaceSelector != nil {
+			if !namespaceFetched {
+				ns, err := m.kubeClient().CoreV1().Namespaces().Get(ctx, pvc.Namespace, metav1.GetOptions{})
+				if err != nil {
+					return nil, fmt.Errorf("could not get namespace %s: %w", pvc.Namespace, err)
+				}
+				namespaceLabels = labels.Set(ns.Labels)
+				namespaceFetched = true
+			}
+
+			selector, err := metav1.LabelSelectorAsSelector(rule.NamespaceSelector)
+			if err != nil {
+				return nil, err
+			}
+			if !selector.Matches(namespaceLabels) {
+				continue
+			}
+		}
+
+		return rule, nil
+	}
+
+	return nil, nil
+}
+
+// buildAnnotationsPatch returns the JSON patch adding the missing annotations,
+// leaving the ones set by the user untouched
+func buildAnnotationsPatch(existing map[string]string, toInject map[string]string) []patchOperation {
+	missing := make(map[string]string)
+	for key, value := range toInject {
+		if value == "" {
+			continue
+		}
+		if _, ok := existing[key]; ok {
+			continue
+		}
+		missing[key] = value
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	if existing == nil {
+		return []patchOperation{{Op: "add", Path: "/metadata/annotations", Value: missing}}
+	}
+
+	keys := make([]string, 0, len(missing))
+	for key := range missing {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	patch := make([]patchOperation, 0, len(keys))
+	for _, key := range keys {
+		patch = append(patch, patchOperation{
+			Op:    "add",
+			Path:  "/metadata/annotations/" + escapeJSONPointer(key),
+			Value: missing[key],
+		})
+	}
+
+	return patch
+}
+
+func escapeJSONPointer(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~", "~0"), "/", "~1")
+}
@@ -0,0 +1,319 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const testPrefix = "pvc-autoscaler.lorenzophys.io/"
+
+func newTestServer(t *testing.T, config *Config, objects ...runtime.Object) *httptest.Server {
+	t.Helper()
+
+	logger := log.New()
+	logger.Out = io.Discard
+
+	kubeClient := fake.NewSimpleClientset(objects...)
+	mutator := NewMutator(func() kubernetes.Interface { return kubeClient }, config, testPrefix, logger)
+	ts := httptest.NewTLSServer(mutator)
+	t.Cleanup(ts.Close)
+
+	return ts
+}
+
+func newAdmissionReview(t *testing.T, pvc *corev1.PersistentVolumeClaim, operation admissionv1.Operation) []byte {
+	t.Helper()
+
+	raw, err := json.Marshal(pvc)
+	assert.NoError(t, err)
+
+	review := admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "AdmissionReview",
+			APIVersion: "admission.k8s.io/v1",
+		},
+		Request: &admissionv1.AdmissionRequest{
+			UID:       types.UID("test-uid"),
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "PersistentVolumeClaim"},
+			Resource:  metav1.GroupVersionResource{Version: "v1", Resource: "persistentvolumeclaims"},
+			Namespace: pvc.Namespace,
+			Operation: operation,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	}
+
+	body, err := json.Marshal(review)
+	assert.NoError(t, err)
+
+	return body
+}
+
+func sendReview(t *testing.T, ts *httptest.Server, body []byte) *admissionv1.AdmissionResponse {
+	t.Helper()
+
+	resp, err := ts.Client().Post(ts.URL, "application/json", bytes.NewReader(body))
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	review := &admissionv1.AdmissionReview{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(review))
+	assert.NotNil(t, review.Response)
+	assert.Equal(t, types.UID("test-uid"), review.Response.UID)
+
+	return review.Response
+}
+
+func decodePatch(t *testing.T, response *admissionv1.AdmissionResponse) []patchOperation {
+	t.Helper()
+
+	var patch []patchOperation
+	assert.NoError(t, json.Unmarshal(response.Patch, &patch))
+
+	return patch
+}
+
+func newPVC(namespace, storageClass string, annotations map[string]string) *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "mypvc",
+			Namespace:   namespace,
+			Annotations: annotations,
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			StorageClassName: &storageClass,
+		},
+	}
+}
+
+func TestMutator(t *testing.T) {
+	config := &Config{
+		Rules: []Rule{
+			{
+				StorageClassNames: []string{"gp3-expandable"},
+				Threshold:         "75%",
+				Increase:          "25%",
+				Ceiling:           "100Gi",
+			},
+			{
+				NamespaceSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"pvc-autoscaler": "enabled"},
+				},
+				Threshold: "90%",
+			},
+		},
+	}
+	labeledNamespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "labeled",
+			Labels: map[string]string{"pvc-autoscaler": "enabled"},
+		},
+	}
+	plainNamespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "default"},
+	}
+
+	t.Run("storage class match without annotations", func(t *testing.T) {
+		ts := newTestServer(t, config, plainNamespace)
+
+		response := sendReview(t, ts, newAdmissionReview(t, newPVC("default", "gp3-expandable", nil), admissionv1.Create))
+
+		assert.True(t, response.Allowed)
+		assert.Equal(t, admissionv1.PatchTypeJSONPatch, *response.PatchType)
+		assert.Equal(t, []patchOperation{
+			{
+				Op:   "add",
+				Path: "/metadata/annotations",
+				Value: map[string]any{
+					testPrefix + "enabled":   "true",
+					testPrefix + "threshold": "75%",
+					testPrefix + "increase":  "25%",
+					testPrefix + "ceiling":   "100Gi",
+				},
+			},
+		}, decodePatch(t, response))
+	})
+
+	t.Run("explicit values are not overwritten", func(t *testing.T) {
+		ts := newTestServer(t, config, plainNamespace)
+
+		pvc := newPVC("default", "gp3-expandable", map[string]string{
+			testPrefix + "threshold": "50%",
+			"foo":                    "bar",
+		})
+		response := sendReview(t, ts, newAdmissionReview(t, pvc, admissionv1.Create))
+
+		assert.True(t, response.Allowed)
+		assert.Equal(t, []patchOperation{
+			{Op: "add", Path: "/metadata/annotations/pvc-autoscaler.lorenzophys.io~1ceiling", Value: "100Gi"},
+			{Op: "add", Path: "/metadata/annotations/pvc-autoscaler.lorenzophys.io~1enabled", Value: "true"},
+			{Op: "add", Path: "/metadata/annotations/pvc-autoscaler.lorenzophys.io~1increase", Value: "25%"},
+		}, decodePatch(t, response))
+	})
+
+	t.Run("namespace selector match", func(t *testing.T) {
+		ts := newTestServer(t, config, labeledNamespace)
+
+		pvc := newPVC("labeled", "standard", map[string]string{"foo": "bar"})
+		response := sendReview(t, ts, newAdmissionReview(t, pvc, admissionv1.Create))
+
+		assert.True(t, response.Allowed)
+		assert.Equal(t, []patchOperation{
+			{Op: "add", Path: "/metadata/annotations/pvc-autoscaler.lorenzophys.io~1enabled", Value: "true"},
+			{Op: "add", Path: "/metadata/annotations/pvc-autoscaler.lorenzophys.io~1threshold", Value: "90%"},
+		}, decodePatch(t, response))
+	})
+
+	t.Run("no rule matches", func(t *testing.T) {
+		ts := newTestServer(t, config, plainNamespace)
+
+		response := sendReview(t, ts, newAdmissionReview(t, newPVC("default", "standard", nil), admissionv1.Create))
+
+		assert.True(t, response.Allowed)
+		assert.Nil(t, response.Patch)
+	})
+
+	t.Run("explicit opt out", func(t *testing.T) {
+		ts := newTestServer(t, config, plainNamespace)
+
+		pvc := newPVC("default", "gp3-expandable", map[string]string{testPrefix + "enabled": "false"})
+		response := sendReview(t, ts, newAdmissionReview(t, pvc, admissionv1.Create))
+
+		assert.True(t, response.Allowed)
+		assert.Nil(t, response.Patch)
+	})
+
+	t.Run("updates are ignored", func(t *testing.T) {
+		ts := newTestServer(t, config, plainNamespace)
+
+		response := sendReview(t, ts, newAdmissionReview(t, newPVC("default", "gp3-expandable", nil), admissionv1.Update))
+
+		assert.True(t, response.Allowed)
+		assert.Nil(t, response.Patch)
+	})
+
+	t.Run("missing namespace does not block creation", func(t *testing.T) {
+		ts := newTestServer(t, config)
+
+		response := sendReview(t, ts, newAdmissionReview(t, newPVC("missing", "standard", nil), admissionv1.Create))
+
+		assert.True(t, response.Allowed)
+		assert.Nil(t, response.Patch)
+	})
+
+	t.Run("malformed review", func(t *testing.T) {
+		ts := newTestServer(t, config)
+
+		resp, err := ts.Client().Post(ts.URL, "application/json", bytes.NewReader([]byte("{")))
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
+
+func TestLoadConfig(t *testing.T) {
+	writeConfig := func(t *testing.T, content string) string {
+		path := filepath.Join(t.TempDir(), "rules.yaml")
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		return path
+	}
+
+	t.Run("valid config", func(t *testing.T) {
+		path := writeConfig(t, `
+rules:
+  - storageClassNames: ["gp3-expandable"]
+    threshold: 80%
+    increase: 20%
+    ceiling: 50Gi
+`)
+		config, err := LoadConfig(path)
+
+		assert.NoError(t, err)
+		assert.Len(t, config.Rules, 1)
+		assert.Equal(t, "50Gi", config.Rules[0].Ceiling)
+	})
+
+	t.Run("rule without matcher", func(t *testing.T) {
+		path := writeConfig(t, `
+rules:
+  - threshold: 80%
+`)
+		_, err := LoadConfig(path)
+
+		assert.Error(t, err)
+	})
+
+	t.Run("invalid threshold", func(t *testing.T) {
+		path := writeConfig(t, `
+rules:
+  - storageClassNames: ["gp3-expandable"]
+    threshold: "120%"
+`)
+		_, err := LoadConfig(path)
+
+		assert.Error(t, err)
+	})
+
+	t.Run("not a number increase", func(t *testing.T) {
+		path := writeConfig(t, `
+rules:
+  - storageClassNames: ["gp3-expandable"]
+    increase: NaN%
+`)
+		_, err := LoadConfig(path)
+
+		assert.ErrorContains(t, err, "rule 0: invalid increase")
+	})
+
+	t.Run("relative ceiling", func(t *testing.T) {
+		path := writeConfig(t, `
+rules:
+  - storageClassNames: ["gp3-expandable"]
+    ceiling: 4x
+  - storageClassNames: ["gp2"]
+    ceiling: 0.5x
+`)
+		_, err := LoadConfig(path)
+
+		assert.ErrorContains(t, err, "rule 1: invalid ceiling")
+	})
+
+	t.Run("invalid tiers", func(t *testing.T) {
+		path := writeConfig(t, `
+rules:
+  - storageClassNames: ["gp3-expandable"]
+    tiers: 90%:30%,75%:10%
+`)
+		_, err := LoadConfig(path)
+
+		assert.ErrorContains(t, err, "invalid tiers")
+	})
+
+	t.Run("unknown field", func(t *testing.T) {
+		path := writeConfig(t, `
+rules:
+  - storageClassNames: ["gp3-expandable"]
+    treshold: 80%
+`)
+		_, err := LoadConfig(path)
+
+		assert.Error(t, err)
+	})
+}